@@ -10,21 +10,23 @@ func (c Cap) String() string { return c.Name }
 var (
 	None = Cap{}
 
-	AccountNotify    = Cap{Name: "account-notify"}
-	AccountTag       = Cap{Name: "account-tag"}
-	AwayNotify       = Cap{Name: "away-notify"}
-	Batch            = Cap{Name: "batch"}
-	CapNotify        = Cap{Name: "cap-notify"}
-	EchoMessage      = Cap{Name: "echo-message"}
-	ExtendedJoin     = Cap{Name: "extended-join"}
-	ExtendedMonitor  = Cap{Name: "extended-monitor"}
-	InviteNotify     = Cap{Name: "invite-notify"}
-	LabeledResponses = Cap{Name: "labeled-response"}
-	MessageTags      = Cap{Name: "message-tags"}
-	MultiPrefix      = Cap{Name: "multi-prefix"}
-	SASL             = Cap{Name: "sasl", Value: "PLAIN,EXTERNAL,SCRAM-SHA-256"}
-	ServerTime       = Cap{Name: "server-time"}
-	Setname          = Cap{Name: "setname"}
-	STS              = Cap{Name: "sts", Value: "port=%s,duration=%.f"}
-	UserhostInNames  = Cap{Name: "userhost-in-names"}
+	AccountNotify       = Cap{Name: "account-notify"}
+	AccountTag          = Cap{Name: "account-tag"}
+	AwayNotify          = Cap{Name: "away-notify"}
+	Batch               = Cap{Name: "batch"}
+	CapNotify           = Cap{Name: "cap-notify"}
+	AccountRegistration = Cap{Name: "draft/account-registration", Value: "before-connect,custom-account-name,email-required"}
+	ChatHistory         = Cap{Name: "draft/chathistory"}
+	EchoMessage         = Cap{Name: "echo-message"}
+	ExtendedJoin        = Cap{Name: "extended-join"}
+	ExtendedMonitor     = Cap{Name: "extended-monitor"}
+	InviteNotify        = Cap{Name: "invite-notify"}
+	LabeledResponses    = Cap{Name: "labeled-response"}
+	MessageTags         = Cap{Name: "message-tags"}
+	MultiPrefix         = Cap{Name: "multi-prefix"}
+	SASL                = Cap{Name: "sasl", Value: "PLAIN,EXTERNAL,SCRAM-SHA-256,SCRAM-SHA-256-PLUS,SCRAM-SHA-512,SCRAM-SHA-512-PLUS,OAUTHBEARER"}
+	ServerTime          = Cap{Name: "server-time"}
+	Setname             = Cap{Name: "setname"}
+	STS                 = Cap{Name: "sts", Value: "port=%s,duration=%.f"}
+	UserhostInNames     = Cap{Name: "userhost-in-names"}
 )
@@ -0,0 +1,58 @@
+package channel
+
+import (
+	"strings"
+
+	"github.com/mitchr/gossip/client"
+	"github.com/mitchr/gossip/scan/wild"
+)
+
+// SetAccess gives mask prefix p on the access list of ch. A mask is
+// either an account name, or a nickmask if it contains '!' or '@'.
+func (ch *Channel) SetAccess(mask string, p prefix) {
+	if ch.Access == nil {
+		ch.Access = make(map[string]prefix)
+	}
+	ch.Access[strings.ToLower(mask)] = p
+}
+
+// DeleteAccess removes mask from the access list of ch. It returns
+// false if mask was not on the list.
+func (ch *Channel) DeleteAccess(mask string) bool {
+	mask = strings.ToLower(mask)
+	_, ok := ch.Access[mask]
+	delete(ch.Access, mask)
+	return ok
+}
+
+// accessPrefix returns every prefix that ch grants to c, either through
+// its access list or by c being its founder.
+func (ch *Channel) accessPrefix(c *client.Client) prefix {
+	var p prefix
+	if ch.IsFounder(c) {
+		p |= Founder
+	}
+
+	account := strings.ToLower(c.SASLMech.Authn())
+	for mask, v := range ch.Access {
+		if strings.ContainsAny(mask, "!@") {
+			if matchMask(mask, c) {
+				p |= v
+			}
+		} else if c.IsAuthenticated && wild.Match(mask, account) {
+			p |= v
+		}
+	}
+	return p
+}
+
+// GrantAccess gives m the prefixes that ch grants it that it does not
+// have yet, and returns the mode letters of the new prefixes.
+func (ch *Channel) GrantAccess(m *Member) string {
+	p := ch.accessPrefix(m.Client) &^ m.Prefix
+	if p == 0 {
+		return ""
+	}
+	m.Prefix |= p
+	return p.modeLetters()
+}
@@ -7,6 +7,7 @@ import (
 	"math"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/mitchr/gossip/client"
@@ -34,18 +35,57 @@ type Channel struct {
 	TopicSetBy *client.Client
 	TopicSetAt time.Time
 
-	// array of nickmasks
-	Ban          []string
-	BanExcept    []string
+	// lists of nickmasks; entries can expire at any time, so they are
+	// guarded by listMu
+	Ban          []ListEntry
+	BanExcept    []ListEntry
 	Limit        int
 	Invite       bool
-	InviteExcept []string
+	InviteExcept []ListEntry
+	listMu       sync.RWMutex
 	Key          string
 	Moderated    bool
 	Secret       bool
 	Protected    bool
 	NoExternal   bool
 
+	// only clients that are logged in can join (+R), or speak without a
+	// prefix (+M)
+	RegisteredOnly      bool
+	RegisteredModerated bool
+
+	// messages with formatting codes are rejected (+c), or have them
+	// stripped (+S)
+	NoColors    bool
+	StripColors bool
+	// CTCPs other than ACTION are rejected (+C)
+	NoCTCP bool
+	// clients cannot KNOCK to ask for an invite (+K)
+	NoKnock bool
+
+	// Forward is the channel that clients are sent to if they are
+	// rejected for +l, +i, or +b (+L). Any channel operator can forward
+	// to a channel that is a FreeTarget (+F); otherwise they must be an
+	// operator of the target as well.
+	Forward    string
+	FreeTarget bool
+
+	// Registered channels are kept when their last member leaves, and
+	// their state is saved across restarts
+	Registered bool
+	// Account of the client that registered this channel
+	Founder string
+	// Account names and nickmasks to the prefixes that they are given
+	// when they join
+	Access map[string]prefix
+
+	// state of +f and +j
+	flood        *floodProtection
+	joinThrottle *joinThrottle
+
+	// when each client last knocked
+	knocks *counter
+
 	// Invited is a list of client nicks who have been INVITEd
 	Invited []string
 
@@ -60,6 +100,7 @@ func New(name string, t ChanType) *Channel {
 		Limit:     math.MaxInt,
 		Members:   util.NewSafeMap[string, *Member](),
 		CreatedAt: time.Now(),
+		knocks:    newCounter(KnockDelay),
 	}
 }
 
@@ -71,6 +112,12 @@ func (c *Channel) Len() int {
 	return c.Members.Len()
 }
 
+// IsFounder reports whether c is logged in to the account that
+// registered ch.
+func (ch *Channel) IsFounder(c *client.Client) bool {
+	return ch.Registered && c.IsAuthenticated && strings.EqualFold(ch.Founder, c.SASLMech.Authn())
+}
+
 func (c *Channel) GetMember(m string) (*Member, bool) {
 	mem, ok := c.Members.Get(strings.ToLower(m))
 	return mem, ok
@@ -109,24 +156,39 @@ func (ch *Channel) AllExcept(except *client.Client) iter.Seq[*Member] {
 }
 
 func (c *Channel) Modes() (modestr string, params []string) {
+	c.listMu.RLock()
+	defer c.listMu.RUnlock()
+
 	modestr = "+"
 	if len(c.Ban) != 0 {
 		modestr += "b"
-		params = append(params, strings.Join(c.Ban, ","))
+		params = append(params, strings.Join(masks(c.Ban), ","))
 	}
 	if len(c.BanExcept) != 0 {
 		modestr += "e"
-		params = append(params, strings.Join(c.BanExcept, ","))
+		params = append(params, strings.Join(masks(c.BanExcept), ","))
 	}
 	if c.Limit != math.MaxInt {
 		modestr += "l"
 		params = append(params, strconv.Itoa(c.Limit))
 	}
+	if c.flood != nil {
+		modestr += "f"
+		params = append(params, c.flood.param)
+	}
+	if c.joinThrottle != nil {
+		modestr += "j"
+		params = append(params, c.joinThrottle.param)
+	}
+	if c.Forward != "" {
+		modestr += "L"
+		params = append(params, c.Forward)
+	}
 	if c.Invite {
 		modestr += "i"
 	}
 	if len(c.InviteExcept) != 0 {
-		params = append(params, strings.Join(c.InviteExcept, ","))
+		params = append(params, strings.Join(masks(c.InviteExcept), ","))
 	}
 	// don't share key in mode params
 	if c.Key != "" {
@@ -144,6 +206,27 @@ func (c *Channel) Modes() (modestr string, params []string) {
 	if c.NoExternal {
 		modestr += "n"
 	}
+	if c.RegisteredOnly {
+		modestr += "R"
+	}
+	if c.RegisteredModerated {
+		modestr += "M"
+	}
+	if c.NoColors {
+		modestr += "c"
+	}
+	if c.StripColors {
+		modestr += "S"
+	}
+	if c.NoCTCP {
+		modestr += "C"
+	}
+	if c.NoKnock {
+		modestr += "K"
+	}
+	if c.FreeTarget {
+		modestr += "F"
+	}
 	return
 }
 
@@ -177,21 +260,57 @@ func (c *Channel) WriteMessageFrom(m msg.Msg, from *client.Client) {
 	}
 }
 
+// ResetModes clears every mode of c and the prefixes of all of its
+// members.
+func (c *Channel) ResetModes() {
+	c.listMu.Lock()
+	c.Ban = nil
+	c.BanExcept = nil
+	c.InviteExcept = nil
+	c.listMu.Unlock()
+
+	c.Limit = math.MaxInt
+	c.Invite = false
+	c.Key = ""
+	c.Moderated = false
+	c.Secret = false
+	c.Protected = false
+	c.NoExternal = false
+	c.RegisteredOnly = false
+	c.RegisteredModerated = false
+	c.NoColors = false
+	c.StripColors = false
+	c.NoCTCP = false
+	c.NoKnock = false
+	c.Forward = ""
+	c.FreeTarget = false
+	c.flood = nil
+	c.joinThrottle = nil
+
+	for m := range c.All() {
+		m.Prefix = 0
+	}
+}
+
 var (
 	ErrKeyMissing   = errors.New("ERR_BADCHANNELKEY")
 	ErrLimitReached = errors.New("ERR_CHANNELISFULL")
 	ErrNotInvited   = errors.New("ERR_INVITEONLYCHAN")
 	ErrBanned       = errors.New("ERR_BANNEDFROMCHAN")
+	ErrThrottled    = errors.New("ERR_THROTTLE")
+	ErrNeedAccount  = errors.New("ERR_NEEDREGGEDNICK")
 )
 
 // Admit adds a client to this channel. A client c is admitted to enter
 // a channel if:
 //  1. If this channel has a key, the client supplies the correct key
 //  2. admitting this client does not put the channel over the chanlimit
-//  3. their nick has been given an INVITE
-//  4. if they have not been given an INVITE, their nickmask is in the inviteException list
-//  5. their nickmask is not included in the banlist
-//  6. if they are in the banlist, they are in the except list
+//     or the join throttle
+//  3. if this channel is +R, the client is logged in
+//  4. their nick has been given an INVITE
+//  5. if they have not been given an INVITE, their nickmask is in the inviteException list
+//  6. their nickmask is not included in the banlist
+//  7. if they are in the banlist, they are in the except list
 func (ch *Channel) Admit(c *client.Client, key string) error {
 	if ch.Key != key {
 		return ErrKeyMissing
@@ -199,11 +318,19 @@ func (ch *Channel) Admit(c *client.Client, key string) error {
 	if ch.Len() >= ch.Limit {
 		return ErrLimitReached
 	}
+	if ch.RegisteredOnly && !c.IsAuthenticated {
+		return ErrNeedAccount
+	}
+	if ch.joinThrottle != nil && !ch.joinThrottle.allow() {
+		return ErrThrottled
+	}
 
+	ch.listMu.RLock()
+	defer ch.listMu.RUnlock()
 	if ch.Invite {
 		for _, v := range ch.InviteExcept {
 			// client doesn't need an invite, add them
-			if wild.Match(strings.ToLower(v), strings.ToLower(c.String())) {
+			if matchMask(v.Mask, c) {
 				ch.SetMember(&Member{Client: c})
 				return nil
 			}
@@ -218,19 +345,47 @@ func (ch *Channel) Admit(c *client.Client, key string) error {
 		return ErrNotInvited
 	}
 
+	if ch.banned(c) {
+		return ErrBanned
+	}
+	ch.SetMember(&Member{Client: c})
+	return nil
+}
+
+// Banned returns true if c matches a ban of ch, and does not match any
+// of its ban exceptions. Mutes are not counted.
+func (ch *Channel) Banned(c *client.Client) bool {
+	ch.listMu.RLock()
+	defer ch.listMu.RUnlock()
+	return ch.banned(c)
+}
+
+func (ch *Channel) banned(c *client.Client) bool {
 	for _, v := range ch.Ban {
-		if wild.Match(strings.ToLower(v), strings.ToLower(c.String())) { // nickmask found in banlist
+		if !isMute(v.Mask) && matchMask(v.Mask, c) { // nickmask found in banlist
 			for _, k := range ch.BanExcept {
-				if wild.Match(strings.ToLower(k), strings.ToLower(c.String())) { // nickmask is an exception, so admit
-					ch.SetMember(&Member{Client: c})
-					return nil
+				if matchMask(k.Mask, c) { // nickmask is an exception
+					return false
 				}
 			}
-			return ErrBanned
+			return true
 		}
 	}
-	ch.SetMember(&Member{Client: c})
-	return nil
+	return false
+}
+
+// matchMask returns true if mask matches the nickmask of c, or if it is
+// an extended ban that matches c. Cloaked clients are matched by both
+// their cloak and their real host.
+func matchMask(mask string, c *client.Client) bool {
+	mask = strings.ToLower(mask)
+	if strings.HasPrefix(mask, "$") {
+		return matchExtban(mask[1:], c)
+	}
+	if wild.Match(mask, strings.ToLower(c.String())) {
+		return true
+	}
+	return c.RealHost != c.Host && wild.Match(mask, strings.ToLower(c.RealString()))
 }
 
 var (
@@ -243,7 +398,11 @@ var (
 // ApplyMode applies the given mode to the channel. It does not
 // verify that the sending client has the proper permissions to make
 // those changes. It returns a modeStr if the mode was successfully applied.
-func (c *Channel) ApplyMode(m mode.Mode) error {
+func (c *Channel) ApplyMode(m mode.Mode) error { return c.ApplyModeBy(m, "") }
+
+// ApplyModeBy is like ApplyMode, but records setter as the one who
+// added any list entries.
+func (c *Channel) ApplyModeBy(m mode.Mode, setter string) error {
 	if p, ok := channelLetter[m.ModeChar]; ok {
 
 		// special branch for key validation
@@ -252,12 +411,25 @@ func (c *Channel) ApplyMode(m mode.Mode) error {
 				return fmt.Errorf("%w+k", ErrInvalidKey)
 			}
 		}
+		if m.ModeChar == 'f' && m.Type == mode.Add && m.Param != "" {
+			if _, err := parseFlood(m.Param); err != nil {
+				return err
+			}
+		}
+		if m.ModeChar == 'j' && m.Type == mode.Add && m.Param != "" {
+			if _, err := parseJoinThrottle(m.Param); err != nil {
+				return err
+			}
+		}
 
 		if (p.addConsumes && m.Type == mode.Add) || (p.remConsumes && m.Type == mode.Remove) {
 			if m.Param == "" { // mode should have a param but doesn't
 				return fmt.Errorf(":%w%s", ErrNeedMoreParams, m)
 			}
 		}
+		if c.list(m.ModeChar) != nil {
+			return c.updateList(m, setter)
+		}
 		p.apply(c, m.Param, m.Type == mode.Add)
 	} else if _, ok := memberLetter[m.ModeChar]; ok { // should apply this prefix to a member, not the channel
 		// all user MODE changes should have a param
@@ -292,32 +464,28 @@ func keyIsValid(key string) bool {
 //     with 'password'. This skips unknown mode characters.
 //  2. sets the Type of a mode to mode.List if there are no more params
 //     left to be associated and that particular mode is listable
+//  3. associates a duration with list entries that are being added if
+//     their mask is timed, like "MODE #test +b ~t:1h:bob!*@*"
 func PrepareModes(modes []mode.Mode, params []string) {
 	pos := 0
-	for _, p := range params {
-		// no more modes left, we can ignore all other params
-		if pos > len(modes)-1 {
-			return
+	for i, m := range modes {
+		f, isChanMode := channelLetter[m.ModeChar]
+		_, isMemberMode := memberLetter[m.ModeChar]
+		consumes := isMemberMode || isChanMode && ((m.Type == mode.Add && f.addConsumes) || (m.Type == mode.Remove && f.remConsumes))
+		if !consumes {
+			continue
 		}
 
-		m := modes[pos]
-		if f, ok := channelLetter[m.ModeChar]; ok {
-			if (m.Type == mode.Add && f.addConsumes) || (m.Type == mode.Remove && f.remConsumes) {
-				modes[pos].Param = p
-				pos++
-			}
-		} else if _, ok := memberLetter[m.ModeChar]; ok {
-			modes[pos].Param = p
+		if pos < len(params) {
+			modes[i].Param = params[pos]
 			pos++
-		}
-	}
-
-	// for remaining modes, check if they are listable
-	for i, m := range modes[pos:] {
-		if f, ok := channelLetter[m.ModeChar]; ok {
-			if m.Type == mode.Add && f.canList {
-				modes[i].Type = mode.List
+			if isChanMode && f.canList && m.Type == mode.Add {
+				if mask, d, ok := parseTimedMask(modes[i].Param); ok {
+					modes[i].Param, modes[i].Duration = mask, d
+				}
 			}
+		} else if isChanMode && m.Type == mode.Add && f.canList {
+			modes[i].Type = mode.List
 		}
 	}
 }
@@ -0,0 +1,70 @@
+package channel
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/mitchr/gossip/client"
+	"github.com/mitchr/gossip/scan/wild"
+)
+
+// Extended bans start with '$' and a type, followed by ':' and a
+// parameter for types that take one:
+//
+//	$a[:account]   logged in (to a matching account)
+//	$r:realname    matching realname
+//	$z             not connected over TLS
+//	$f:certfp      TLS certificate with this fingerprint
+//	$m:mask        muted; can join, but not speak
+//
+// The parameter of $m is any other mask, including an extended ban.
+const ExtbanTypes = "afmrz"
+
+// matchExtban returns true if the extended ban mask, without its
+// leading '$', matches c.
+func matchExtban(mask string, c *client.Client) bool {
+	if mask == "" {
+		return false
+	}
+	typ, arg, hasArg := mask[0], "", false
+	if len(mask) > 1 {
+		if mask[1] != ':' {
+			return false
+		}
+		arg, hasArg = mask[2:], true
+	}
+
+	switch typ {
+	case 'a':
+		return c.IsAuthenticated && (!hasArg || wild.Match(arg, strings.ToLower(c.SASLMech.Authn())))
+	case 'r':
+		return hasArg && wild.Match(arg, strings.ToLower(c.Realname))
+	case 'z':
+		return !c.IsSecure()
+	case 'f':
+		fp, err := c.CertificateFingerprint()
+		return hasArg && err == nil && strings.EqualFold(arg, fp)
+	case 'm':
+		return hasArg && matchMask(arg, c)
+	}
+	return false
+}
+
+// isMute returns true if mask is a mute, which does not keep clients
+// from joining. Like matchMask, it ignores case.
+func isMute(mask string) bool {
+	return len(mask) >= 3 && strings.EqualFold(mask[:3], "$m:")
+}
+
+// Muted returns true if c matches a mute of ch, and does not match any
+// of its ban exceptions.
+func (ch *Channel) Muted(c *client.Client) bool {
+	ch.listMu.RLock()
+	defer ch.listMu.RUnlock()
+	for _, v := range ch.Ban {
+		if isMute(v.Mask) && matchMask(v.Mask, c) {
+			return !slices.ContainsFunc(ch.BanExcept, func(e ListEntry) bool { return matchMask(e.Mask, c) })
+		}
+	}
+	return false
+}
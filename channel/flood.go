@@ -0,0 +1,245 @@
+package channel
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kinds of events that +f counts. Messages are counted for each member,
+// while joins and nick changes are counted for the whole channel.
+const (
+	FloodMessage = 'm'
+	FloodJoin    = 'j'
+	FloodNick    = 'n'
+)
+
+// Actions that are taken once a flood limit is exceeded. Kicking and
+// muting only act on the member that sent the last message, so they can
+// only be used for message floods.
+const (
+	FloodKick     = 'k'
+	FloodMute     = 'q'
+	FloodModerate = 'm'
+	FloodInvite   = 'i'
+)
+
+var defaultFloodAction = map[byte]byte{
+	FloodMessage: FloodKick,
+	FloodJoin:    FloodInvite,
+	FloodNick:    FloodModerate,
+}
+
+// How long a mute, +m, or +i lasts if no duration is given
+const defaultFloodDuration = time.Minute
+
+// An InvalidModeParamError is returned when a mode is given a
+// parameter that can't be parsed.
+type InvalidModeParamError struct {
+	ModeChar byte
+	Param    string
+}
+
+func (e *InvalidModeParamError) Error() string {
+	return fmt.Sprintf("invalid parameter %q for mode %c", e.Param, e.ModeChar)
+}
+
+// A FloodLimit is the number of events of one kind that are allowed in a
+// window, and what to do when there are more.
+type FloodLimit struct {
+	Count  int
+	Action byte
+
+	// how long the action lasts; not used for kicks
+	Duration time.Duration
+}
+
+// A counter counts events in fixed windows of time.
+type counter struct {
+	window time.Duration
+
+	mu     sync.Mutex
+	start  map[string]time.Time
+	counts map[string]int
+}
+
+func newCounter(window time.Duration) *counter {
+	return &counter{window: window, start: make(map[string]time.Time), counts: make(map[string]int)}
+}
+
+// add records an event for key, and returns the number of events for
+// key in the current window.
+func (c *counter) add(key string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(c.start[key]) >= c.window {
+		// forget everyone else whose windows are over as well
+		for k, start := range c.start {
+			if now.Sub(start) >= c.window {
+				delete(c.start, k)
+				delete(c.counts, k)
+			}
+		}
+		c.start[key] = now
+	}
+	c.counts[key]++
+	return c.counts[key]
+}
+
+// floodProtection is the state of +f.
+type floodProtection struct {
+	param  string
+	limits map[byte]FloodLimit
+	*counter
+}
+
+// parseFlood parses a +f parameter, which is a comma separated list of
+// limits followed by the length of the window in seconds:
+//
+//	<count><kind>[#<action>[<minutes>]][,...]:<seconds>
+//
+// For example, 5m#q2,10j:15 mutes members for 2 minutes if they send
+// more than 5 messages in 15 seconds, and sets +i if there are more than
+// 10 joins in 15 seconds.
+func parseFlood(param string) (*floodProtection, error) {
+	invalid := &InvalidModeParamError{ModeChar: 'f', Param: param}
+	limitStr, secondStr, ok := strings.Cut(param, ":")
+	seconds, err := strconv.Atoi(secondStr)
+	if !ok || err != nil || seconds <= 0 {
+		return nil, invalid
+	}
+
+	f := &floodProtection{param: param, limits: make(map[byte]FloodLimit), counter: newCounter(time.Duration(seconds) * time.Second)}
+	for _, l := range strings.Split(limitStr, ",") {
+		l, actionStr, hasAction := strings.Cut(l, "#")
+		if len(l) < 2 {
+			return nil, invalid
+		}
+		kind := l[len(l)-1]
+		count, err := strconv.Atoi(l[:len(l)-1])
+		action, known := defaultFloodAction[kind]
+		if err != nil || count <= 0 || !known {
+			return nil, invalid
+		}
+
+		limit := FloodLimit{Count: count, Action: action, Duration: defaultFloodDuration}
+		if hasAction {
+			if actionStr == "" {
+				return nil, invalid
+			}
+			limit.Action = actionStr[0]
+			if len(actionStr) > 1 {
+				minutes, err := strconv.Atoi(actionStr[1:])
+				if err != nil || minutes <= 0 {
+					return nil, invalid
+				}
+				limit.Duration = time.Duration(minutes) * time.Minute
+			}
+		}
+
+		switch limit.Action {
+		case FloodModerate, FloodInvite:
+		case FloodKick, FloodMute:
+			if kind != FloodMessage {
+				return nil, invalid
+			}
+		default:
+			return nil, invalid
+		}
+		f.limits[kind] = limit
+	}
+	return f, nil
+}
+
+// Flooded records an event of kind by the member nick. If this goes
+// over the +f limit for kind, the limit is returned along with true.
+func (ch *Channel) Flooded(kind byte, nick string) (FloodLimit, bool) {
+	f := ch.flood
+	if f == nil {
+		return FloodLimit{}, false
+	}
+	limit, ok := f.limits[kind]
+	if !ok {
+		return FloodLimit{}, false
+	}
+
+	key := string(kind)
+	if kind == FloodMessage {
+		key += strings.ToLower(nick)
+	}
+	return limit, f.add(key) > limit.Count
+}
+
+// joinThrottle is the state of +j.
+type joinThrottle struct {
+	param string
+	joins int
+	*counter
+}
+
+// parseJoinThrottle parses a +j parameter, which is the number of joins
+// that are allowed in a number of seconds: <joins>:<seconds>
+func parseJoinThrottle(param string) (*joinThrottle, error) {
+	invalid := &InvalidModeParamError{ModeChar: 'j', Param: param}
+	joinStr, secondStr, _ := strings.Cut(param, ":")
+	joins, err := strconv.Atoi(joinStr)
+	if err != nil || joins <= 0 {
+		return nil, invalid
+	}
+	seconds, err := strconv.Atoi(secondStr)
+	if err != nil || seconds <= 0 {
+		return nil, invalid
+	}
+	return &joinThrottle{param, joins, newCounter(time.Duration(seconds) * time.Second)}, nil
+}
+
+// allow returns true if another client can join. Every join that is
+// allowed is counted.
+func (j *joinThrottle) allow() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if time.Since(j.start[""]) >= j.window {
+		j.start[""] = time.Now()
+		j.counts[""] = 0
+	}
+	if j.counts[""] >= j.joins {
+		return false
+	}
+	j.counts[""]++
+	return true
+}
+
+// FloodParam returns the parameter of +f, or "" if it is not set.
+func (ch *Channel) FloodParam() string {
+	if ch.flood == nil {
+		return ""
+	}
+	return ch.flood.param
+}
+
+// JoinThrottleParam returns the parameter of +j, or "" if it is not set.
+func (ch *Channel) JoinThrottleParam() string {
+	if ch.joinThrottle == nil {
+		return ""
+	}
+	return ch.joinThrottle.param
+}
+
+func flood(ch *Channel, param string, add bool) {
+	ch.flood = nil
+	if add {
+		ch.flood, _ = parseFlood(param)
+	}
+}
+
+func throttle(ch *Channel, param string, add bool) {
+	ch.joinThrottle = nil
+	if add {
+		ch.joinThrottle, _ = parseJoinThrottle(param)
+	}
+}
@@ -0,0 +1,16 @@
+package channel
+
+import (
+	"strings"
+	"time"
+)
+
+// How long a client has to wait before it can KNOCK on the same channel
+// again
+const KnockDelay = time.Minute
+
+// Knock records a KNOCK by nick on ch. It returns false if nick has
+// already knocked on ch within KnockDelay.
+func (ch *Channel) Knock(nick string) bool {
+	return ch.knocks.add(strings.ToLower(nick)) == 1
+}
@@ -0,0 +1,163 @@
+package channel
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mitchr/gossip/scan/mode"
+)
+
+// MaxListEntries is how many entries +b, +e, and +I can hold between
+// them. It is advertised in MAXLIST.
+const MaxListEntries = 25
+
+var ErrListFull = errors.New("")
+
+// A ListEntry is a mask on the ban, ban exception, or invite exception
+// list of a channel.
+type ListEntry struct {
+	Mask  string
+	SetBy string
+	SetAt time.Time
+	// when the entry is removed; zero if it never is
+	Expires time.Time
+}
+
+// list returns the list of ch that is set with char, or nil if char is
+// not a list mode. Callers must hold listMu to use the list.
+func (ch *Channel) list(char byte) *[]ListEntry {
+	switch char {
+	case 'b':
+		return &ch.Ban
+	case 'e':
+		return &ch.BanExcept
+	case 'I':
+		return &ch.InviteExcept
+	}
+	return nil
+}
+
+// List returns a copy of the list of ch that is set with char.
+func (ch *Channel) List(char byte) []ListEntry {
+	ch.listMu.RLock()
+	defer ch.listMu.RUnlock()
+	if list := ch.list(char); list != nil {
+		return slices.Clone(*list)
+	}
+	return nil
+}
+
+// ListEntry returns the entry for mask on the list of ch that is set
+// with char.
+func (ch *Channel) ListEntry(char byte, mask string) (ListEntry, bool) {
+	ch.listMu.RLock()
+	defer ch.listMu.RUnlock()
+	list := ch.list(char)
+	if list == nil {
+		return ListEntry{}, false
+	}
+	i := slices.IndexFunc(*list, func(e ListEntry) bool { return e.Mask == mask })
+	if i == -1 {
+		return ListEntry{}, false
+	}
+	return (*list)[i], true
+}
+
+// AddListEntry adds e to the list of ch that is set with char, without
+// checking MAXLIST. This is used to restore lists.
+func (ch *Channel) AddListEntry(char byte, e ListEntry) {
+	ch.listMu.Lock()
+	defer ch.listMu.Unlock()
+	if list := ch.list(char); list != nil {
+		*list = append(*list, e)
+	}
+}
+
+// updateList adds or removes the mask of m from the list that is set
+// with its mode char. Adding a mask that is already on the list
+// replaces its entry.
+func (ch *Channel) updateList(m mode.Mode, setter string) error {
+	ch.listMu.Lock()
+	defer ch.listMu.Unlock()
+
+	list := ch.list(m.ModeChar)
+	i := slices.IndexFunc(*list, func(e ListEntry) bool { return e.Mask == m.Param })
+	if m.Type == mode.Remove {
+		if i != -1 {
+			*list = slices.Delete(*list, i, i+1)
+		}
+		return nil
+	}
+
+	// PrepareModes strips the prefix from timed masks it could parse, so
+	// one that is still here has a bad duration
+	if strings.HasPrefix(m.Param, timedPrefix) {
+		return &InvalidModeParamError{ModeChar: m.ModeChar, Param: m.Param}
+	}
+
+	e := ListEntry{Mask: m.Param, SetBy: setter, SetAt: time.Now()}
+	if m.Duration > 0 {
+		e.Expires = e.SetAt.Add(m.Duration)
+	}
+	if i != -1 {
+		(*list)[i] = e
+		return nil
+	}
+	if len(ch.Ban)+len(ch.BanExcept)+len(ch.InviteExcept) >= MaxListEntries {
+		return fmt.Errorf("%w%c", ErrListFull, m.ModeChar)
+	}
+	*list = append(*list, e)
+	return nil
+}
+
+func masks(list []ListEntry) []string {
+	m := make([]string, len(list))
+	for i, e := range list {
+		m[i] = e.Mask
+	}
+	return m
+}
+
+// maxEntryDuration is the longest that a timed list entry can last.
+const maxEntryDuration = 366 * 24 * time.Hour
+
+const timedPrefix = "~t:"
+
+// parseTimedMask splits a timed list entry, written "~t:<duration>:<mask>",
+// into its mask and duration. It returns false if param is not timed.
+func parseTimedMask(param string) (string, time.Duration, bool) {
+	rest, ok := strings.CutPrefix(param, timedPrefix)
+	if !ok {
+		return "", 0, false
+	}
+	duration, mask, ok := strings.Cut(rest, ":")
+	if !ok || mask == "" {
+		return "", 0, false
+	}
+	d, ok := parseDuration(duration)
+	return mask, d, ok
+}
+
+// parseDuration parses how long a list entry should last. Besides the
+// units of time.ParseDuration, whole days can be given as "<n>d".
+// Durations longer than maxEntryDuration are rejected.
+func parseDuration(s string) (time.Duration, bool) {
+	var d time.Duration
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 || n > int(maxEntryDuration/(24*time.Hour)) {
+			return 0, false
+		}
+		d = time.Duration(n) * 24 * time.Hour
+	} else {
+		var err error
+		if d, err = time.ParseDuration(s); err != nil {
+			return 0, false
+		}
+	}
+	return d, d > 0 && d <= maxEntryDuration
+}
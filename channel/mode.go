@@ -91,42 +91,27 @@ var channelLetter = map[byte]struct {
 	addConsumes, remConsumes bool
 	canList                  bool
 }{
-	'b': {ban, true, true, true},
-	'e': {banExcept, true, true, true},
+	// lists are updated by updateList
+	'b': {nil, true, true, true},
+	'e': {nil, true, true, true},
 	'l': {limit, true, false, false},
+	'f': {flood, true, false, false},
+	'j': {throttle, true, false, false},
+	'L': {forward, true, false, false},
 	'i': {invite, false, false, false},
-	'I': {inviteExcept, true, true, true},
+	'I': {nil, true, true, true},
 	'k': {key, true, false, false},
 	'm': {moderated, false, false, false},
 	's': {secret, false, false, false},
 	't': {protected, false, false, false},
 	'n': {noExternal, false, false, false},
-}
-
-func ban(ch *Channel, mask string, add bool) {
-	if add {
-		ch.Ban = append(ch.Ban, mask)
-	} else {
-		for i := range ch.Ban {
-			if ch.Ban[i] == mask {
-				ch.Ban = append(ch.Ban[:i], ch.Ban[i+1:]...)
-				return
-			}
-		}
-	}
-}
-
-func banExcept(ch *Channel, mask string, add bool) {
-	if add {
-		ch.BanExcept = append(ch.BanExcept, mask)
-	} else {
-		for i := range ch.BanExcept {
-			if ch.BanExcept[i] == mask {
-				ch.BanExcept = append(ch.BanExcept[:i], ch.BanExcept[i+1:]...)
-				return
-			}
-		}
-	}
+	'R': {registeredOnly, false, false, false},
+	'M': {registeredModerated, false, false, false},
+	'c': {noColors, false, false, false},
+	'S': {stripColors, false, false, false},
+	'C': {noCTCP, false, false, false},
+	'K': {noKnock, false, false, false},
+	'F': {freeTarget, false, false, false},
 }
 
 func limit(ch *Channel, param string, add bool) {
@@ -139,16 +124,11 @@ func limit(ch *Channel, param string, add bool) {
 
 func invite(ch *Channel, p string, add bool) { ch.Invite = add }
 
-func inviteExcept(ch *Channel, mask string, add bool) {
+func forward(ch *Channel, param string, add bool) {
 	if add {
-		ch.InviteExcept = append(ch.InviteExcept, mask)
+		ch.Forward = param
 	} else {
-		for i := range ch.InviteExcept {
-			if ch.InviteExcept[i] == mask {
-				ch.InviteExcept = append(ch.InviteExcept[:i], ch.InviteExcept[i+1:]...)
-				return
-			}
-		}
+		ch.Forward = ""
 	}
 }
 
@@ -160,7 +140,14 @@ func key(ch *Channel, param string, add bool) {
 	}
 }
 
-func moderated(ch *Channel, p string, add bool)  { ch.Moderated = add }
-func secret(ch *Channel, p string, add bool)     { ch.Secret = add }
-func protected(ch *Channel, p string, add bool)  { ch.Protected = add }
-func noExternal(ch *Channel, p string, add bool) { ch.NoExternal = add }
+func moderated(ch *Channel, p string, add bool)           { ch.Moderated = add }
+func secret(ch *Channel, p string, add bool)              { ch.Secret = add }
+func protected(ch *Channel, p string, add bool)           { ch.Protected = add }
+func noExternal(ch *Channel, p string, add bool)          { ch.NoExternal = add }
+func registeredOnly(ch *Channel, p string, add bool)      { ch.RegisteredOnly = add }
+func registeredModerated(ch *Channel, p string, add bool) { ch.RegisteredModerated = add }
+func noColors(ch *Channel, p string, add bool)            { ch.NoColors = add }
+func stripColors(ch *Channel, p string, add bool)         { ch.StripColors = add }
+func noCTCP(ch *Channel, p string, add bool)              { ch.NoCTCP = add }
+func noKnock(ch *Channel, p string, add bool)             { ch.NoKnock = add }
+func freeTarget(ch *Channel, p string, add bool)          { ch.FreeTarget = add }
@@ -7,9 +7,10 @@ import (
 	"crypto/tls"
 	"encoding/hex"
 	"errors"
-	"log"
+	"log/slog"
 	"net"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -19,14 +20,23 @@ import (
 )
 
 type Client struct {
-	conn net.Conn
-	ctx  context.Context
+	conn   net.Conn
+	connID uint64
+	ctx    context.Context
 
 	Nick     string
 	User     string
 	Realname string
 	Host     string
 
+	// The host that this client is really connecting from. Host is the
+	// same as RealHost unless the client is cloaked.
+	RealHost string
+
+	// Name of the server that this client is connected to. This is empty
+	// for clients that are connected directly to this server.
+	Server string
+
 	// uxin timestamp when client first connects
 	JoinTime int64
 
@@ -41,6 +51,14 @@ type Client struct {
 	ServerPassAccepted bool
 	RegSuspended       bool
 
+	// categories of server notices that this client receives with +s
+	snomask uint32
+
+	// The name of the operator class that this client opered up with.
+	// This is empty if the client is not an operator, or if its class
+	// grants every privilege.
+	OperClass string
+
 	// Represents a set of IRCv3 capabilities
 	Caps map[string]bool
 	// The maximum CAP version that this client supports. If no version is
@@ -49,6 +67,8 @@ type Client struct {
 
 	// Mechanism that is currently in use for this client
 	SASLMech sasl.Mechanism
+	// The name that the client requested SASLMech by, like PLAIN
+	SASLMechName string
 
 	// True if this client has authenticated using SASL
 	IsAuthenticated bool
@@ -59,12 +79,34 @@ type Client struct {
 	AuthCtx []byte
 
 	grants uint32
+
+	// other connections that share this client's identity
+	sessions    []*Client
+	sessionLock sync.RWMutex
+	// the client that this connection is a session of, if any
+	identity *Client
+	// true if this client's own connection has been detached, but the
+	// client is kept around for its sessions
+	detached bool
+	// true once writeQueue has been closed
+	closed bool
 }
 
 func New(conn net.Conn, ctx context.Context) (*Client, context.Context, context.CancelFunc) {
+	c, ctx, cancel := Restore(conn, ctx)
+	c.Host = populateHostname(c.RemoteAddr().String())
+	c.RealHost = c.Host
+	return c, ctx, cancel
+}
+
+// Restore is like New, but does not look up the host of conn. It is
+// used for connections whose client is already known, like ones that
+// were handed over by an upgrade.
+func Restore(conn net.Conn, ctx context.Context) (*Client, context.Context, context.CancelFunc) {
 	now := time.Now()
 	c := &Client{
-		conn: conn,
+		conn:   conn,
+		connID: lastConnID.Add(1),
 
 		JoinTime: now.Unix(),
 		idle:     now.Unix(),
@@ -82,7 +124,6 @@ func New(conn net.Conn, ctx context.Context) (*Client, context.Context, context.
 	c.ctx, cancel = context.WithCancel(ctx)
 
 	c.FillGrants()
-	c.Host = populateHostname(c.RemoteAddr().String())
 
 	go func() {
 		for b := range c.writeQueue {
@@ -124,11 +165,16 @@ func (c *Client) String() string {
 	} else if c.Nick != "" {
 		return c.Nick
 	} else {
-		log.Println("client has no nick registered")
+		slog.Warn("client has no nick registered", "client", c)
 		return ""
 	}
 }
 
+// RealString is the same as String, but uses the real host of c.
+func (c *Client) RealString() string {
+	return c.Nick + "!" + c.User + "@" + c.RealHost
+}
+
 // An Id is used anywhere where a nick is requested in a reply. If
 // Client.Nick is not set yet, then Id returns "*" as a generic
 // placeholder.
@@ -141,18 +187,48 @@ func (c *Client) Id() string {
 
 func (c *Client) RemoteAddr() net.Addr { return c.conn.RemoteAddr() }
 
+// Conn returns the connection that c reads from and writes to.
+func (c *Client) Conn() net.Conn { return c.conn }
+
 // returns true if the client is connected over tls
 func (c *Client) IsSecure() bool {
-	_, ok := c.conn.(*tls.Conn)
+	_, ok := c.tlsConn()
 	return ok
 }
 
+// tlsConn finds the tls connection of c, looking through any
+// connections (like websockets) that wrap it.
+func (c *Client) tlsConn() (*tls.Conn, bool) {
+	conn := c.conn
+	for {
+		switch v := conn.(type) {
+		case *tls.Conn:
+			return v, true
+		case interface{ NetConn() net.Conn }:
+			conn = v.NetConn()
+		default:
+			return nil, false
+		}
+	}
+}
+
+// ConnectionState returns the state of the tls connection of c. If c is
+// not connected over tls, ok is false.
+func (c *Client) ConnectionState() (state tls.ConnectionState, ok bool) {
+	conn, ok := c.tlsConn()
+	if !ok {
+		return tls.ConnectionState{}, false
+	}
+	return conn.ConnectionState(), true
+}
+
 func (c *Client) Certificate() ([]byte, error) {
-	if !c.IsSecure() {
+	conn, ok := c.tlsConn()
+	if !ok {
 		return nil, errors.New("client is not connected over tls")
 	}
 
-	certs := c.conn.(*tls.Conn).ConnectionState().PeerCertificates
+	certs := conn.ConnectionState().PeerCertificates
 	if len(certs) < 1 {
 		return nil, errors.New("client has not provided a certificate")
 	}
@@ -244,15 +320,35 @@ func (c *Client) Write(b []byte) (int, error) {
 
 const timeFormat string = "2006-01-02T15:04:05.999Z"
 
+// WriteMessage sends m to every connection of c.
 func (c *Client) WriteMessage(m msg.Msg) {
+	for conn := range c.connections() {
+		conn.writeMessage(m)
+	}
+}
+
+func (c *Client) writeMessage(m msg.Msg) {
 	if c.Caps[capability.ServerTime.Name] {
 		m.AddTag("time", time.Now().UTC().Format(timeFormat))
 	}
 
-	c.writeQueue <- m.Bytes()
+	// the connection may have been closed since it was looked up
+	c.sessionLock.RLock()
+	defer c.sessionLock.RUnlock()
+	if !c.closed {
+		c.writeQueue <- m.Bytes()
+	}
 }
 
+// WriteMessageFrom sends m to every connection of c, adding or removing
+// tags based on the capabilities of each connection.
 func (c *Client) WriteMessageFrom(m msg.Msg, from *Client) {
+	for conn := range c.connections() {
+		conn.writeMessageFrom(m, from)
+	}
+}
+
+func (c *Client) writeMessageFrom(m msg.Msg, from *Client) {
 	if from.Is(Bot) {
 		m.AddTag("bot", "")
 	}
@@ -266,13 +362,21 @@ func (c *Client) WriteMessageFrom(m msg.Msg, from *Client) {
 		m.AddTag("account", from.SASLMech.Authn())
 	}
 
-	c.WriteMessage(m)
+	c.writeMessage(m)
 }
 
-// close msgQueue, then wait for all messages to be sent
-// it's up to the caller to make sure that no Writes are sent after this
+// close msgQueue, then wait for all messages to be sent. Any messages
+// written after this are dropped, and closing c again does nothing.
 func (c *Client) Close() error {
+	c.sessionLock.Lock()
+	if c.closed {
+		c.sessionLock.Unlock()
+		return net.ErrClosed
+	}
+	c.closed = true
 	close(c.writeQueue)
+	c.sessionLock.Unlock()
+
 	<-c.ctx.Done()
 	return c.conn.Close()
 }
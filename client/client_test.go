@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"context"
 	"fmt"
+	"io"
 	"net"
 	"testing"
 
@@ -33,6 +34,24 @@ func TestWriteMessageFrom(t *testing.T) {
 	})
 }
 
+func TestWriteAfterClose(t *testing.T) {
+	newPipeClient := func() *Client {
+		in, out := net.Pipe()
+		go io.Copy(io.Discard, out)
+		c, _, _ := New(in, context.TODO())
+		return c
+	}
+
+	identity, session := newPipeClient(), newPipeClient()
+	defer identity.Close()
+	identity.Attach(session)
+	session.Close()
+
+	// the session is still attached, but writing to it must not panic
+	identity.WriteMessage(msg.New(nil, "a", "", "", "PRIVMSG", []string{"b"}, false))
+	session.WriteToConn(msg.New(nil, "a", "", "", "PRIVMSG", []string{"b"}, false))
+}
+
 func BenchmarkRequestGrant(b *testing.B) {
 	c := &Client{}
 	c.FillGrants()
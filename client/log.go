@@ -0,0 +1,31 @@
+package client
+
+import (
+	"log/slog"
+	"sync/atomic"
+)
+
+// the id of the last connection that was opened
+var lastConnID atomic.Uint64
+
+// ConnID is a number that identifies the connection of c in logs. Each
+// connection gets its own id, even if it shares the identity of
+// another client.
+func (c *Client) ConnID() uint64 { return c.connID }
+
+// LogValue groups the attributes that identify c in a log record. These
+// are read when the record is written, so they always show the current
+// nick and account of c.
+func (c *Client) LogValue() slog.Value {
+	var attrs []slog.Attr
+	if c.conn != nil {
+		attrs = append(attrs, slog.Uint64("conn", c.connID), slog.String("addr", c.RemoteAddr().String()))
+	}
+	if c.Nick != "" {
+		attrs = append(attrs, slog.String("nick", c.Nick))
+	}
+	if c.IsAuthenticated {
+		attrs = append(attrs, slog.String("account", c.SASLMech.Authn()))
+	}
+	return slog.GroupValue(attrs...)
+}
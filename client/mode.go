@@ -19,6 +19,12 @@ const (
 	Op
 	LocalOp
 	Bot
+	// receives server notices; only operators can set this
+	ServerNotice
+	// host is hidden behind a cloak
+	Cloaked
+	// only accepts private messages from clients that are logged in
+	AuthOnly
 )
 
 var letter = map[byte]Mode{
@@ -28,6 +34,9 @@ var letter = map[byte]Mode{
 	'r': Registered,
 	'w': Wallops,
 	'b': Bot,
+	's': ServerNotice,
+	'x': Cloaked,
+	'R': AuthOnly,
 }
 
 func (m Mode) String() string {
@@ -44,6 +53,12 @@ func (m Mode) String() string {
 		return "O"
 	case Bot:
 		return "b"
+	case ServerNotice:
+		return "s"
+	case Cloaked:
+		return "x"
+	case AuthOnly:
+		return "R"
 	}
 
 	s := ""
@@ -55,6 +70,17 @@ func (m Mode) String() string {
 	return s
 }
 
+// Modes returns every mode that c has set, like "+iw".
+func (c *Client) Modes() string {
+	s := "+"
+	for _, l := range "ioOrwbsxR" {
+		if c.Is(letter[byte(l)]) {
+			s += string(l)
+		}
+	}
+	return s
+}
+
 func (c *Client) Is(m Mode) bool {
 	mode := Mode(atomic.LoadUint32((*uint32)(&c.Mode)))
 	return mode&m == m
@@ -88,9 +114,15 @@ func (c *Client) ApplyMode(m mode.Mode) bool {
 			if mask == Op || mask == LocalOp {
 				return false
 			}
+			if mask == ServerNotice && !c.Is(Op) {
+				return false
+			}
 			c.SetMode(mask)
 		} else {
 			c.UnsetMode(mask)
+			if mask == ServerNotice {
+				c.SetSnomask(0)
+			}
 		}
 	}
 	return ok
@@ -0,0 +1,119 @@
+package client
+
+import (
+	"iter"
+	"slices"
+
+	"github.com/mitchr/gossip/scan/msg"
+)
+
+// A Client may have other connections (sessions) attached to it. All
+// of its sessions share its nick and channel memberships, and every
+// message written to the Client is sent to each one of them.
+
+// Attach adds session as a connection of c. session takes on the nick
+// and hostmask of c and is marked as registered.
+func (c *Client) Attach(session *Client) {
+	c.sessionLock.Lock()
+	defer c.sessionLock.Unlock()
+
+	session.identity = c
+	session.Nick = c.Nick
+	session.User = c.User
+	session.Host = c.Host
+	session.RealHost = c.RealHost
+	session.Realname = c.Realname
+	session.SetMode(Registered)
+	c.sessions = append(c.sessions, session)
+}
+
+// Detach removes session from the connections of c. session may be c
+// itself, in which case c stays around without a connection of its
+// own. If session is the last connection of c and keepLast is false,
+// nothing is removed and Detach returns false.
+func (c *Client) Detach(session *Client, keepLast bool) bool {
+	c.sessionLock.Lock()
+	defer c.sessionLock.Unlock()
+
+	if c.connCount() <= 1 && !keepLast {
+		return false
+	}
+
+	if session == c {
+		c.detached = true
+	} else {
+		c.sessions = slices.DeleteFunc(c.sessions, func(v *Client) bool { return v == session })
+	}
+	return true
+}
+
+// SetNick changes the nick of c and of every session attached to it.
+func (c *Client) SetNick(nick string) {
+	c.sessionLock.RLock()
+	defer c.sessionLock.RUnlock()
+
+	c.Nick = nick
+	for _, v := range c.sessions {
+		v.Nick = nick
+	}
+}
+
+// Identity returns the client that c is a session of. If c is not
+// attached to another client, this is c.
+func (c *Client) Identity() *Client {
+	if c.identity != nil {
+		return c.identity
+	}
+	return c
+}
+
+// Sessions returns the connections that are attached to c, not
+// including c itself.
+func (c *Client) Sessions() []*Client {
+	c.sessionLock.RLock()
+	defer c.sessionLock.RUnlock()
+	return slices.Clone(c.sessions)
+}
+
+// Detached reports whether c has been detached from its own
+// connection.
+func (c *Client) Detached() bool {
+	c.sessionLock.RLock()
+	defer c.sessionLock.RUnlock()
+	return c.detached
+}
+
+func (c *Client) connCount() int {
+	n := len(c.sessions)
+	if !c.detached && !c.closed {
+		n++
+	}
+	return n
+}
+
+// connections yields every live connection of c, including c itself
+// if it has not been detached or closed. The connections are collected
+// up front, so that writing to them doesn't happen under the lock of c.
+func (c *Client) connections() iter.Seq[*Client] {
+	return func(yield func(*Client) bool) {
+		c.sessionLock.RLock()
+		conns := make([]*Client, 0, len(c.sessions)+1)
+		if !c.detached && !c.closed {
+			conns = append(conns, c)
+		}
+		conns = append(conns, c.sessions...)
+		c.sessionLock.RUnlock()
+
+		for _, v := range conns {
+			if !yield(v) {
+				return
+			}
+		}
+	}
+}
+
+// WriteToConn writes m only to the connection of c, without sending it
+// to any of its sessions. This works even if c has been detached.
+func (c *Client) WriteToConn(m msg.Msg) {
+	c.writeMessage(m)
+}
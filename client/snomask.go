@@ -0,0 +1,90 @@
+package client
+
+import (
+	"sync/atomic"
+
+	"github.com/mitchr/gossip/scan/mode"
+)
+
+// A Snomask selects the categories of server notices that an operator
+// with user mode +s receives.
+type Snomask uint32
+
+const (
+	// clients connecting
+	SnoConnect Snomask = 1 << iota
+	// clients disconnecting
+	SnoExit
+	// KILLs and server bans
+	SnoKill
+	// clients that were disconnected for flooding
+	SnoFlood
+	// OPER attempts
+	SnoOper
+	// nick changes
+	SnoNick
+	// failed SASL logins
+	SnoAuth
+	// REHASH
+	SnoRehash
+)
+
+// AllSnomasks is given to operators that set +s without a mask.
+const AllSnomasks = SnoConnect | SnoExit | SnoKill | SnoFlood | SnoOper | SnoNick | SnoAuth | SnoRehash
+
+var snoLetter = map[byte]Snomask{
+	'c': SnoConnect,
+	'C': SnoExit,
+	'k': SnoKill,
+	'f': SnoFlood,
+	'o': SnoOper,
+	'n': SnoNick,
+	'a': SnoAuth,
+	'r': SnoRehash,
+}
+
+// letters are listed in this order
+const snoLetters = "cCkfonar"
+
+func (s Snomask) String() string {
+	str := "+"
+	for i := 0; i < len(snoLetters); i++ {
+		if s&snoLetter[snoLetters[i]] != 0 {
+			str += string(snoLetters[i])
+		}
+	}
+	return str
+}
+
+// Snomask returns the server notice mask of c.
+func (c *Client) Snomask() Snomask {
+	return Snomask(atomic.LoadUint32(&c.snomask))
+}
+
+func (c *Client) SetSnomask(s Snomask) {
+	atomic.StoreUint32(&c.snomask, uint32(s))
+}
+
+// HasSnomask returns true if c receives server notices of category s.
+func (c *Client) HasSnomask(s Snomask) bool {
+	return c.Is(ServerNotice) && c.Snomask()&s != 0
+}
+
+// ApplySnomask adds or removes categories from the server notice mask
+// of c according to modes. Unknown letters are ignored. If the mask
+// becomes empty, +s is removed.
+func (c *Client) ApplySnomask(modes []mode.Mode) {
+	mask := c.Snomask()
+	for _, m := range modes {
+		if m.Type == mode.Remove {
+			mask &^= snoLetter[m.ModeChar]
+		} else {
+			mask |= snoLetter[m.ModeChar]
+		}
+	}
+	c.SetSnomask(mask)
+
+	if mask == 0 {
+		c.UnsetMode(ServerNotice)
+	}
+}
@@ -0,0 +1,86 @@
+// Package cloak hides the hosts of clients behind keyed hashes.
+//
+// Cloaks keep the structure of the host that they hide, so that a ban
+// on a cloak can cover a whole network or domain. An IPv4 address
+// a.b.c.d becomes
+//
+//	H(a.b.c.d).H(a.b.c).H(a.b).IP
+//
+// so *.H(a.b.c).H(a.b).IP covers a.b.c.0/24, and *.H(a.b).IP covers
+// a.b.0.0/16. IPv6 addresses are cloaked the same way with their /64
+// and /48 prefixes, separated with colons. Hostnames keep their domain,
+// and only their first label is replaced.
+package cloak
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"strings"
+)
+
+type Cloaker struct {
+	secret []byte
+	prefix string
+}
+
+// New creates a Cloaker that hashes with secret. Cloaked hostnames
+// start with prefix.
+func New(secret, prefix string) *Cloaker {
+	return &Cloaker{[]byte(secret), prefix}
+}
+
+// hash returns a short keyed digest of s.
+func (c *Cloaker) hash(s string) string {
+	mac := hmac.New(sha256.New, c.secret)
+	mac.Write([]byte(s))
+	return strings.ToUpper(hex.EncodeToString(mac.Sum(nil)[:4]))
+}
+
+// Host returns the cloak of host, which is either an IP address or a
+// hostname.
+func (c *Cloaker) Host(host string) string {
+	ip := net.ParseIP(host)
+	if ip4 := ip.To4(); ip4 != nil {
+		return c.ipv4(ip4)
+	} else if ip != nil {
+		return c.ipv6(ip)
+	}
+	return c.hostname(host)
+}
+
+func (c *Cloaker) ipv4(ip net.IP) string {
+	full := ip.String()
+	net24 := ip.Mask(net.CIDRMask(24, 32)).String()
+	net16 := ip.Mask(net.CIDRMask(16, 32)).String()
+	return c.hash(full) + "." + c.hash(net24) + "." + c.hash(net16) + ".IP"
+}
+
+func (c *Cloaker) ipv6(ip net.IP) string {
+	full := ip.String()
+	net64 := ip.Mask(net.CIDRMask(64, 128)).String()
+	net48 := ip.Mask(net.CIDRMask(48, 128)).String()
+	return c.hash(full) + ":" + c.hash(net64) + ":" + c.hash(net48) + ":IP"
+}
+
+func (c *Cloaker) hostname(host string) string {
+	host = strings.ToLower(host)
+	labels := strings.Split(host, ".")
+
+	// keep the domain; a host with only two labels is its own domain,
+	// so only keep the top level domain
+	keep := 1
+	if len(labels) > 2 {
+		keep = len(labels) - 1
+	}
+	if len(labels) == 1 {
+		keep = 0
+	}
+
+	cloak := c.prefix + "-" + c.hash(host)
+	if keep > 0 {
+		cloak += "." + strings.Join(labels[len(labels)-keep:], ".")
+	}
+	return cloak
+}
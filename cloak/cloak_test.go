@@ -0,0 +1,56 @@
+package cloak
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHost(t *testing.T) {
+	c := New("secret", "gossip")
+
+	tests := []struct {
+		host, suffix string
+	}{
+		{"irc.example.com", ".example.com"},
+		{"example.com", ".com"},
+		{"localhost", ""},
+		{"192.0.2.1", ".IP"},
+		{"2001:db8::1", ":IP"},
+	}
+	for _, test := range tests {
+		cloak := c.Host(test.host)
+		if !strings.HasSuffix(cloak, test.suffix) || strings.Contains(cloak, test.host) {
+			t.Errorf("bad cloak %q for %q", cloak, test.host)
+		}
+		if cloak != c.Host(test.host) {
+			t.Error("cloak is not stable for", test.host)
+		}
+	}
+
+	if New("other", "gossip").Host("192.0.2.1") == c.Host("192.0.2.1") {
+		t.Error("cloak does not depend on the secret")
+	}
+}
+
+func TestPrefixes(t *testing.T) {
+	c := New("secret", "gossip")
+
+	a := strings.Split(c.Host("192.0.2.1"), ".")
+	b := strings.Split(c.Host("192.0.2.200"), ".")
+	other := strings.Split(c.Host("192.0.3.1"), ".")
+
+	// same /24
+	if a[0] == b[0] || a[1] != b[1] || a[2] != b[2] {
+		t.Error("expected shared /24:", a, b)
+	}
+	// same /16, different /24
+	if a[1] == other[1] || a[2] != other[2] {
+		t.Error("expected shared /16:", a, other)
+	}
+
+	v6a := strings.Split(c.Host("2001:db8:1:2::1"), ":")
+	v6b := strings.Split(c.Host("2001:db8:1:2::2"), ":")
+	if v6a[0] == v6b[0] || v6a[1] != v6b[1] || v6a[2] != v6b[2] {
+		t.Error("expected shared /64:", v6a, v6b)
+	}
+}
@@ -30,6 +30,12 @@ BASE_CONFIG = {
     }
   },
   "motd": "",
+  "cloak": {
+    "disabled": True
+  },
+  "accountRegistration": {
+    "minPasswordLength": 1
+  },
   "ops": {
     "operuser": "JDJhJDEwJEQ0TldiOTJUVVR5MW1nQmtOZjNweXVkSTBHUjE4Y05jNlZYN1hpWDRndDcvUGFidjczZzhD" #operpassword
   }
@@ -147,9 +153,9 @@ class GossipController(BaseServerController, DirectoryBasedController):
             pass
         case.getMessages(client)
         assert password
-        case.sendLine(client, "REGISTER PASS " + password)
+        case.sendLine(client, "REGISTER * * " + password)
         msg = case.getMessage(client)
-        assert msg.params == ["Registered"]
+        assert msg.command == "REGISTER" and msg.params[0] == "SUCCESS"
         case.sendLine(client, "QUIT")
         case.assertDisconnected(client)
 
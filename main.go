@@ -3,8 +3,10 @@ package main
 import (
 	"flag"
 	"log"
+	"log/slog"
 	"os"
 	"os/signal"
+	"syscall"
 
 	"github.com/mitchr/gossip/server"
 )
@@ -19,18 +21,13 @@ var (
 func init() {
 	flag.BoolVar(&sPass, "s", false, "sets server password")
 	flag.BoolVar(&oPass, "o", false, "add a server operator (username and pass)")
-	flag.BoolVar(&debug, "d", false, "print incoming messages to stdout")
+	flag.BoolVar(&debug, "d", false, "log at debug level, including every incoming message")
 	flag.StringVar(&confPath, "conf", "config.json", "path to the config file")
 	flag.Parse()
 }
 
 func main() {
-	confFile, err := os.Open(confPath)
-	if err != nil {
-		log.Fatalln(err)
-	}
-
-	c, err := server.NewConfig(confFile)
+	c, err := server.ReadConfig(confPath)
 	if err != nil {
 		log.Fatalln(err)
 	}
@@ -59,20 +56,50 @@ func main() {
 		return
 	}
 
-	s, err := server.New(c)
+	var s *server.Server
+	if server.Upgraded() {
+		s, err = server.Resume(c)
+	} else {
+		s, err = server.New(c)
+	}
 	if err != nil {
 		log.Fatalln(err)
 	}
+	slog.SetDefault(s.Logger())
 
 	// capture OS interrupt signal so that we can gracefully shutdown server
 	interrupt := make(chan os.Signal, 1)
 	signal.Notify(interrupt, os.Interrupt)
 
+	// reload the config on SIGHUP
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+
+	// hand everything over to a new binary on SIGUSR2
+	upgrade := make(chan os.Signal, 1)
+	if len(upgradeSignals) > 0 {
+		signal.Notify(upgrade, upgradeSignals...)
+	}
+
 	go s.Serve()
 
-	<-interrupt
-	err = s.Close()
-	if err != nil {
-		log.Fatalln(err)
+	for {
+		select {
+		case <-hangup:
+			if err := s.Rehash(); err != nil {
+				s.Logger().Error("could not rehash", "err", err)
+			}
+		case <-upgrade:
+			if err := s.Upgrade(); err != nil {
+				s.Logger().Error("could not upgrade", "err", err)
+				continue
+			}
+			return
+		case <-interrupt:
+			if err := s.Close(); err != nil {
+				log.Fatalln(err)
+			}
+			return
+		}
 	}
 }
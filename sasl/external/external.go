@@ -5,6 +5,8 @@ import (
 	"crypto/sha256"
 	"crypto/subtle"
 	"database/sql"
+	"errors"
+	"log/slog"
 
 	"github.com/mitchr/gossip/client"
 	"github.com/mitchr/gossip/sasl"
@@ -58,5 +60,8 @@ func (e *External) lookup(username string) (*Credential, error) {
 	c := &Credential{}
 	row := e.db.QueryRow("SELECT username, clientCert FROM sasl_external WHERE username = ?", username)
 	err := row.Scan(&c.Username, &c.Cert)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		slog.Error("could not look up credential", "mechanism", "EXTERNAL", "username", username, "err", err)
+	}
 	return c, err
 }
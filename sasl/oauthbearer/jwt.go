@@ -0,0 +1,195 @@
+package oauthbearer
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMalformedToken = errors.New("malformed token")
+	ErrBadSignature   = errors.New("token signature is invalid")
+	ErrExpired        = errors.New("token is expired or not yet valid")
+	ErrBadClaims      = errors.New("token has the wrong issuer or audience")
+	ErrNoAccount      = errors.New("token does not name an account")
+)
+
+// clock skew that is tolerated when checking exp and nbf
+const leeway = time.Minute
+
+// A Verifier checks JWTs against a fixed set of public keys. No network
+// requests are ever made.
+type Verifier struct {
+	keys []key
+
+	// If set, the iss claim must match Issuer, and the aud claim must
+	// contain Audience.
+	Issuer, Audience string
+
+	// the claim that holds the name of the account; defaults to sub
+	Claim string
+
+	now func() time.Time
+}
+
+// NewVerifier loads public keys from paths, each of which is either a
+// JWKS document or PEM file.
+func NewVerifier(paths []string, issuer, audience, claim string) (*Verifier, error) {
+	v := &Verifier{Issuer: issuer, Audience: audience, Claim: claim, now: time.Now}
+	if v.Claim == "" {
+		v.Claim = "sub"
+	}
+
+	for _, p := range paths {
+		keys, err := loadKeys(p)
+		if err != nil {
+			return nil, err
+		}
+		v.keys = append(v.keys, keys...)
+	}
+	if len(v.keys) == 0 {
+		return nil, errors.New("oauthbearer: no public keys were loaded")
+	}
+	return v, nil
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// Verify checks the signature and claims of token, and returns the
+// account that it was issued for.
+func (v *Verifier) Verify(token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", ErrMalformedToken
+	}
+
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return "", ErrMalformedToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", ErrMalformedToken
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	if !slices.ContainsFunc(v.keys, func(k key) bool {
+		return (h.Kid == "" || k.kid == "" || k.kid == h.Kid) && verifySignature(h.Alg, k.pub, signed, sig)
+	}) {
+		return "", ErrBadSignature
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return "", ErrMalformedToken
+	}
+	return v.checkClaims(claims)
+}
+
+func (v *Verifier) checkClaims(claims map[string]any) (string, error) {
+	now := v.now()
+
+	// tokens must expire
+	exp, ok := claims["exp"].(float64)
+	if !ok || now.After(time.Unix(int64(exp), 0).Add(leeway)) {
+		return "", ErrExpired
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(leeway).Before(time.Unix(int64(nbf), 0)) {
+		return "", ErrExpired
+	}
+
+	if v.Issuer != "" && claims["iss"] != v.Issuer {
+		return "", ErrBadClaims
+	}
+	if v.Audience != "" {
+		switch aud := claims["aud"].(type) {
+		case string:
+			if aud != v.Audience {
+				return "", ErrBadClaims
+			}
+		case []any:
+			if !slices.Contains(aud, any(v.Audience)) {
+				return "", ErrBadClaims
+			}
+		default:
+			return "", ErrBadClaims
+		}
+	}
+
+	account, ok := claims[v.Claim].(string)
+	if !ok || account == "" {
+		return "", ErrNoAccount
+	}
+	return account, nil
+}
+
+func decodeSegment(seg string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+var esCurves = map[string]elliptic.Curve{
+	"ES256": elliptic.P256(),
+	"ES384": elliptic.P384(),
+	"ES512": elliptic.P521(),
+}
+
+// verifySignature returns true if sig is a valid signature of signed
+// using alg. The key must be of the type that alg calls for; in
+// particular, alg "none" is never accepted.
+func verifySignature(alg string, pub crypto.PublicKey, signed, sig []byte) bool {
+	var hash crypto.Hash
+	switch alg[min(len(alg), 2):] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	}
+
+	var digest []byte
+	if hash != 0 {
+		h := hash.New()
+		h.Write(signed)
+		digest = h.Sum(nil)
+	}
+
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		switch {
+		case strings.HasPrefix(alg, "RS") && hash != 0:
+			return rsa.VerifyPKCS1v15(k, hash, digest, sig) == nil
+		case strings.HasPrefix(alg, "PS") && hash != 0:
+			return rsa.VerifyPSS(k, hash, digest, sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}) == nil
+		}
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if esCurves[alg] != k.Curve || len(sig) != 2*size {
+			return false
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		return ecdsa.Verify(k, digest, r, s)
+	case ed25519.PublicKey:
+		return alg == "EdDSA" && ed25519.Verify(k, signed, sig)
+	}
+	return false
+}
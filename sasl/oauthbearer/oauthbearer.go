@@ -0,0 +1,71 @@
+// Implementation of SASL OAUTHBEARER (RFC 7628)
+package oauthbearer
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"strings"
+
+	"github.com/mitchr/gossip/sasl"
+)
+
+type OAuthBearer struct {
+	v    *Verifier
+	step int
+
+	account string
+}
+
+func New(v *Verifier) *OAuthBearer { return &OAuthBearer{v: v} }
+
+func (o *OAuthBearer) Authn() string { return o.account }
+
+func (o *OAuthBearer) Next(clientResponse []byte) (challenge []byte, err error) {
+	defer func() { o.step++ }()
+
+	switch o.step {
+	case 0:
+		token, err := parseInitialResponse(clientResponse)
+		if err != nil {
+			return nil, err
+		}
+
+		account, err := o.v.Verify(token)
+		if err != nil {
+			slog.Debug("rejected bearer token", "err", err)
+			// the client has to acknowledge the error before the exchange
+			// fails (RFC 7628 section 3.2.2)
+			return []byte(`{"status":"invalid_token"}`), nil
+		}
+		o.account = account
+		return nil, nil
+	default:
+		return nil, sasl.ErrSaslFail
+	}
+}
+
+// parseInitialResponse extracts the bearer token from the client's
+// initial response.
+//
+// gs2-header kvsep *(key "=" value kvsep) kvsep
+func parseInitialResponse(b []byte) (string, error) {
+	gs2, rest, ok := bytes.Cut(b, []byte{1})
+	if !ok || (!bytes.HasPrefix(gs2, []byte("n,")) && !bytes.HasPrefix(gs2, []byte("y,"))) {
+		return "", errors.New("malformed OAUTHBEARER response")
+	}
+
+	for _, kv := range bytes.Split(rest, []byte{1}) {
+		k, v, _ := strings.Cut(string(kv), "=")
+		if k != "auth" {
+			continue
+		}
+
+		scheme, token, ok := strings.Cut(v, " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") {
+			break
+		}
+		return strings.TrimSpace(token), nil
+	}
+	return "", errors.New("missing bearer token")
+}
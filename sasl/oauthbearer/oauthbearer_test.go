@@ -0,0 +1,196 @@
+package oauthbearer
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mitchr/gossip/sasl"
+)
+
+func TestVerify(t *testing.T) {
+	dir := t.TempDir()
+
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	edPub, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	// the rsa key is in a PEM file, while the others are in a JWKS
+	der, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	pemPath := filepath.Join(dir, "rsa.pem")
+	os.WriteFile(pemPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600)
+
+	jwks := map[string]any{"keys": []map[string]string{
+		{"kty": "EC", "kid": "ec", "crv": "P-256", "x": b64(ecKey.X.Bytes()), "y": b64(ecKey.Y.Bytes())},
+		{"kty": "OKP", "kid": "ed", "crv": "Ed25519", "x": b64(edPub)},
+	}}
+	jwksPath := filepath.Join(dir, "jwks.json")
+	b, _ := json.Marshal(jwks)
+	os.WriteFile(jwksPath, b, 0600)
+
+	v, err := NewVerifier([]string{pemPath, jwksPath}, "https://sso.example.com", "gossip", "preferred_username")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid := map[string]any{
+		"iss":                "https://sso.example.com",
+		"aud":                []string{"other", "gossip"},
+		"exp":                time.Now().Add(time.Hour).Unix(),
+		"preferred_username": "alice",
+	}
+	with := func(k string, val any) map[string]any {
+		c := map[string]any{}
+		for k, v := range valid {
+			c[k] = v
+		}
+		if val == nil {
+			delete(c, k)
+		} else {
+			c[k] = val
+		}
+		return c
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{"RS256", sign(t, "RS256", "", rsaKey, valid), nil},
+		{"PS384", sign(t, "PS384", "", rsaKey, valid), nil},
+		{"ES256", sign(t, "ES256", "ec", ecKey, valid), nil},
+		{"EdDSA", sign(t, "EdDSA", "ed", edKey, valid), nil},
+		{"WrongKid", sign(t, "ES256", "ed", ecKey, valid), ErrBadSignature},
+		{"AlgMismatch", sign(t, "ES384", "ec", ecKey, valid), ErrBadSignature},
+		{"None", b64([]byte(`{"alg":"none"}`)) + "." + b64([]byte(`{"sub":"alice"}`)) + ".", ErrBadSignature},
+		{"Expired", sign(t, "EdDSA", "ed", edKey, with("exp", time.Now().Add(-time.Hour).Unix())), ErrExpired},
+		{"NoExpiry", sign(t, "EdDSA", "ed", edKey, with("exp", nil)), ErrExpired},
+		{"NotYetValid", sign(t, "EdDSA", "ed", edKey, with("nbf", time.Now().Add(time.Hour).Unix())), ErrExpired},
+		{"WrongIssuer", sign(t, "EdDSA", "ed", edKey, with("iss", "https://evil.com")), ErrBadClaims},
+		{"WrongAudience", sign(t, "EdDSA", "ed", edKey, with("aud", "other")), ErrBadClaims},
+		{"NoAccount", sign(t, "EdDSA", "ed", edKey, with("preferred_username", nil)), ErrNoAccount},
+		{"Malformed", "abc", ErrMalformedToken},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			account, err := v.Verify(test.token)
+			if err != test.err {
+				t.Fatal("expected", test.err, "got", err)
+			}
+			if err == nil && account != "alice" {
+				t.Error("expected alice, got", account)
+			}
+		})
+	}
+
+	t.Run("Tampered", func(t *testing.T) {
+		token := sign(t, "RS256", "", rsaKey, valid)
+		other := sign(t, "RS256", "", rsaKey, with("preferred_username", "mallory"))
+		parts, otherParts := strings.Split(token, "."), strings.Split(other, ".")
+		if _, err := v.Verify(parts[0] + "." + otherParts[1] + "." + parts[2]); err != ErrBadSignature {
+			t.Error("expected", ErrBadSignature, "got", err)
+		}
+	})
+}
+
+func TestOAUTHBEARER(t *testing.T) {
+	dir := t.TempDir()
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	der, _ := x509.MarshalPKIXPublicKey(pub)
+	path := filepath.Join(dir, "key.pem")
+	os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600)
+
+	v, err := NewVerifier([]string{path}, "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := sign(t, "EdDSA", "", priv, map[string]any{"sub": "bob", "exp": time.Now().Add(time.Hour).Unix()})
+
+	t.Run("Success", func(t *testing.T) {
+		o := New(v)
+		challenge, err := o.Next([]byte("n,a=bob,\x01host=irc.example.com\x01auth=Bearer " + token + "\x01\x01"))
+		if challenge != nil || err != nil {
+			t.Fatal(string(challenge), err)
+		}
+		if o.Authn() != "bob" {
+			t.Error("expected bob, got", o.Authn())
+		}
+	})
+
+	t.Run("InvalidToken", func(t *testing.T) {
+		o := New(v)
+		challenge, err := o.Next([]byte("n,,\x01auth=Bearer " + token + "x\x01\x01"))
+		if string(challenge) != `{"status":"invalid_token"}` || err != nil {
+			t.Fatal(string(challenge), err)
+		}
+
+		_, err = o.Next([]byte{1})
+		if err != sasl.ErrSaslFail {
+			t.Error("expected failure, got", err)
+		}
+	})
+
+	t.Run("Malformed", func(t *testing.T) {
+		o := New(v)
+		if _, err := o.Next([]byte("auth=Bearer " + token)); err == nil {
+			t.Error("expected error")
+		}
+	})
+}
+
+func b64(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+
+// sign creates a JWT with the given claims.
+func sign(t *testing.T, alg, kid string, key crypto.Signer, claims map[string]any) string {
+	t.Helper()
+
+	header := map[string]string{"alg": alg, "typ": "JWT"}
+	if kid != "" {
+		header["kid"] = kid
+	}
+	h, _ := json.Marshal(header)
+	c, _ := json.Marshal(claims)
+	signed := b64(h) + "." + b64(c)
+
+	var sig []byte
+	var err error
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		hash := crypto.SHA256
+		if alg == "PS384" {
+			hash = crypto.SHA384
+		}
+		hh := hash.New()
+		hh.Write([]byte(signed))
+		if alg[0] == 'P' {
+			sig, err = rsa.SignPSS(rand.Reader, k, hash, hh.Sum(nil), &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		} else {
+			sig, err = rsa.SignPKCS1v15(rand.Reader, k, hash, hh.Sum(nil))
+		}
+	case *ecdsa.PrivateKey:
+		digest := sha256.Sum256([]byte(signed))
+		r, s, e := ecdsa.Sign(rand.Reader, k, digest[:])
+		sig, err = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...), e
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(k, []byte(signed))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + b64(sig)
+}
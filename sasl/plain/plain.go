@@ -6,6 +6,7 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"errors"
+	"log/slog"
 
 	"github.com/mitchr/gossip/sasl"
 	"golang.org/x/crypto/bcrypt"
@@ -67,5 +68,8 @@ func (p *Plain) lookup(username string) (*Credential, error) {
 	c := &Credential{}
 	row := p.db.QueryRow("SELECT username, pass FROM sasl_plain WHERE username = ?", username)
 	err := row.Scan(&c.Username, &c.Pass)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		slog.Error("could not look up credential", "mechanism", "PLAIN", "username", username, "err", err)
+	}
 	return c, err
 }
@@ -30,3 +30,12 @@ type None struct{}
 func (n None) Next([]byte) ([]byte, error) { return nil, nil }
 
 func (n None) Authn() string { return "*" }
+
+// Account is a placeholder mechanism for clients that are already known
+// to be logged in to an account, without having authenticated on this
+// connection.
+type Account string
+
+func (a Account) Next([]byte) ([]byte, error) { return nil, ErrSaslFail }
+
+func (a Account) Authn() string { return string(a) }
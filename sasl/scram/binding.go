@@ -0,0 +1,48 @@
+package scram
+
+import (
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+)
+
+// Channel binding types (RFC 9266 and RFC 5929)
+const (
+	TLSExporter       = "tls-exporter"
+	TLSServerEndPoint = "tls-server-end-point"
+)
+
+// ChannelBinding describes the tls connection that a SCRAM exchange is
+// bound to.
+type ChannelBinding struct {
+	State tls.ConnectionState
+
+	// the certificate that the server presented on this connection
+	Cert *x509.Certificate
+}
+
+func (b *ChannelBinding) data(cbType string) ([]byte, error) {
+	switch cbType {
+	case TLSExporter:
+		return b.State.ExportKeyingMaterial("EXPORTER-Channel-Binding", nil, 32)
+	case TLSServerEndPoint:
+		if b.Cert == nil {
+			return nil, errors.New("no server certificate")
+		}
+
+		// use the hash from the certificate's signature algorithm,
+		// upgrading anything weaker than (or without) one to SHA-256
+		h := crypto.SHA256
+		switch b.Cert.SignatureAlgorithm {
+		case x509.SHA384WithRSA, x509.ECDSAWithSHA384, x509.SHA384WithRSAPSS:
+			h = crypto.SHA384
+		case x509.SHA512WithRSA, x509.ECDSAWithSHA512, x509.SHA512WithRSAPSS:
+			h = crypto.SHA512
+		}
+		hash := h.New()
+		hash.Write(b.Cert.Raw)
+		return hash.Sum(nil), nil
+	}
+	return nil, errors.New("unknown channel binding type")
+}
@@ -2,7 +2,11 @@ package scram
 
 import (
 	"crypto/hmac"
+	"crypto/sha512"
+	"database/sql"
+	"errors"
 	"hash"
+	"log/slog"
 
 	"golang.org/x/crypto/pbkdf2"
 )
@@ -44,10 +48,23 @@ func NewCredential(hash func() hash.Hash, uname, pass string, salt []byte, iter
 	return c
 }
 
+// Table returns the name of the table that credentials using hash are
+// stored in. SHA-512 credentials are kept apart from the rest, so that
+// an account can have one of each.
+func Table(hash func() hash.Hash) string {
+	if hash != nil && hash().Size() == sha512.Size {
+		return "sasl_scram_sha512"
+	}
+	return "sasl_scram"
+}
+
 func (s *Scram) lookup(username string) (*Credential, error) {
-	row := s.db.QueryRow("SELECT username, serverKey, storedKey, salt, iterations	FROM sasl_scram WHERE username = ?", username)
+	row := s.db.QueryRow("SELECT username, serverKey, storedKey, salt, iterations	FROM "+Table(s.hash)+" WHERE username = ?", username)
 
 	c := &Credential{}
 	err := row.Scan(&c.Username, &c.ServerKey, &c.StoredKey, &c.Salt, &c.Iteration)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		slog.Error("could not look up credential", "mechanism", "SCRAM", "username", username, "err", err)
+	}
 	return c, err
 }
@@ -17,7 +17,14 @@ type Scram struct {
 	db   *sql.DB
 	step int
 
-	// gs2Header string
+	// binding is nil if the client's connection does not support
+	// channel binding. If plus is true, the client must use it.
+	binding *ChannelBinding
+	plus    bool
+
+	gs2Header string
+	cbType    string
+
 	nonce string
 	proof []byte // sent from client
 
@@ -59,7 +66,18 @@ func (s *Scram) Next(clientResponse []byte) (challenge []byte, err error) {
 	return nil, nil
 }
 
-func New(db *sql.DB, h func() hash.Hash) *Scram { return &Scram{db: db, hash: h} }
+// New returns a SCRAM mechanism that does not use channel binding. b
+// describes the client's connection, and is only used to detect
+// downgrade attacks; it may be nil.
+func New(db *sql.DB, h func() hash.Hash, b *ChannelBinding) *Scram {
+	return &Scram{db: db, hash: h, binding: b}
+}
+
+// NewPlus returns a SCRAM-*-PLUS mechanism, which requires the client
+// to bind the exchange to its connection.
+func NewPlus(db *sql.DB, h func() hash.Hash, b *ChannelBinding) *Scram {
+	return &Scram{db: db, hash: h, binding: b, plus: true}
+}
 
 func (s *Scram) ParseClientFirst(m string) error {
 	attrs := strings.Split(m, ",")
@@ -68,6 +86,11 @@ func (s *Scram) ParseClientFirst(m string) error {
 	}
 
 	// attrs[1] is unused as we do not take advantage of authzid
+	err := s.parseGS2Flag(attrs[0])
+	if err != nil {
+		return err
+	}
+	s.gs2Header = attrs[0] + "," + attrs[1] + ","
 
 	// grab username from db
 	cred, err := s.lookup(attrs[2][2:])
@@ -86,6 +109,32 @@ func (s *Scram) ParseClientFirst(m string) error {
 	return nil
 }
 
+// parseGS2Flag checks that the channel binding flag sent by the client
+// is appropriate for this mechanism.
+func (s *Scram) parseGS2Flag(flag string) error {
+	switch {
+	case flag == "n":
+		if s.plus {
+			return errors.New("e=channel-binding-not-supported")
+		}
+	case flag == "y":
+		// the client thinks that the server does not support channel
+		// binding, which is only true if nobody tampered with our list
+		// of mechanisms
+		if s.plus || s.binding != nil {
+			return errors.New("e=server-does-support-channel-binding")
+		}
+	case strings.HasPrefix(flag, "p="):
+		if !s.plus || s.binding == nil {
+			return errors.New("e=channel-binding-not-supported")
+		}
+		s.cbType = flag[2:]
+	default:
+		return errors.New("e=other-error")
+	}
+	return nil
+}
+
 func (s *Scram) GenServerFirst() []byte {
 	s.serverFirst = fmt.Sprintf("r=%s,s=%s,i=%d",
 		s.nonce,
@@ -101,7 +150,11 @@ func (s *Scram) ParseClientFinal(m string) error {
 		return errors.New("e=other-error")
 	}
 
-	// attrs[0] is unused since we don't use channel binding
+	err := s.checkBinding(attrs[0][2:])
+	if err != nil {
+		return err
+	}
+
 	nonce := attrs[1][2:]
 	if nonce != s.nonce {
 		return errors.New("e=other-error")
@@ -118,6 +171,26 @@ func (s *Scram) ParseClientFinal(m string) error {
 	return nil
 }
 
+// checkBinding makes sure that the channel binding sent by the client
+// in its final message matches what it asked for in its first message,
+// and, for -PLUS mechanisms, that it matches our side of the
+// connection.
+func (s *Scram) checkBinding(c string) error {
+	expected := []byte(s.gs2Header)
+	if s.cbType != "" {
+		data, err := s.binding.data(s.cbType)
+		if err != nil {
+			return errors.New("e=unsupported-channel-binding-type")
+		}
+		expected = append(expected, data...)
+	}
+
+	if c != base64.StdEncoding.EncodeToString(expected) {
+		return errors.New("e=channel-bindings-dont-match")
+	}
+	return nil
+}
+
 func (s *Scram) GenServerFinal() ([]byte, error) {
 	authMsg := []byte(fmt.Sprintf("%s,%s,%s", s.clientFirstBare, s.serverFirst, s.clientFinalWithoutProof))
 
@@ -55,7 +55,7 @@ func TestSCRAM(t *testing.T) {
 		cred := NewCredential(v.hash, "user", v.pass, v.salt, v.iter)
 		DB.Exec("INSERT INTO sasl_scram VALUES(?, ?, ?, ?, ?)", cred.Username, cred.ServerKey, cred.StoredKey, cred.Salt, cred.Iteration)
 
-		s := New(DB, v.hash)
+		s := New(DB, v.hash, nil)
 		s.ParseClientFirst(v.clientFirst)
 		s.nonce = v.sNonce
 
@@ -74,6 +74,53 @@ func TestSCRAM(t *testing.T) {
 	}
 }
 
+func TestChannelBindingFlag(t *testing.T) {
+	DB, err := initTable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cred := NewCredential(sha256.New, "user", "pencil", []byte("salt"), 4096)
+	DB.Exec("INSERT INTO sasl_scram VALUES(?, ?, ?, ?, ?)", cred.Username, cred.ServerKey, cred.StoredKey, cred.Salt, cred.Iteration)
+
+	tests := []struct {
+		s           *Scram
+		clientFirst string
+		err         string
+	}{
+		{New(DB, sha256.New, nil), "y,,n=user,r=abc", ""},
+		{New(DB, sha256.New, &ChannelBinding{}), "y,,n=user,r=abc", "e=server-does-support-channel-binding"},
+		{New(DB, sha256.New, &ChannelBinding{}), "p=tls-exporter,,n=user,r=abc", "e=channel-binding-not-supported"},
+		{NewPlus(DB, sha256.New, &ChannelBinding{}), "n,,n=user,r=abc", "e=channel-binding-not-supported"},
+		{NewPlus(DB, sha256.New, &ChannelBinding{}), "p=tls-exporter,,n=user,r=abc", ""},
+	}
+
+	for _, v := range tests {
+		err := v.s.ParseClientFirst(v.clientFirst)
+		if (err == nil && v.err != "") || (err != nil && err.Error() != v.err) {
+			t.Errorf("%s: expected %q, got %v", v.clientFirst, v.err, err)
+		}
+	}
+}
+
+func TestChannelBindingMismatch(t *testing.T) {
+	DB, err := initTable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cred := NewCredential(sha256.New, "user", "pencil", []byte("salt"), 4096)
+	DB.Exec("INSERT INTO sasl_scram VALUES(?, ?, ?, ?, ?)", cred.Username, cred.ServerKey, cred.StoredKey, cred.Salt, cred.Iteration)
+
+	s := New(DB, sha256.New, nil)
+	s.ParseClientFirst("n,,n=user,r=abc")
+	s.GenServerFirst()
+
+	// c=eSws is "y,,", which does not match the header that was sent
+	err = s.ParseClientFinal("c=eSws,r=" + s.nonce + ",p=AAAA")
+	if err == nil || err.Error() != "e=channel-bindings-dont-match" {
+		t.Error("expected binding mismatch, got", err)
+	}
+}
+
 func TestSCRAMLookup(t *testing.T) {
 	DB, err := initTable()
 	if err != nil {
@@ -0,0 +1,107 @@
+// format finds and strips the formatting codes that clients use to
+// style messages. The codes are described at
+// https://modern.ircdocs.horse/formatting.html.
+package format
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// \x03, followed by up to two digits for the foreground, and
+	// optionally a comma and up to two digits for the background
+	color = '\x03'
+	// \x04, followed by six hex digits for the foreground, and
+	// optionally a comma and six hex digits for the background
+	hexColor = '\x04'
+)
+
+// isControl returns true for the codes that don't take any parameters:
+// bold, italics, underline, strikethrough, monospace, reverse, and
+// reset.
+func isControl(b byte) bool {
+	switch b {
+	case '\x02', '\x1d', '\x1f', '\x1e', '\x11', '\x16', '\x0f':
+		return true
+	default:
+		return false
+	}
+}
+
+func isDigit(b byte) bool { return b >= '0' && b <= '9' }
+
+func isHexDigit(b byte) bool {
+	return isDigit(b) || (b >= 'a' && b <= 'f') || (b >= 'A' && b <= 'F')
+}
+
+// skipColor returns the index of the first byte after the color that
+// starts at s[i], where each color is at most n digits. A background
+// color can only be given along with a foreground color.
+func skipColor(s string, i, n int, isDigit func(byte) bool) int {
+	j := skipDigits(s, i, n, isDigit)
+	if j == i || j+1 >= len(s) || s[j] != ',' || !isDigit(s[j+1]) {
+		// if there is a comma, it is part of the text
+		return j
+	}
+	return skipDigits(s, j+1, n, isDigit)
+}
+
+// skipDigits returns the index of the first byte after the (at most n)
+// digits that start at s[i].
+func skipDigits(s string, i, n int, isDigit func(byte) bool) int {
+	end := min(i+n, len(s))
+	for i < end && isDigit(s[i]) {
+		i++
+	}
+	return i
+}
+
+// scanText calls f with every run of text in s that is not part of a
+// formatting code. It returns true if s contained any formatting codes.
+//
+// Every code is a single ASCII byte, which never appears inside a
+// multibyte rune, so s is walked a rune at a time. Invalid UTF-8 and
+// U+FFFD are kept as text.
+func scanText(s string, f func(string)) bool {
+	found := false
+	start := 0
+	for i := 0; i < len(s); {
+		b := s[i]
+		if !isControl(b) && b != color && b != hexColor {
+			_, width := utf8.DecodeRuneInString(s[i:])
+			i += width
+			continue
+		}
+
+		found = true
+		if start < i {
+			f(s[start:i])
+		}
+		switch b {
+		case color:
+			i = skipColor(s, i+1, 2, isDigit)
+		case hexColor:
+			i = skipColor(s, i+1, 6, isHexDigit)
+		default:
+			i++
+		}
+		start = i
+	}
+	if start < len(s) {
+		f(s[start:])
+	}
+	return found
+}
+
+// Strip returns s with every formatting code removed.
+func Strip(s string) string {
+	var b strings.Builder
+	scanText(s, func(t string) { b.WriteString(t) })
+	return b.String()
+}
+
+// Contains returns true if s contains any formatting codes.
+func Contains(s string) bool {
+	return scanText(s, func(string) {})
+}
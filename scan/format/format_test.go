@@ -0,0 +1,38 @@
+package format
+
+import "testing"
+
+func TestStrip(t *testing.T) {
+	tests := []struct {
+		in, out  string
+		contains bool
+	}{
+		{"plain text", "plain text", false},
+		{"a, b", "a, b", false},
+		{"\x02bold\x02", "bold", true},
+		{"\x1ditalic\x1d \x1funderline\x1f \x1estrike\x1e \x11mono\x11 \x16reverse\x16\x0f", "italic underline strike mono reverse", true},
+		{"\x034red", "red", true},
+		{"\x0304red", "red", true},
+		{"\x03040red", "0red", true},
+		{"\x0304,12red on blue", "red on blue", true},
+		{"\x0304,red", ",red", true},
+		{"\x03,04text", ",04text", true},
+		{"\x03reset", "reset", true},
+		{"\x04ff0000red", "red", true},
+		{"\x04FF0000,00ff00red on green", "red on green", true},
+		{"\x04ff0000,zz", ",zz", true},
+		{"a\uFFFDb\x02c", "a\uFFFDbc", true},
+		{"invalid \xff\x02utf8", "invalid \xffutf8", true},
+	}
+
+	for _, v := range tests {
+		t.Run(v.out, func(t *testing.T) {
+			if s := Strip(v.in); s != v.out {
+				t.Errorf("expected %q, got %q", v.out, s)
+			}
+			if Contains(v.in) != v.contains {
+				t.Errorf("expected Contains to be %v", v.contains)
+			}
+		})
+	}
+}
@@ -1,6 +1,8 @@
 package mode
 
 import (
+	"time"
+
 	"github.com/mitchr/gossip/scan"
 )
 
@@ -37,6 +39,8 @@ type Mode struct {
 	Type     Type
 	// accepts a param if nonempty (used for channel modes)
 	Param string
+	// how long a channel list entry lasts; 0 if it does not expire
+	Duration time.Duration
 }
 
 func (m Mode) String() string {
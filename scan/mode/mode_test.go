@@ -10,21 +10,21 @@ func TestParseMode(t *testing.T) {
 		i string
 		m []Mode
 	}{
-		{"+m", []Mode{{'m', Add, ""}}},
-		{"+mb", []Mode{{'m', Add, ""}, {'b', Add, ""}}},
-		{"-i", []Mode{{'i', Remove, ""}}},
-		{"+a-i", []Mode{{'a', Add, ""}, {'i', Remove, ""}}},
-		{"i", []Mode{{'i', List, ""}}},
-		{"beI", []Mode{{'b', List, ""}, {'e', List, ""}, {'I', List, ""}}},
+		{"+m", []Mode{{'m', Add, "", 0}}},
+		{"+mb", []Mode{{'m', Add, "", 0}, {'b', Add, "", 0}}},
+		{"-i", []Mode{{'i', Remove, "", 0}}},
+		{"+a-i", []Mode{{'a', Add, "", 0}, {'i', Remove, "", 0}}},
+		{"i", []Mode{{'i', List, "", 0}}},
+		{"beI", []Mode{{'b', List, "", 0}, {'e', List, "", 0}, {'I', List, "", 0}}},
 		{"+a+b+c-de+f-g",
 			[]Mode{
-				{'a', Add, ""},
-				{'b', Add, ""},
-				{'c', Add, ""},
-				{'d', Remove, ""},
-				{'e', Remove, ""},
-				{'f', Add, ""},
-				{'g', Remove, ""},
+				{'a', Add, "", 0},
+				{'b', Add, "", 0},
+				{'c', Add, "", 0},
+				{'d', Remove, "", 0},
+				{'e', Remove, "", 0},
+				{'f', Add, "", 0},
+				{'g', Remove, "", 0},
 			},
 		},
 	}
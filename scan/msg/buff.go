@@ -7,27 +7,31 @@ import (
 type BatchType string
 
 const (
-	Label BatchType = "labeled-response"
+	Label              BatchType = "labeled-response"
+	ChatHistory        BatchType = "chathistory"
+	ChatHistoryTargets BatchType = "draft/chathistory-targets"
 )
 
 type Buffer []Msg
 
 func (b Buffer) Len() int { return len(b) }
 
-// Batch all messages together. The caller should not call AddMsg after WrapInBatch.
-func (b Buffer) WrapInBatch(batchType BatchType) Buffer {
-	// single responses don't need to be BATCHed
-	if b.Len() == 1 {
+// Batch all messages together. Any params are appended to the opening
+// BATCH message after the batch type. The caller should not call AddMsg
+// after WrapInBatch.
+func (b Buffer) WrapInBatch(batchType BatchType, params ...string) Buffer {
+	// single labeled responses don't need to be BATCHed
+	if batchType == Label && b.Len() == 1 {
 		return b
 	}
 
 	batchLabel := uuid.New().String()
-	start := New(nil, "", "", "", "BATCH", []string{"+" + batchLabel, string(batchType)}, false)
-	end := New(nil, "", "", "", "BATCH", []string{"-" + batchLabel}, false)
-	b = append([]Msg{start}, append(b, end)...)
-
+	// messages that already belong to a nested batch keep their own tag
 	b.AddTag("batch", batchLabel)
-	return b
+
+	start := New(nil, "", "", "", "BATCH", append([]string{"+" + batchLabel, string(batchType)}, params...), false)
+	end := New(nil, "", "", "", "BATCH", []string{"-" + batchLabel}, false)
+	return append([]Msg{start}, append(b, end)...)
 }
 
 func (b *Buffer) AddMsg(m Msg) {
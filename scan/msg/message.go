@@ -163,7 +163,12 @@ func (m Message) NUH() string {
 	}
 }
 
+// AddTag adds the tag k=v to m. If m already has a tag with the key k,
+// the existing tag is kept.
 func (m *Message) AddTag(k, v string) {
+	if exists, _ := m.HasTag(k); exists {
+		return
+	}
 	m.tags = append(m.tags, Tag{Key: k, Value: v})
 }
 
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mitchr/gossip/channel"
+	"github.com/mitchr/gossip/client"
+	"github.com/mitchr/gossip/scan/msg"
+)
+
+// ACCESS <channel> [LIST]
+// ACCESS <channel> ADD <account|mask> <prefix>
+// ACCESS <channel> DEL <account|mask>
+//
+// Manages the access list of a registered channel. Members whose
+// account or nickmask is on the list are given its prefix when they
+// join, or when they log in.
+func ACCESS(s *Server, c *client.Client, m *msg.Message) msg.Msg {
+	if len(m.Params) < 1 {
+		return prepMessage(ERR_NEEDMOREPARAMS, s.Name, c.Id(), "ACCESS")
+	}
+
+	ch, ok := s.getChannel(m.Params[0])
+	if !ok {
+		return prepMessage(ERR_NOSUCHCHANNEL, s.Name, c.Id(), m.Params[0])
+	}
+	if !ch.Registered {
+		return s.NOTICE(c, fmt.Sprintf("Channel %s is not registered", ch))
+	}
+
+	self, belongs := ch.GetMember(c.Nick)
+	subcommand := "LIST"
+	if len(m.Params) > 1 {
+		subcommand = strings.ToUpper(m.Params[1])
+	}
+
+	if subcommand == "LIST" {
+		if (!belongs || !self.Is(channel.Operator)) && !s.hasPrivilege(c, privOverride) {
+			return prepMessage(ERR_CHANOPRIVSNEEDED, s.Name, c.Id(), ch)
+		}
+
+		var buff msg.Buffer
+		for mask, p := range ch.Access {
+			buff.AddMsg(s.NOTICE(c, fmt.Sprintf("%s %s %s", ch, p, mask)))
+		}
+		buff.AddMsg(s.NOTICE(c, fmt.Sprintf("End of %s access list", ch)))
+		return buff
+	}
+
+	// only the founder can change the access list. Being given +q by
+	// the list is not enough, so the founder is matched by account.
+	if !ch.IsFounder(c) && !s.hasPrivilege(c, privOverride) {
+		return prepMessage(ERR_CHANOPRIVSNEEDED, s.Name, c.Id(), ch)
+	}
+
+	switch subcommand {
+	case "ADD":
+		if len(m.Params) < 4 {
+			return prepMessage(ERR_NEEDMOREPARAMS, s.Name, c.Id(), "ACCESS")
+		}
+		if len(m.Params[3]) != 1 {
+			return s.NOTICE(c, "Prefix must be one of ~&@%+")
+		}
+		p, ok := channel.MemberPrefix[m.Params[3][0]]
+		if !ok {
+			return s.NOTICE(c, "Prefix must be one of ~&@%+")
+		}
+		ch.SetAccess(m.Params[2], p)
+	case "DEL":
+		if len(m.Params) < 3 {
+			return prepMessage(ERR_NEEDMOREPARAMS, s.Name, c.Id(), "ACCESS")
+		}
+		if !ch.DeleteAccess(m.Params[2]) {
+			return s.NOTICE(c, fmt.Sprintf("%s is not on the %s access list", m.Params[2], ch))
+		}
+	default:
+		return prepMessage(ERR_UNKNOWNCOMMAND, s.Name, c.Id(), "ACCESS "+subcommand)
+	}
+
+	if err := s.saveChannel(ch); err != nil {
+		s.logger.Error("could not save channel", "channel", ch.String(), "err", err)
+		return s.NOTICE(c, "Could not update access list")
+	}
+	return s.NOTICE(c, fmt.Sprintf("Updated %s access list", ch))
+}
+
+// grantAccess gives c the prefixes that ch grants it. It returns the
+// MODE that announces the new prefixes, or nil if c was not given any.
+// The MODE is sent to every other member of ch.
+func (s *Server) grantAccess(ch *channel.Channel, c *client.Client) *msg.Message {
+	member, ok := ch.GetMember(c.Nick)
+	if !ok {
+		return nil
+	}
+	letters := ch.GrantAccess(member)
+	if letters == "" {
+		return nil
+	}
+
+	params := []string{ch.String(), "+" + letters}
+	for range letters {
+		params = append(params, c.Nick)
+	}
+	for other := range ch.AllExcept(c) {
+		other.WriteMessage(msg.New(nil, s.Name, "", "", "MODE", params, false))
+	}
+	s.propagateChan(ch, msg.New(nil, c.Nick, "", "", "MODE", params, false))
+	return msg.New(nil, s.Name, "", "", "MODE", params, false)
+}
+
+// grantAllAccess gives c the prefixes that every channel it belongs to
+// grants it. This is done once c logs in to an account.
+func (s *Server) grantAllAccess(c *client.Client) msg.Buffer {
+	var buff msg.Buffer
+	for ch := range s.channelsOf(c) {
+		if grant := s.grantAccess(ch, c); grant != nil {
+			buff.AddMsg(grant)
+		}
+	}
+	return buff
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/mitchr/gossip/sasl/plain"
+)
+
+func TestACCESS(t *testing.T) {
+	t.Parallel()
+
+	s, err := New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	go s.Serve()
+
+	cred := plain.NewCredential("bob", "tanstaaftanstaaf")
+	s.persistPlain(cred.Username, "robert", cred.Pass)
+	cred = plain.NewCredential("alice", "tanstaaftanstaaf")
+	s.persistPlain(cred.Username, "alice", cred.Pass)
+
+	alice, aliceR := s.connectAndAuthenticate("alice", "alice", "tanstaaftanstaaf")
+	defer alice.Close()
+	alice.Write([]byte("JOIN #team\r\nREGISTER #team\r\n"))
+	readLines(aliceR, 5)
+
+	t.Run("NotFounder", func(t *testing.T) {
+		c, r := s.connectAndRegister("eve")
+		defer c.Close()
+		c.Write([]byte("JOIN #team\r\n"))
+		readLines(r, 3)
+		readLines(aliceR, 1)
+
+		c.Write([]byte("ACCESS #team ADD eve ~\r\nPART #team\r\n"))
+		resp, _ := r.ReadBytes('\n')
+		assertResponse(resp, prepMessage(ERR_CHANOPRIVSNEEDED, s.Name, "eve", "#team").String(), t)
+		readLines(aliceR, 1)
+	})
+
+	t.Run("EmptyPrefix", func(t *testing.T) {
+		alice.Write([]byte("ACCESS #team ADD bob :\r\n"))
+		resp, _ := aliceR.ReadBytes('\n')
+		assertResponse(resp, "NOTICE :Prefix must be one of ~&@%+\r\n", t)
+	})
+
+	alice.Write([]byte("ACCESS #team ADD bob @\r\nACCESS #team ADD carol!*@* +\r\n"))
+	resp, _ := readLines(aliceR, 2)
+	assertResponse(resp, "NOTICE :Updated #team access list\r\n", t)
+
+	t.Run("Mask", func(t *testing.T) {
+		c, r := s.connectAndRegister("carol")
+		defer c.Close()
+		c.Write([]byte("JOIN #team\r\n"))
+		grant, _ := readLines(r, 4)
+		assertResponse(grant, ":gossip MODE #team +v carol\r\n", t)
+
+		join, _ := aliceR.ReadBytes('\n')
+		mode, _ := aliceR.ReadBytes('\n')
+		assertResponse(join, ":carol!carol@localhost JOIN #team\r\n", t)
+		assertResponse(mode, ":gossip MODE #team +v carol\r\n", t)
+
+		c.Write([]byte("PART #team\r\n"))
+		readLines(aliceR, 1)
+	})
+
+	t.Run("GrantedFounderPrefix", func(t *testing.T) {
+		alice.Write([]byte("ACCESS #team ADD dave!*@* ~\r\n"))
+		readLines(aliceR, 1)
+
+		c, r := s.connectAndRegister("dave")
+		defer c.Close()
+		c.Write([]byte("JOIN #team\r\n"))
+		grant, _ := readLines(r, 4)
+		assertResponse(grant, ":gossip MODE #team +q dave\r\n", t)
+		readLines(aliceR, 2)
+
+		c.Write([]byte("ACCESS #team DEL bob\r\n"))
+		resp, _ := r.ReadBytes('\n')
+		assertResponse(resp, prepMessage(ERR_CHANOPRIVSNEEDED, s.Name, "dave", "#team").String(), t)
+
+		c.Write([]byte("PART #team\r\n"))
+		readLines(aliceR, 1)
+		alice.Write([]byte("ACCESS #team DEL dave!*@*\r\n"))
+		readLines(aliceR, 1)
+	})
+
+	t.Run("Login", func(t *testing.T) {
+		c, r := s.connectAndRegister("bob")
+		defer c.Close()
+		c.Write([]byte("JOIN #team\r\n"))
+		readLines(r, 3)
+		readLines(aliceR, 1)
+
+		c.Write([]byte("AUTHENTICATE PLAIN\r\n"))
+		r.ReadBytes('\n')
+		c.Write([]byte("AUTHENTICATE " + base64.StdEncoding.EncodeToString([]byte("\000bob\000tanstaaftanstaaf")) + "\r\n"))
+		grant, _ := readLines(r, 3)
+		assertResponse(grant, ":gossip MODE #team +o bob\r\n", t)
+
+		mode, _ := aliceR.ReadBytes('\n')
+		assertResponse(mode, ":gossip MODE #team +o bob\r\n", t)
+
+		c.Write([]byte("PART #team\r\n"))
+		readLines(aliceR, 1)
+	})
+
+	t.Run("List", func(t *testing.T) {
+		alice.Write([]byte("ACCESS #team DEL carol!*@*\r\nACCESS #team LIST\r\n"))
+		readLines(aliceR, 1)
+		entry, _ := aliceR.ReadBytes('\n')
+		end, _ := aliceR.ReadBytes('\n')
+		assertResponse(entry, "NOTICE :#team @ bob\r\n", t)
+		assertResponse(end, "NOTICE :End of #team access list\r\n", t)
+	})
+}
@@ -0,0 +1,281 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mitchr/gossip/channel"
+	"github.com/mitchr/gossip/client"
+	"github.com/mitchr/gossip/scan/msg"
+)
+
+// httpAddr is the address that the admin API or the metrics endpoint
+// listens on. If addr does not name a host, it listens on localhost
+// only.
+func httpAddr(addr string) string {
+	if strings.HasPrefix(addr, ":") {
+		return "localhost" + addr
+	}
+	return addr
+}
+
+// serveAdmin serves the admin API on l until it is closed.
+func (s *Server) serveAdmin(l net.Listener) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /users", s.adminUsers)
+	mux.HandleFunc("GET /users/{nick}", s.adminUser)
+	mux.HandleFunc("POST /users/{nick}/kill", s.adminKill)
+	mux.HandleFunc("GET /channels", s.adminChannels)
+	mux.HandleFunc("GET /channels/{channel}", s.adminChannel)
+	mux.HandleFunc("POST /channels/{channel}/kick", s.adminKick)
+	mux.HandleFunc("POST /channels/{channel}/topic", s.adminTopic)
+	mux.HandleFunc("POST /notice", s.adminNotice)
+	mux.HandleFunc("POST /rehash", s.adminRehash)
+
+	serveHTTP(l, s.authorizeAdmin(mux))
+}
+
+// serveHTTP serves h on l. Once l is closed, idle connections are
+// closed as well, and requests that are in progress are finished.
+func serveHTTP(l net.Listener, h http.Handler) {
+	srv := &http.Server{Handler: h, ReadHeaderTimeout: time.Second * 10}
+	srv.Serve(l)
+	srv.Shutdown(context.Background())
+}
+
+// authorizeAdmin only lets requests that carry the bearer token from
+// the config through to h.
+func (s *Server) authorizeAdmin(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.Admin.Token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSONError(w, http.StatusUnauthorized, "invalid token")
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, err string) {
+	writeJSON(w, status, map[string]string{"error": err})
+}
+
+// readJSON decodes the body of r into v. If it can't, an error is
+// written to w and false is returned.
+func readJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid request body")
+		return false
+	}
+	return true
+}
+
+type adminUser struct {
+	Nick     string   `json:"nick"`
+	User     string   `json:"user"`
+	Host     string   `json:"host"`
+	RealHost string   `json:"realHost"`
+	Realname string   `json:"realname"`
+	Account  string   `json:"account,omitempty"`
+	Modes    string   `json:"modes"`
+	Away     string   `json:"away,omitempty"`
+	Server   string   `json:"server"`
+	Channels []string `json:"channels"`
+}
+
+func (s *Server) describeUser(c *client.Client) adminUser {
+	u := adminUser{
+		Nick:     c.Nick,
+		User:     c.User,
+		Host:     c.Host,
+		RealHost: c.RealHost,
+		Realname: c.Realname,
+		Modes:    c.Modes(),
+		Away:     c.AwayMsg,
+		Server:   c.Server,
+		Channels: []string{},
+	}
+	if c.IsAuthenticated {
+		u.Account = c.SASLMech.Authn()
+	}
+	if u.Server == "" {
+		u.Server = s.Name
+	}
+	for ch := range s.channelsOf(c) {
+		u.Channels = append(u.Channels, ch.String())
+	}
+	return u
+}
+
+type adminMember struct {
+	Nick   string `json:"nick"`
+	Prefix string `json:"prefix,omitempty"`
+}
+
+type adminChannel struct {
+	Name    string        `json:"name"`
+	Topic   string        `json:"topic,omitempty"`
+	Modes   string        `json:"modes"`
+	Params  []string      `json:"params"`
+	Members []adminMember `json:"members"`
+}
+
+func describeChannel(ch *channel.Channel) adminChannel {
+	modes, params := ch.Modes()
+	if params == nil {
+		params = []string{}
+	}
+	info := adminChannel{Name: ch.String(), Topic: ch.Topic, Modes: modes, Params: params, Members: []adminMember{}}
+	for m := range ch.All() {
+		info.Members = append(info.Members, adminMember{Nick: m.Nick, Prefix: m.HighestPrefix(true)})
+	}
+	return info
+}
+
+func (s *Server) adminUsers(w http.ResponseWriter, r *http.Request) {
+	users := []adminUser{}
+	for _, c := range s.clients.All() {
+		users = append(users, s.describeUser(c))
+	}
+	writeJSON(w, http.StatusOK, users)
+}
+
+func (s *Server) adminUser(w http.ResponseWriter, r *http.Request) {
+	c, ok := s.getClient(r.PathValue("nick"))
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "no such nick")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.describeUser(c))
+}
+
+func (s *Server) adminKill(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	if !readJSON(w, r, &body) {
+		return
+	}
+	target, ok := s.getClient(r.PathValue("nick"))
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "no such nick")
+		return
+	}
+
+	if target.Server == "" {
+		target.WriteMessage(msg.New(nil, s.Name, "", "", "KILL", []string{target.Nick, body.Reason}, true))
+	}
+	s.kill(target, "Killed ("+s.Name+" ("+body.Reason+"))", nil)
+	s.snotice(client.SnoKill, "Received KILL message for "+describe(target)+" from the admin API: "+body.Reason)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) adminChannels(w http.ResponseWriter, r *http.Request) {
+	chans := []adminChannel{}
+	for _, ch := range s.channels.All() {
+		chans = append(chans, describeChannel(ch))
+	}
+	writeJSON(w, http.StatusOK, chans)
+}
+
+func (s *Server) adminChannel(w http.ResponseWriter, r *http.Request) {
+	ch, ok := s.getChannel(r.PathValue("channel"))
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "no such channel")
+		return
+	}
+	writeJSON(w, http.StatusOK, describeChannel(ch))
+}
+
+func (s *Server) adminKick(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Nick   string `json:"nick"`
+		Reason string `json:"reason"`
+	}
+	if !readJSON(w, r, &body) {
+		return
+	}
+	ch, ok := s.getChannel(r.PathValue("channel"))
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "no such channel")
+		return
+	}
+	member, ok := ch.GetMember(body.Nick)
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "no such member")
+		return
+	}
+
+	s.serverKick(ch, member.Nick, body.Reason)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) adminTopic(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Topic string `json:"topic"`
+	}
+	if !readJSON(w, r, &body) {
+		return
+	}
+	ch, ok := s.getChannel(r.PathValue("channel"))
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "no such channel")
+		return
+	}
+
+	ch.Topic = body.Topic
+	ch.TopicSetBy = topicSetter(s.Name)
+	ch.TopicSetAt = time.Now()
+	ch.WriteMessage(msg.New(nil, s.Name, "", "", "TOPIC", []string{ch.String(), ch.Topic}, true))
+	s.propagateChan(ch, msg.New(nil, s.Name, "", "", "TOPIC", []string{ch.String(), strconv.FormatInt(ch.TopicSetAt.Unix(), 10), ch.Topic}, true))
+	s.channelChanged(ch)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// adminNotice sends a NOTICE from the server to every client that is
+// connected to it.
+func (s *Server) adminNotice(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Text string `json:"text"`
+	}
+	if !readJSON(w, r, &body) {
+		return
+	}
+	if body.Text == "" {
+		writeJSONError(w, http.StatusBadRequest, "no text to send")
+		return
+	}
+
+	var local []*client.Client
+	for _, c := range s.clients.All() {
+		if c.Server == "" {
+			local = append(local, c)
+		}
+	}
+	for _, c := range local {
+		c.WriteMessage(msg.New(nil, s.Name, "", "", "NOTICE", []string{c.Id(), body.Text}, true))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) adminRehash(w http.ResponseWriter, r *http.Request) {
+	if err := s.Rehash(); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s.snotice(client.SnoRehash, "The admin API is rehashing")
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestAdminAPI(t *testing.T) {
+	t.Parallel()
+
+	conf := &Config{Name: "gossip", Port: ":0"}
+	conf.Cloak.Disabled = true
+	conf.Admin.Addr = "localhost:0"
+	conf.Admin.Token = "secret"
+
+	s, err := New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	go s.Serve()
+
+	base := "http://" + s.adminListener.Addr().String()
+	request := func(method, path, token, body string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(method, base+path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	c, r := s.connectAndRegister("alice")
+	defer c.Close()
+	c.Write([]byte("JOIN #test\r\n"))
+	readLines(r, 3)
+
+	bob, bobR := s.connectAndRegister("bob")
+	defer bob.Close()
+	bob.Write([]byte("JOIN #test\r\n"))
+	readLines(bobR, 3)
+	r.ReadBytes('\n')
+
+	t.Run("Unauthorized", func(t *testing.T) {
+		resp := request("GET", "/users", "wrong", "")
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Error("expected 401, got", resp.StatusCode)
+		}
+	})
+
+	t.Run("User", func(t *testing.T) {
+		resp := request("GET", "/users/alice", "secret", "")
+		defer resp.Body.Close()
+		var u adminUser
+		json.NewDecoder(resp.Body).Decode(&u)
+		if u.Nick != "alice" || u.Server != "gossip" || len(u.Channels) != 1 || u.Channels[0] != "#test" {
+			t.Error("unexpected user", u)
+		}
+	})
+
+	t.Run("Channel", func(t *testing.T) {
+		resp := request("GET", "/channels/"+url.PathEscape("#test"), "secret", "")
+		defer resp.Body.Close()
+		var ch adminChannel
+		json.NewDecoder(resp.Body).Decode(&ch)
+		if ch.Name != "#test" || len(ch.Members) != 2 {
+			t.Error("unexpected channel", ch)
+		}
+	})
+
+	t.Run("Topic", func(t *testing.T) {
+		resp := request("POST", "/channels/"+url.PathEscape("#test")+"/topic", "secret", `{"topic": "hello"}`)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNoContent {
+			t.Error("expected 204, got", resp.StatusCode)
+		}
+		topic, _ := r.ReadBytes('\n')
+		assertResponse(topic, ":gossip TOPIC #test :hello\r\n", t)
+		bobR.ReadBytes('\n')
+	})
+
+	t.Run("Notice", func(t *testing.T) {
+		resp := request("POST", "/notice", "secret", `{"text": "maintenance soon"}`)
+		resp.Body.Close()
+		notice, _ := r.ReadBytes('\n')
+		assertResponse(notice, ":gossip NOTICE alice :maintenance soon\r\n", t)
+		bobR.ReadBytes('\n')
+	})
+
+	t.Run("Kick", func(t *testing.T) {
+		resp := request("POST", "/channels/"+url.PathEscape("#test")+"/kick", "secret", `{"nick": "bob", "reason": "bye"}`)
+		resp.Body.Close()
+		kick, _ := r.ReadBytes('\n')
+		assertResponse(kick, ":gossip KICK #test bob :bye\r\n", t)
+		bobR.ReadBytes('\n')
+	})
+
+	t.Run("Kill", func(t *testing.T) {
+		resp := request("POST", "/users/bob/kill", "secret", `{"reason": "spam"}`)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNoContent {
+			t.Error("expected 204, got", resp.StatusCode)
+		}
+		kill, _ := bobR.ReadBytes('\n')
+		assertResponse(kill, ":gossip KILL bob :spam\r\n", t)
+		if _, ok := s.getClient("bob"); ok {
+			t.Error("bob was not killed")
+		}
+	})
+
+	t.Run("RehashWithoutFile", func(t *testing.T) {
+		resp := request("POST", "/rehash", "secret", "")
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusInternalServerError {
+			t.Error("expected 500, got", resp.StatusCode)
+		}
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		resp := request("GET", "/users/bob", "secret", "")
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Error("expected 404, got", resp.StatusCode)
+		}
+	})
+}
@@ -1,11 +1,13 @@
 package server
 
 import (
-	"crypto/rand"
 	"crypto/sha256"
-	"database/sql"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
 	"fmt"
+	"slices"
 	"strings"
 
 	cap "github.com/mitchr/gossip/capability"
@@ -13,6 +15,7 @@ import (
 	"github.com/mitchr/gossip/client"
 	"github.com/mitchr/gossip/sasl"
 	"github.com/mitchr/gossip/sasl/external"
+	"github.com/mitchr/gossip/sasl/oauthbearer"
 	"github.com/mitchr/gossip/sasl/plain"
 	"github.com/mitchr/gossip/sasl/scram"
 	"github.com/mitchr/gossip/scan/msg"
@@ -58,13 +61,31 @@ func AUTHENTICATE(s *Server, c *client.Client, m *msg.Message) msg.Msg {
 		case "EXTERNAL":
 			c.SASLMech = external.New(s.db, c)
 		case "SCRAM-SHA-256":
-			c.SASLMech = scram.New(s.db, sha256.New)
+			c.SASLMech = scram.New(s.db, sha256.New, s.channelBinding(c))
+		case "SCRAM-SHA-512":
+			c.SASLMech = scram.New(s.db, sha512.New, s.channelBinding(c))
+		case "SCRAM-SHA-256-PLUS", "SCRAM-SHA-512-PLUS":
+			b := s.channelBinding(c)
+			if b == nil {
+				return prepMessage(ERR_SASLFAIL, s.Name, c.Id())
+			}
+			h := sha256.New
+			if m.Params[0] == "SCRAM-SHA-512-PLUS" {
+				h = sha512.New
+			}
+			c.SASLMech = scram.NewPlus(s.db, h, b)
+		case "OAUTHBEARER":
+			if s.oauth == nil {
+				return prepMessage(ERR_SASLFAIL, s.Name, c.Id())
+			}
+			c.SASLMech = oauthbearer.New(s.oauth)
 		default:
 			return msg.Buffer{
-				prepMessage(RPL_SASLMECHS, s.Name, c.Id(), cap.SASL.Value),
+				prepMessage(RPL_SASLMECHS, s.Name, c.Id(), s.saslMechs()),
 				prepMessage(ERR_SASLFAIL, s.Name, c.Id()),
 			}
 		}
+		c.SASLMechName = m.Params[0]
 
 		// TODO: all currently supported SASL mechanisms are client-first,
 		// so we can be assured that the server should be sending a blank
@@ -88,14 +109,18 @@ func AUTHENTICATE(s *Server, c *client.Client, m *msg.Message) msg.Msg {
 	decodedResp := make([]byte, base64.StdEncoding.DecodedLen(len(c.AuthCtx)))
 	n, err := base64.StdEncoding.Decode(decodedResp, c.AuthCtx)
 	if err != nil {
+		s.metrics.saslAttempt(c.SASLMechName, false)
 		return prepMessage(ERR_SASLFAIL, s.Name, c.Id())
 	}
 
 	challenge, err := c.SASLMech.Next(decodedResp[:n])
 	if err != nil {
+		s.metrics.saslAttempt(c.SASLMechName, false)
+		s.snotice(client.SnoAuth, "Failed SASL login by "+describe(c))
 		return prepMessage(ERR_SASLFAIL, s.Name, c.Id())
 	}
 	if challenge == nil {
+		s.metrics.saslAttempt(c.SASLMechName, true)
 		c.IsAuthenticated = true
 		var buff msg.Buffer
 		buff.AddMsg(prepMessage(RPL_LOGGEDIN, s.Name, c.Id(), c, c.SASLMech.Authn(), c.Id()))
@@ -104,6 +129,7 @@ func AUTHENTICATE(s *Server, c *client.Client, m *msg.Message) msg.Msg {
 			buff.AddMsg(msg.New(nil, c.Nick, c.User, c.Host, "ACCOUNT", []string{c.SASLMech.Authn()}, false))
 		}
 		s.accountNotify(c)
+		buff.AddMsg(s.grantAllAccess(c))
 		return buff
 	}
 
@@ -111,6 +137,37 @@ func AUTHENTICATE(s *Server, c *client.Client, m *msg.Message) msg.Msg {
 	return msg.New(nil, s.Name, "", "", "AUTHENTICATE", []string{encodedChallenge}, false)
 }
 
+// saslMechs lists the SASL mechanisms that this server supports.
+// Channel binding is only possible over tls, and OAUTHBEARER needs keys
+// to check tokens with.
+func (s *Server) saslMechs() string {
+	mechs := slices.DeleteFunc(strings.Split(cap.SASL.Value, ","), func(m string) bool {
+		return (!s.TLS.Enabled && strings.HasSuffix(m, "-PLUS")) || (s.oauth == nil && m == "OAUTHBEARER")
+	})
+	return strings.Join(mechs, ",")
+}
+
+// channelBinding describes the tls connection of c for SCRAM. If c is
+// not connected over tls, channelBinding returns nil.
+func (s *Server) channelBinding(c *client.Client) *scram.ChannelBinding {
+	state, ok := c.ConnectionState()
+	if !ok {
+		return nil
+	}
+
+	b := &scram.ChannelBinding{State: state}
+	var cert *tls.Certificate
+	if s.TLS.Config.GetCertificate != nil {
+		cert, _ = s.TLS.Config.GetCertificate(&tls.ClientHelloInfo{ServerName: state.ServerName})
+	} else if len(s.TLS.Config.Certificates) > 0 {
+		cert = &s.TLS.Config.Certificates[0]
+	}
+	if cert != nil && len(cert.Certificate) > 0 {
+		b.Cert, _ = x509.ParseCertificate(cert.Certificate[0])
+	}
+	return b
+}
+
 func (s *Server) accountNotify(c *client.Client) {
 	// if c.Caps[cap.AccountNotify.Name] {
 	// 	c.WriteMessage(msg.New(nil, c.Nick, c.User, c.Host, "ACCOUNT", []string{c.SASLMech.Authn()}, false))
@@ -133,42 +190,57 @@ func (s *Server) accountNotify(c *client.Client) {
 	s.notify(c, msg.New(nil, c.Nick, c.User, c.Host, "ACCOUNT", []string{c.SASLMech.Authn()}, false), cap.AccountNotify)
 }
 
-// REGISTER is nonstandard
-// for now, username is assumed to be the same as the current client's nick
-// REGISTER PASS <pass>
+// REGISTER <account> {<email> | "*"} <password>
+//
+// The following forms are nonstandard, and use the current nick as the
+// account name:
+// REGISTER PASS <pass>, which is the same as REGISTER * * <pass>
 // REGISTER CERT
 // REGISTER <channel>
 func REGISTER(s *Server, c *client.Client, m *msg.Message) msg.Msg {
 	if len(m.Params) == 0 {
 		return prepMessage(ERR_NEEDMOREPARAMS, s.Name, c.Id(), "REGISTER")
 	}
-
-	switch arg := strings.ToUpper(m.Params[0]); {
-	case arg == "PASS":
+	if len(m.Params) >= 3 {
+		return s.registerAccount(c, m)
+	}
+	if strings.EqualFold(m.Params[0], "PASS") {
 		if len(m.Params) < 2 {
 			return prepMessage(ERR_NEEDMOREPARAMS, s.Name, c.Id(), "REGISTER PASS")
 		}
-		pass := m.Params[1]
-
-		plainCred := plain.NewCredential(c.Id(), pass)
-		s.persistPlain(plainCred.Username, c.Nick, plainCred.Pass)
+		return s.registerAccount(c, &msg.Message{Command: "REGISTER", Params: []string{"*", "*", m.Params[1]}})
+	}
 
-		salt := make([]byte, 16)
-		rand.Read(salt)
-		scramCred := scram.NewCredential(sha256.New, c.Id(), pass, salt, 4096)
-		s.persistScram(scramCred.Username, c.Nick, scramCred.ServerKey, scramCred.StoredKey, scramCred.Salt, scramCred.Iteration)
+	if !c.Is(client.Registered) {
+		s.stdReply(c, FAIL, "REGISTER", "COMPLETE_CONNECTION_REQUIRED", m.Params[0], "You must complete connection registration first")
+		return nil
+	}
 
+	switch arg := strings.ToUpper(m.Params[0]); {
 	case arg == "CERT":
 		cert, err := c.Certificate()
 		if err != nil {
 			return s.NOTICE(c, err.Error())
 		}
+		if s.accountExists(c.Id()) {
+			s.stdReply(c, FAIL, "REGISTER", "ACCOUNT_EXISTS", c.Id(), "Account already exists")
+			return nil
+		}
 		cred := external.NewCredential(c.Id(), cert)
-		s.persistExternal(cred.Username, c.Nick, cred.Cert)
+		if err := s.persistExternal(cred.Username, c.Nick, cred.Cert); err != nil {
+			s.logger.Error("could not register account", "client", c, "account", cred.Username, "err", err)
+			s.stdReply(c, FAIL, "REGISTER", "TEMPORARILY_UNAVAILABLE", c.Id(), "Could not register account, try again later")
+			return nil
+		}
 
 	case isValidChannelString(arg):
-		// channel must exist already, sender must be an op in that channel,
-		// and the channel should not already be registered
+		// channel must exist already, sender must be logged in and an op
+		// in that channel, and the channel should not already be
+		// registered. The channel belongs to the account of the sender, so
+		// that whoever takes their nick later can't claim it.
+		if !c.IsAuthenticated {
+			return s.NOTICE(c, "You must be logged in to register a channel")
+		}
 		ch, exists := s.getChannel(arg)
 		if !exists {
 			return s.NOTICE(c, fmt.Sprintf("Channel %s does not exist", arg))
@@ -179,42 +251,56 @@ func REGISTER(s *Server, c *client.Client, m *msg.Message) msg.Msg {
 			return s.NOTICE(c, "You are not a channel operator")
 		}
 
-		if s.chanAlreadyRegistered(arg) {
+		if ch.Registered || s.chanAlreadyRegistered(ch.String()) {
 			return s.NOTICE(c, "Channel already registered")
 		}
 
-		s.persistChan(c.Id(), arg)
+		account := c.SASLMech.Authn()
+		if err := s.persistChan(account, ch.String()); err != nil {
+			s.logger.Error("could not register channel", "client", c, "channel", ch.String(), "err", err)
+			return s.NOTICE(c, "Could not register channel")
+		}
+		ch.Registered = true
+		ch.Founder = account
+		if err := s.saveChannel(ch); err != nil {
+			s.logger.Error("could not save channel", "channel", ch.String(), "err", err)
+			return s.NOTICE(c, "Could not register channel")
+		}
 		return msg.Buffer{
 			MODE(s, c, msg.New(nil, c.Nick, c.User, c.Host, "MODE", []string{arg, "+q", c.Nick}, false)),
 			s.NOTICE(c, "Registered"),
 		}
 
 	default:
-		return s.NOTICE(c, "Unsupported registration type "+m.Params[0])
+		return prepMessage(ERR_NEEDMOREPARAMS, s.Name, c.Id(), "REGISTER")
 	}
 
 	return s.NOTICE(c, "Registered")
 }
 
-func (s *Server) persistPlain(username, nick string, pass []byte) {
-	s.db.Exec("INSERT INTO sasl_plain VALUES(?, ?, ?)", username, nick, pass)
+func (s *Server) persistPlain(username, nick string, pass []byte) error {
+	_, err := s.db.Exec("INSERT INTO sasl_plain VALUES(?, ?, ?)", username, nick, pass)
+	return err
 }
 
-func (s *Server) persistScram(username, nick string, serverKey, storedKey, salt []byte, iteration int) {
-	s.db.Exec("INSERT INTO sasl_scram VALUES(?, ?, ?, ?, ?, ?)", username, nick, serverKey, storedKey, salt, iteration)
+func (s *Server) persistScram(table, username, nick string, serverKey, storedKey, salt []byte, iteration int) error {
+	_, err := s.db.Exec("INSERT INTO "+table+" VALUES(?, ?, ?, ?, ?, ?)", username, nick, serverKey, storedKey, salt, iteration)
+	return err
 }
 
-func (s *Server) persistExternal(username, nick string, cert []byte) {
-	s.db.Exec("INSERT INTO sasl_external VALUES(?, ?, ?)", username, nick, cert)
+func (s *Server) persistExternal(username, nick string, cert []byte) error {
+	_, err := s.db.Exec("INSERT INTO sasl_external VALUES(?, ?, ?)", username, nick, cert)
+	return err
 }
 
-func (s *Server) persistChan(owner, channel string) {
-	s.db.Exec("INSERT INTO channels VALUES(?, ?)", owner, channel)
+func (s *Server) persistChan(owner, channel string) error {
+	_, err := s.db.Exec("INSERT INTO channels VALUES(?, ?)", owner, channel)
+	return err
 }
 
 func (s *Server) chanAlreadyRegistered(channel string) bool {
-	r := s.db.QueryRow("select chan from channels where chan=?", channel)
-	return r.Err() == sql.ErrNoRows
+	var ch string
+	return s.db.QueryRow("select chan from channels where lower(chan)=lower(?)", channel).Scan(&ch) == nil
 }
 
 func (s *Server) userAccountForNickExists(n string) (username string) {
@@ -223,7 +309,9 @@ func (s *Server) userAccountForNickExists(n string) (username string) {
 		union
 		select username from sasl_scram where nick=?
 		union
+		select username from sasl_scram_sha512 where nick=?
+		union
 		select username from sasl_external where nick=?
-	`, n, n, n).Scan(&username)
+	`, n, n, n, n).Scan(&username)
 	return
 }
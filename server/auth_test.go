@@ -2,13 +2,26 @@ package server
 
 import (
 	"bufio"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha512"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/mitchr/gossip/sasl/external"
 	"github.com/mitchr/gossip/sasl/plain"
+	"github.com/mitchr/gossip/sasl/scram"
+	"golang.org/x/crypto/pbkdf2"
 )
 
 func TestREGISTER(t *testing.T) {
@@ -21,13 +34,150 @@ func TestREGISTER(t *testing.T) {
 	defer s.Close()
 	go s.Serve()
 
+	t.Run("Success", func(t *testing.T) {
+		conn, r := s.connectAndRegister("alice")
+		defer conn.Close()
+
+		conn.Write([]byte("REGISTER * * pass1pass1\r\n"))
+		success, _ := r.ReadBytes('\n')
+		loggedIn, _ := r.ReadBytes('\n')
+
+		assertResponse(success, ":gossip REGISTER SUCCESS alice :Account successfully registered\r\n", t)
+		assertResponse(loggedIn, prepMessage(RPL_LOGGEDIN, s.Name, "alice", "alice!alice@localhost", "alice", "alice").String(), t)
+	})
+
+	t.Run("PASS", func(t *testing.T) {
+		conn, r := s.connectAndRegister("erin")
+		defer conn.Close()
+
+		conn.Write([]byte("REGISTER PASS pass1pass1\r\n"))
+		success, _ := r.ReadBytes('\n')
+		assertResponse(success, ":gossip REGISTER SUCCESS erin :Account successfully registered\r\n", t)
+		r.ReadBytes('\n')
+	})
+
+	t.Run("AccountExists", func(t *testing.T) {
+		conn, r := s.connectAndRegister("bob")
+		defer conn.Close()
+
+		conn.Write([]byte("REGISTER ALICE * pass1pass1\r\n"))
+		resp, _ := r.ReadBytes('\n')
+		assertResponse(resp, "FAIL REGISTER ACCOUNT_EXISTS ALICE :Account already exists\r\n", t)
+	})
+
+	t.Run("WeakPassword", func(t *testing.T) {
+		conn, r := s.connectAndRegister("carl")
+		defer conn.Close()
+
+		conn.Write([]byte("REGISTER carl * pass\r\n"))
+		resp, _ := r.ReadBytes('\n')
+		assertResponse(resp, "FAIL REGISTER WEAK_PASSWORD carl :Password must be at least 8 characters\r\n", t)
+	})
+
+	t.Run("BeforeConnect", func(t *testing.T) {
+		c, r, p := connect(s)
+		defer p()
+
+		c.Write([]byte("REGISTER dan * pass1pass1\r\n"))
+		resp, _ := r.ReadBytes('\n')
+		assertResponse(resp, "FAIL REGISTER COMPLETE_CONNECTION_REQUIRED dan :You must complete connection registration before registering an account\r\n", t)
+	})
+
+	t.Run("Login", func(t *testing.T) {
+		c, r, p := connect(s)
+		defer p()
+
+		c.Write([]byte("CAP REQ sasl\r\nAUTHENTICATE PLAIN\r\n"))
+		r.ReadBytes('\n')
+		r.ReadBytes('\n')
+		c.Write([]byte("AUTHENTICATE " + base64.StdEncoding.EncodeToString([]byte("\000alice\000pass1pass1")) + "\r\n"))
+		resp, _ := r.ReadBytes('\n')
+		assertResponse(resp, prepMessage(RPL_LOGGEDIN, s.Name, "*", "@pipe", "alice", "*").String(), t)
+	})
+}
+
+// codeCatcher records the last verification code it was asked to send.
+type codeCatcher chan string
+
+func (c codeCatcher) SendVerification(account, email, code string) error {
+	c <- code
+	return nil
+}
+
+func TestVERIFY(t *testing.T) {
+	t.Parallel()
+
+	codes := make(codeCatcher, 1)
+	conf := &Config{Name: "gossip", Port: ":0"}
+	conf.Cloak.Disabled = true
+	conf.AccountRegistration.EmailRequired = true
+	conf.AccountRegistration.Sender = codes
+
+	s, err := New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	go s.Serve()
+
 	conn, r := s.connectAndRegister("alice")
 	defer conn.Close()
 
-	conn.Write([]byte("REGISTER PASS pass1\r\n"))
+	conn.Write([]byte("REGISTER alice * pass1pass1\r\n"))
 	resp, _ := r.ReadBytes('\n')
+	assertResponse(resp, "FAIL REGISTER INVALID_EMAIL alice :An email address is required\r\n", t)
+
+	conn.Write([]byte("REGISTER alice alice@example.com pass1pass1\r\n"))
+	resp, _ = r.ReadBytes('\n')
+	assertResponse(resp, ":gossip REGISTER VERIFICATION_REQUIRED alice :A verification code was sent to alice@example.com\r\n", t)
+	code := <-codes
+
+	conn.Write([]byte("VERIFY alice wrong\r\n"))
+	resp, _ = r.ReadBytes('\n')
+	assertResponse(resp, "FAIL VERIFY INVALID_CODE alice :Invalid verification code\r\n", t)
+
+	conn.Write([]byte("VERIFY alice " + code + "\r\n"))
+	success, _ := r.ReadBytes('\n')
+	loggedIn, _ := r.ReadBytes('\n')
+	assertResponse(success, ":gossip VERIFY SUCCESS alice :Account successfully registered\r\n", t)
+	assertResponse(loggedIn, prepMessage(RPL_LOGGEDIN, s.Name, "alice", "alice!alice@localhost", "alice", "alice").String(), t)
+
+	t.Run("Expired", func(t *testing.T) {
+		conn, r := s.connectAndRegister("bob")
+		defer conn.Close()
+
+		conn.Write([]byte("REGISTER bob bob@example.com pass1pass1\r\n"))
+		r.ReadBytes('\n')
+		<-codes
+
+		p, _ := s.pendingAccounts.Get("bob")
+		p.expires = time.Now().Add(-time.Second)
+		s.expirePendingAccounts()
+		if _, ok := s.pendingAccounts.Get("bob"); ok {
+			t.Error("expected expired account to be forgotten")
+		}
+	})
+
+	t.Run("SendFailed", func(t *testing.T) {
+		conn, r := s.connectAndRegister("carl")
+		defer conn.Close()
+
+		s.configLock.Lock()
+		s.verificationSender = failingSender{}
+		s.configLock.Unlock()
+		conn.Write([]byte("REGISTER carl carl@example.com pass1pass1\r\n"))
+		resp, _ := r.ReadBytes('\n')
+		assertResponse(resp, "FAIL REGISTER TEMPORARILY_UNAVAILABLE carl :Could not send a verification code, try again later\r\n", t)
+		if _, ok := s.pendingAccounts.Get("carl"); ok {
+			t.Error("expected account to be forgotten")
+		}
+	})
+}
+
+type failingSender struct{}
 
-	assertResponse(resp, "NOTICE :Registered\r\n", t)
+func (failingSender) SendVerification(account, email, code string) error {
+	return errors.New("unreachable")
 }
 
 func TestChannelREGISTER(t *testing.T) {
@@ -40,8 +190,19 @@ func TestChannelREGISTER(t *testing.T) {
 	defer s.Close()
 	go s.Serve()
 
-	c, r := s.connectAndRegister("alice")
-	defer c.Close()
+	t.Run("NotLoggedIn", func(t *testing.T) {
+		c, r := s.connectAndRegister("bob")
+		defer c.Close()
+
+		c.Write([]byte("JOIN #bob\r\nREGISTER #bob\r\n"))
+		readLines(r, 3)
+		resp, _ := r.ReadBytes('\n')
+		assertResponse(resp, "NOTICE :You must be logged in to register a channel\r\n", t)
+	})
+
+	cred := plain.NewCredential("founder", "tanstaaftanstaaf")
+	s.persistPlain(cred.Username, "founder", cred.Pass)
+	c, r := s.connectAndAuthenticate("alice", "founder", "tanstaaftanstaaf")
 
 	c.Write([]byte("JOIN #test\r\nREGISTER #test\r\n"))
 	readLines(r, 3)
@@ -50,6 +211,19 @@ func TestChannelREGISTER(t *testing.T) {
 	regResp, _ := r.ReadBytes('\n')
 	assertResponse(founderMode, fmt.Sprintf(":%s MODE #test +q alice\r\n", s.Name), t)
 	assertResponse(regResp, "NOTICE :Registered\r\n", t)
+
+	t.Run("NickSquatter", func(t *testing.T) {
+		c.Write([]byte("QUIT\r\n"))
+		c.Close()
+		waitFor(t, func() bool { _, ok := s.getClient("alice"); return !ok })
+
+		squatter, r := s.connectAndRegister("alice")
+		defer squatter.Close()
+		squatter.Write([]byte("JOIN #test\r\n"))
+		readLines(r, 1)
+		names, _ := r.ReadBytes('\n')
+		assertResponse(names, prepMessage(RPL_NAMREPLY, s.Name, "alice", "=", "#test", "alice").String(), t)
+	})
 }
 
 func TestAUTHENTICATE(t *testing.T) {
@@ -145,7 +319,7 @@ func TestAUTHENTICATE(t *testing.T) {
 		mechList, _ := r.ReadBytes('\n')
 		fail, _ := r.ReadBytes('\n')
 
-		assertResponse(mechList, prepMessage(RPL_SASLMECHS, s.Name, "*", "PLAIN,EXTERNAL,SCRAM-SHA-256").String(), t)
+		assertResponse(mechList, prepMessage(RPL_SASLMECHS, s.Name, "*", "PLAIN,EXTERNAL,SCRAM-SHA-256,SCRAM-SHA-512").String(), t)
 		assertResponse(fail, prepMessage(ERR_SASLFAIL, s.Name, "*").String(), t)
 	})
 }
@@ -238,6 +412,121 @@ func TestAUTHENTICATESCRAM(t *testing.T) {
 	assertResponse(resp, ":gossip AUTHENTICATE +\r\n", t)
 }
 
+func TestAUTHENTICATESCRAMPLUS(t *testing.T) {
+	t.Parallel()
+
+	s, err := New(generateConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	go s.Serve()
+
+	salt := []byte("saltsaltsaltsalt")
+	cred := scram.NewCredential(sha512.New, "tim", "pencil", salt, 4096)
+	s.persistScram(scram.Table(sha512.New), cred.Username, "tim", cred.ServerKey, cred.StoredKey, cred.Salt, cred.Iteration)
+
+	c, err := tls.Dial("tcp", ":"+s.tlsPort(), &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	r := bufio.NewReader(c)
+
+	c.Write([]byte("CAP LS 302\r\n"))
+	ls, _ := r.ReadBytes('\n')
+	if !strings.Contains(string(ls), "sasl=PLAIN,EXTERNAL,SCRAM-SHA-256,SCRAM-SHA-256-PLUS,SCRAM-SHA-512,SCRAM-SHA-512-PLUS") {
+		t.Error("PLUS mechanisms not advertised:", string(ls))
+	}
+
+	c.Write([]byte("CAP REQ sasl\r\nAUTHENTICATE SCRAM-SHA-512-PLUS\r\n"))
+	readLines(r, 2)
+
+	gs2Header := "p=tls-exporter,,"
+	clientFirstBare := "n=tim,r=clientnonce"
+	c.Write([]byte("AUTHENTICATE " + base64.StdEncoding.EncodeToString([]byte(gs2Header+clientFirstBare)) + "\r\n"))
+	resp, _ := r.ReadString('\n')
+	serverFirst, _ := base64.StdEncoding.DecodeString(strings.TrimSuffix(strings.TrimPrefix(resp, ":gossip AUTHENTICATE "), "\r\n"))
+	nonce := strings.Split(string(serverFirst), ",")[0]
+
+	state := c.ConnectionState()
+	cbData, _ := state.ExportKeyingMaterial("EXPORTER-Channel-Binding", nil, 32)
+	clientFinal := "c=" + base64.StdEncoding.EncodeToString(append([]byte(gs2Header), cbData...)) + "," + nonce
+
+	saltedPass := pbkdf2.Key([]byte("pencil"), salt, 4096, sha512.Size, sha512.New)
+	mac := hmac.New(sha512.New, saltedPass)
+	mac.Write([]byte("Client Key"))
+	clientKey := mac.Sum(nil)
+	mac = hmac.New(sha512.New, cred.StoredKey)
+	mac.Write([]byte(clientFirstBare + "," + string(serverFirst) + "," + clientFinal))
+	proof := mac.Sum(nil)
+	for i := range proof {
+		proof[i] ^= clientKey[i]
+	}
+	clientFinal += ",p=" + base64.StdEncoding.EncodeToString(proof)
+
+	c.Write([]byte("AUTHENTICATE " + base64.StdEncoding.EncodeToString([]byte(clientFinal)) + "\r\n"))
+	resp, _ = r.ReadString('\n')
+	if !strings.HasPrefix(resp, ":gossip AUTHENTICATE ") {
+		t.Fatal("expected server final, got", resp)
+	}
+
+	c.Write([]byte("AUTHENTICATE +\r\n"))
+	r.ReadBytes('\n')
+	success, _ := r.ReadBytes('\n')
+	assertResponse(success, prepMessage(RPL_SASLSUCCESS, s.Name, "*").String(), t)
+}
+
+func TestAUTHENTICATEOAUTHBEARER(t *testing.T) {
+	t.Parallel()
+
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	der, _ := x509.MarshalPKIXPublicKey(pub)
+	keyPath := filepath.Join(t.TempDir(), "key.pem")
+	os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600)
+
+	conf := &Config{Name: "gossip", Port: ":0"}
+	conf.Cloak.Disabled = true
+	conf.OAuth.Keys = []string{keyPath}
+	conf.OAuth.Issuer = "https://sso.example.com"
+	s, err := New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	go s.Serve()
+
+	b64 := base64.RawURLEncoding.EncodeToString
+	signed := b64([]byte(`{"alg":"EdDSA"}`)) + "." + b64([]byte(fmt.Sprintf(`{"iss":"https://sso.example.com","sub":"tim","exp":%d}`, time.Now().Add(time.Hour).Unix())))
+	token := signed + "." + b64(ed25519.Sign(priv, []byte(signed)))
+
+	t.Run("Success", func(t *testing.T) {
+		c, r, p := connect(s)
+		defer p()
+
+		c.Write([]byte("CAP REQ sasl\r\nAUTHENTICATE OAUTHBEARER\r\n"))
+		readLines(r, 2)
+		c.Write([]byte("AUTHENTICATE " + base64.StdEncoding.EncodeToString([]byte("n,,\x01auth=Bearer "+token+"\x01\x01")) + "\r\n"))
+		resp, _ := r.ReadBytes('\n')
+		assertResponse(resp, prepMessage(RPL_LOGGEDIN, s.Name, "*", "@pipe", "tim", "*").String(), t)
+	})
+
+	t.Run("InvalidToken", func(t *testing.T) {
+		c, r, p := connect(s)
+		defer p()
+
+		c.Write([]byte("CAP REQ sasl\r\nAUTHENTICATE OAUTHBEARER\r\n"))
+		readLines(r, 2)
+		c.Write([]byte("AUTHENTICATE " + base64.StdEncoding.EncodeToString([]byte("n,,\x01auth=Bearer "+token+"x\x01\x01")) + "\r\n"))
+		resp, _ := r.ReadBytes('\n')
+		assertResponse(resp, ":gossip AUTHENTICATE "+base64.StdEncoding.EncodeToString([]byte(`{"status":"invalid_token"}`))+"\r\n", t)
+
+		c.Write([]byte("AUTHENTICATE AQ==\r\n"))
+		resp, _ = r.ReadBytes('\n')
+		assertResponse(resp, prepMessage(ERR_SASLFAIL, s.Name, "*").String(), t)
+	})
+}
+
 func TestEndRegistrationWithANickBelongingToRegisteredAccount(t *testing.T) {
 	t.Parallel()
 
@@ -0,0 +1,401 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mitchr/gossip/client"
+	"github.com/mitchr/gossip/scan/msg"
+	"github.com/mitchr/gossip/scan/wild"
+)
+
+// Server bans keep users off of the server. K-lines match the user@host
+// of a client once it has registered, and G-lines are K-lines that are
+// shared with every linked server. D-lines match the IP address of a
+// connection as soon as it is accepted, and may be given as a CIDR
+// range.
+
+type banKind string
+
+const (
+	kline banKind = "K"
+	gline banKind = "G"
+	dline banKind = "D"
+)
+
+type serverBan struct {
+	kind                 banKind
+	mask, reason, setter string
+	set                  time.Time
+
+	// the zero value means that the ban never expires
+	expires time.Time
+}
+
+var ErrBadBanMask = errors.New("invalid ban mask")
+
+// newBan checks that mask is well formed for a ban of kind, and returns
+// the normalized ban.
+func newBan(kind banKind, mask, reason, setter string, duration time.Duration) (serverBan, error) {
+	b := serverBan{kind: kind, reason: reason, setter: setter, set: time.Now()}
+	if duration > 0 {
+		b.expires = b.set.Add(duration)
+	}
+
+	if kind == dline {
+		if ip := net.ParseIP(mask); ip != nil {
+			b.mask = ip.String()
+		} else if _, ipNet, err := net.ParseCIDR(mask); err == nil {
+			b.mask = ipNet.String()
+		} else {
+			return b, ErrBadBanMask
+		}
+		return b, nil
+	}
+
+	if !strings.Contains(mask, "@") {
+		mask = "*@" + mask
+	}
+	if mask == "*@*" || strings.ContainsAny(mask, " !") {
+		return b, ErrBadBanMask
+	}
+	b.mask = strings.ToLower(mask)
+	return b, nil
+}
+
+func (b serverBan) String() string {
+	return string(b.kind) + "-line " + b.mask
+}
+
+// matches returns true if c is covered by b.
+func (b serverBan) matches(c *client.Client) bool {
+	if b.kind == dline {
+		return b.matchesAddr(c.RemoteAddr())
+	}
+
+	user := strings.ToLower(c.User)
+	if wild.Match(b.mask, user+"@"+strings.ToLower(c.Host)) || wild.Match(b.mask, user+"@"+strings.ToLower(c.RealHost)) {
+		return true
+	}
+	ip := addrIP(c.RemoteAddr())
+	return ip != nil && wild.Match(b.mask, user+"@"+ip.String())
+}
+
+// matchesAddr returns true if b is a D-line that covers addr.
+func (b serverBan) matchesAddr(addr net.Addr) bool {
+	ip := addrIP(addr)
+	if b.kind != dline || ip == nil {
+		return false
+	}
+	if _, ipNet, err := net.ParseCIDR(b.mask); err == nil {
+		return ipNet.Contains(ip)
+	}
+	return ip.Equal(net.ParseIP(b.mask))
+}
+
+func addrIP(addr net.Addr) net.IP {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
+
+// addBan persists b, replacing any ban of the same kind and mask, and
+// disconnects every local client that it matches.
+func (s *Server) addBan(b serverBan) error {
+	var expires int64
+	if !b.expires.IsZero() {
+		expires = b.expires.Unix()
+	}
+	_, err := s.db.Exec("INSERT OR REPLACE INTO server_bans VALUES(?, ?, ?, ?, ?, ?)", string(b.kind), b.mask, b.reason, b.setter, b.set.Unix(), expires)
+	if err != nil {
+		return err
+	}
+
+	var banned []*client.Client
+	for _, c := range s.clients.All() {
+		if c.Server == "" && b.matches(c) {
+			banned = append(banned, c)
+		}
+	}
+	for _, c := range banned {
+		s.disconnectBanned(c, b)
+	}
+	return nil
+}
+
+// removeBan deletes the ban of kind on mask. It returns false if no such
+// ban existed.
+func (s *Server) removeBan(kind banKind, mask string) (bool, error) {
+	if b, err := newBan(kind, mask, "", "", 0); err == nil {
+		mask = b.mask
+	}
+	res, err := s.db.Exec("DELETE FROM server_bans WHERE kind = ? AND mask = ?", string(kind), mask)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// bans returns every active ban of the given kinds. Expired bans are
+// removed.
+func (s *Server) bans(kinds ...banKind) ([]serverBan, error) {
+	now := time.Now().Unix()
+	if _, err := s.db.Exec("DELETE FROM server_bans WHERE expires != 0 AND expires <= ?", now); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query("SELECT kind, mask, reason, setter, setAt, expires FROM server_bans ORDER BY setAt")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var bans []serverBan
+	for rows.Next() {
+		var b serverBan
+		var set, expires int64
+		if err := rows.Scan(&b.kind, &b.mask, &b.reason, &b.setter, &set, &expires); err != nil {
+			return nil, err
+		}
+		b.set = time.Unix(set, 0)
+		if expires != 0 {
+			b.expires = time.Unix(expires, 0)
+		}
+
+		for _, k := range kinds {
+			if b.kind == k {
+				bans = append(bans, b)
+				break
+			}
+		}
+	}
+	return bans, rows.Err()
+}
+
+// bannedAddr returns the D-line that covers addr, if there is one.
+func (s *Server) bannedAddr(addr net.Addr) (serverBan, bool) {
+	bans, err := s.bans(dline)
+	if err != nil {
+		s.logger.Error("could not load bans", "err", err)
+	}
+	for _, b := range bans {
+		if b.matchesAddr(addr) {
+			return b, true
+		}
+	}
+	return serverBan{}, false
+}
+
+// bannedClient returns the ban that covers c, if there is one.
+func (s *Server) bannedClient(c *client.Client) (serverBan, bool) {
+	bans, err := s.bans(kline, gline, dline)
+	if err != nil {
+		s.logger.Error("could not load bans", "err", err)
+	}
+	for _, b := range bans {
+		if b.matches(c) {
+			return b, true
+		}
+	}
+	return serverBan{}, false
+}
+
+// disconnectBanned removes a registered client that is covered by b from
+// the network.
+func (s *Server) disconnectBanned(c *client.Client, b serverBan) {
+	c.WriteMessage(prepMessage(ERR_YOUREBANNEDCREEP, s.Name, c.Id(), b.reason))
+	s.kill(c, "Banned: "+b.reason, nil)
+}
+
+// rejectConn closes a connection that is covered by a D-line before it
+// can register. It returns true if conn was closed.
+func (s *Server) rejectConn(conn net.Conn) bool {
+	b, ok := s.bannedAddr(conn.RemoteAddr())
+	if !ok {
+		return false
+	}
+	conn.Write(msg.New(nil, "", "", "", "ERROR", []string{"Closing Link: Banned: " + b.reason}, true).Bytes())
+	conn.Close()
+	return true
+}
+
+// parseBan reads the parameters shared by KLINE, DLINE and GLINE:
+//
+// [<minutes>] <mask> [:<reason>]
+func parseBan(kind banKind, setter string, params []string) (serverBan, error) {
+	var duration time.Duration
+	if minutes, err := strconv.Atoi(params[0]); err == nil && len(params) > 1 {
+		duration = time.Duration(minutes) * time.Minute
+		params = params[1:]
+	}
+
+	reason := "No reason given"
+	if len(params) > 1 && params[1] != "" {
+		reason = params[1]
+	}
+	return newBan(kind, params[0], reason, setter, duration)
+}
+
+// addBanCommand implements KLINE, DLINE and GLINE.
+func addBanCommand(kind banKind) executor {
+	command := string(kind) + "LINE"
+	return func(s *Server, c *client.Client, m *msg.Message) msg.Msg {
+		if !s.hasPrivilege(c, privBan) {
+			return prepMessage(ERR_NOPRIVILEGES, s.Name, c.Id())
+		}
+		if len(m.Params) < 1 {
+			return prepMessage(ERR_NEEDMOREPARAMS, s.Name, c.Id(), command)
+		}
+
+		b, err := parseBan(kind, c.Nick, m.Params)
+		if err != nil {
+			return s.NOTICE(c, "Invalid mask for "+command)
+		}
+		if err := s.addBan(b); err != nil {
+			s.logger.Error("could not add ban", "ban", b.String(), "err", err)
+			return s.NOTICE(c, "Could not add "+b.String())
+		}
+		if kind == gline {
+			s.propagate(nil, glineMessage(s.Name, b))
+		}
+
+		expiry := "permanent"
+		if !b.expires.IsZero() {
+			expiry = "expires " + b.expires.UTC().Format(time.RFC3339)
+		}
+		s.snotice(client.SnoKill, c.Nick+" added "+b.String()+" ("+expiry+"): "+b.reason)
+		return s.NOTICE(c, "Added "+b.String()+" ("+expiry+"): "+b.reason)
+	}
+}
+
+// removeBanCommand implements UNKLINE, UNDLINE and UNGLINE.
+func removeBanCommand(kind banKind) executor {
+	command := "UN" + string(kind) + "LINE"
+	return func(s *Server, c *client.Client, m *msg.Message) msg.Msg {
+		if !s.hasPrivilege(c, privBan) {
+			return prepMessage(ERR_NOPRIVILEGES, s.Name, c.Id())
+		}
+		if len(m.Params) < 1 {
+			return prepMessage(ERR_NEEDMOREPARAMS, s.Name, c.Id(), command)
+		}
+
+		ok, err := s.removeBan(kind, m.Params[0])
+		if err != nil || !ok {
+			return s.NOTICE(c, "No "+string(kind)+"-line for "+m.Params[0])
+		}
+		if kind == gline {
+			s.propagate(nil, msg.New(nil, s.Name, "", "", "UNGLINE", []string{m.Params[0]}, false))
+		}
+		s.snotice(client.SnoKill, c.Nick+" removed "+string(kind)+"-line "+m.Params[0])
+		return s.NOTICE(c, "Removed "+string(kind)+"-line "+m.Params[0])
+	}
+}
+
+// KILL <nick> <comment>
+func KILL(s *Server, c *client.Client, m *msg.Message) msg.Msg {
+	if !s.hasPrivilege(c, privKill) {
+		return prepMessage(ERR_NOPRIVILEGES, s.Name, c.Id())
+	}
+	if len(m.Params) < 2 {
+		return prepMessage(ERR_NEEDMOREPARAMS, s.Name, c.Id(), "KILL")
+	}
+
+	target, ok := s.getClient(m.Params[0])
+	if !ok {
+		return prepMessage(ERR_NOSUCHNICK, s.Name, c.Id(), m.Params[0])
+	}
+
+	reason := "Killed (" + c.Nick + " (" + m.Params[1] + "))"
+	if target.Server == "" {
+		target.WriteMessage(msg.New(nil, c.String(), "", "", "KILL", []string{target.Nick, m.Params[1]}, true))
+	}
+	s.kill(target, reason, nil)
+	s.snotice(client.SnoKill, "Received KILL message for "+describe(target)+" from "+c.Nick+": "+m.Params[1])
+	return nil
+}
+
+// STATS <query>
+func STATS(s *Server, c *client.Client, m *msg.Message) msg.Msg {
+	if len(m.Params) < 1 || m.Params[0] == "" {
+		return prepMessage(ERR_NEEDMOREPARAMS, s.Name, c.Id(), "STATS")
+	}
+	query := m.Params[0][:1]
+
+	var kind banKind
+	switch query {
+	case "k", "K":
+		kind = kline
+	case "g", "G":
+		kind = gline
+	case "d", "D":
+		kind = dline
+	}
+
+	var buff msg.Buffer
+	if kind != "" {
+		if !s.hasPrivilege(c, privBan) {
+			return prepMessage(ERR_NOPRIVILEGES, s.Name, c.Id())
+		}
+
+		reply := RPL_STATSKLINE
+		if kind == dline {
+			reply = RPL_STATSDLINE
+		}
+
+		bans, _ := s.bans(kind)
+		for _, b := range bans {
+			expires := "0"
+			if !b.expires.IsZero() {
+				expires = strconv.FormatInt(b.expires.Unix(), 10)
+			}
+			buff.AddMsg(prepMessage(reply, s.Name, c.Id(), b.kind, b.mask, expires, b.setter, b.reason))
+		}
+	}
+	buff.AddMsg(prepMessage(RPL_ENDOFSTATS, s.Name, c.Id(), query))
+	return buff
+}
+
+// glineMessage shares b with other servers.
+//
+// :<server> GLINE <mask> <expires> <setter> :<reason>
+func glineMessage(server string, b serverBan) *msg.Message {
+	var expires int64
+	if !b.expires.IsZero() {
+		expires = b.expires.Unix()
+	}
+	return msg.New(nil, server, "", "", "GLINE", []string{b.mask, strconv.FormatInt(expires, 10), b.setter, b.reason}, true)
+}
+
+// :<server> GLINE <mask> <expires> <setter> :<reason>
+func linkGLINE(s *Server, l *link, m *msg.Message) bool {
+	if len(m.Params) < 4 {
+		return false
+	}
+
+	b, err := newBan(gline, m.Params[0], m.Params[3], m.Params[2], 0)
+	if err != nil {
+		return false
+	}
+	if expires, _ := strconv.ParseInt(m.Params[1], 10, 64); expires != 0 {
+		b.expires = time.Unix(expires, 0)
+		if time.Now().After(b.expires) {
+			return false
+		}
+	}
+	return s.addBan(b) == nil
+}
+
+// :<server> UNGLINE <mask>
+func linkUNGLINE(s *Server, l *link, m *msg.Message) bool {
+	if len(m.Params) < 1 {
+		return false
+	}
+	ok, _ := s.removeBan(gline, m.Params[0])
+	return ok
+}
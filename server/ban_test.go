@@ -0,0 +1,116 @@
+package server
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/mitchr/gossip/client"
+)
+
+func TestServerBans(t *testing.T) {
+	t.Parallel()
+
+	s, err := New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	go s.Serve()
+
+	oper, operR := s.connectAndRegister("alice")
+	defer oper.Close()
+
+	t.Run("NoPrivileges", func(t *testing.T) {
+		oper.Write([]byte("KLINE bob@*\r\n"))
+		resp, _ := operR.ReadBytes('\n')
+		assertResponse(resp, prepMessage(ERR_NOPRIVILEGES, s.Name, "alice").String(), t)
+	})
+
+	alice, _ := s.getClient("alice")
+	alice.SetMode(client.Op)
+
+	t.Run("KLINE", func(t *testing.T) {
+		bob, bobR := s.connectAndRegister("bob")
+		defer bob.Close()
+
+		oper.Write([]byte("KLINE bob@* :go away\r\n"))
+		resp, _ := operR.ReadBytes('\n')
+		assertResponse(resp, "NOTICE :Added K-line bob@* (permanent): go away\r\n", t)
+
+		banned, _ := bobR.ReadBytes('\n')
+		errResp, _ := bobR.ReadBytes('\n')
+		assertResponse(banned, prepMessage(ERR_YOUREBANNEDCREEP, s.Name, "bob", "go away").String(), t)
+		assertResponse(errResp, "ERROR :Banned: go away\r\n", t)
+	})
+
+	t.Run("RejectedOnRegistration", func(t *testing.T) {
+		c, _ := net.Dial("tcp", ":"+s.port())
+		defer c.Close()
+		r := bufio.NewReader(c)
+
+		c.Write([]byte("NICK bob2\r\nUSER bob 0 0 :bob\r\n"))
+		resp, _ := r.ReadBytes('\n')
+		assertResponse(resp, prepMessage(ERR_YOUREBANNEDCREEP, s.Name, "bob2", "go away").String(), t)
+	})
+
+	t.Run("STATS", func(t *testing.T) {
+		oper.Write([]byte("KLINE 10 *@example.com\r\n"))
+		operR.ReadBytes('\n')
+
+		oper.Write([]byte("STATS k\r\n"))
+		first, _ := operR.ReadBytes('\n')
+		second, _ := operR.ReadBytes('\n')
+		end, _ := operR.ReadBytes('\n')
+		assertResponse(first, ":gossip 216 alice K bob@* 0 alice :go away\r\n", t)
+		if !strings.HasPrefix(string(second), ":gossip 216 alice K *@example.com ") || strings.Contains(string(second), " 0 alice") {
+			t.Error("expected timed K-line, got", string(second))
+		}
+		assertResponse(end, prepMessage(RPL_ENDOFSTATS, s.Name, "alice", "k").String(), t)
+	})
+
+	t.Run("UNKLINE", func(t *testing.T) {
+		oper.Write([]byte("UNKLINE bob@*\r\nUNKLINE bob@*\r\n"))
+		removed, _ := operR.ReadBytes('\n')
+		missing, _ := operR.ReadBytes('\n')
+		assertResponse(removed, "NOTICE :Removed K-line bob@*\r\n", t)
+		assertResponse(missing, "NOTICE :No K-line for bob@*\r\n", t)
+
+		c, r := s.connectAndRegister("bob")
+		defer c.Close()
+		c.Write([]byte("PING a\r\n"))
+		resp, _ := r.ReadBytes('\n')
+		assertResponse(resp, ":gossip PONG gossip a\r\n", t)
+	})
+
+	t.Run("KILL", func(t *testing.T) {
+		c, r := s.connectAndRegister("carl")
+		defer c.Close()
+
+		oper.Write([]byte("KILL carl :spamming\r\n"))
+		kill, _ := r.ReadBytes('\n')
+		errResp, _ := r.ReadBytes('\n')
+		assertResponse(kill, ":alice!alice@localhost KILL carl :spamming\r\n", t)
+		assertResponse(errResp, "ERROR :Killed (alice (spamming))\r\n", t)
+
+		oper.Write([]byte("KILL carl :again\r\n"))
+		resp, _ := operR.ReadBytes('\n')
+		assertResponse(resp, prepMessage(ERR_NOSUCHNICK, s.Name, "alice", "carl").String(), t)
+	})
+
+	t.Run("DLINE", func(t *testing.T) {
+		oper.Write([]byte("DLINE 10.0.0.1/8\r\n"))
+		resp, _ := operR.ReadBytes('\n')
+		assertResponse(resp, "NOTICE :Added D-line 10.0.0.0/8 (permanent): No reason given\r\n", t)
+
+		oper.Write([]byte("DLINE 127.0.0.1 :local\r\n"))
+		banned, _ := operR.ReadBytes('\n')
+		assertResponse(banned, prepMessage(ERR_YOUREBANNEDCREEP, s.Name, "alice", "local").String(), t)
+
+		c, _ := net.Dial("tcp", "127.0.0.1:"+s.port())
+		defer c.Close()
+		resp, _ = bufio.NewReader(c).ReadBytes('\n')
+		assertResponse(resp, "ERROR :Closing Link: Banned: local\r\n", t)
+	})
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"github.com/mitchr/gossip/client"
+	"github.com/mitchr/gossip/scan/msg"
+)
+
+// identityOf returns the local registered client that is logged in to
+// the same account as c. If bouncing is disabled, or no such client
+// exists, identityOf returns nil. Clients on other servers are never
+// returned, since their connection is the link.
+func (s *Server) identityOf(c *client.Client) *client.Client {
+	if !s.Bouncer.Enabled || !c.IsAuthenticated {
+		return nil
+	}
+
+	for _, v := range s.clients.All() {
+		if v.Server == "" && v.IsAuthenticated && v.SASLMech.Authn() == c.SASLMech.Authn() {
+			return v
+		}
+	}
+	return nil
+}
+
+// attachSession finishes the registration of c by attaching it to
+// identity. c is sent the usual registration burst, followed by the
+// state of every channel that identity belongs to.
+func (s *Server) attachSession(identity, c *client.Client) msg.Msg {
+	identity.Attach(c)
+	s.unknowns.Dec()
+
+	buff := s.welcome(c)
+	for ch := range s.channelsOf(identity) {
+		buff.AddMsg(msg.New(nil, c.String(), "", "", "JOIN", []string{ch.String()}, false))
+		if ch.Topic != "" {
+			buff.AddMsg(TOPIC(s, c, &msg.Message{Params: []string{ch.String()}}))
+		}
+		buff.AddMsg(NAMES(s, c, &msg.Message{Params: []string{ch.String()}}))
+	}
+	if identity.Is(client.Away) {
+		buff.AddMsg(prepMessage(RPL_NOWAWAY, s.Name, c.Id()))
+	}
+	return buff
+}
+
+// detachSession closes the connection of c without removing its
+// identity from the server. This only happens if the identity has other
+// connections, or if it is allowed to stay online without any. If c
+// should instead quit as normal, detachSession returns false.
+func (s *Server) detachSession(c *client.Client, reason string) bool {
+	if !s.Bouncer.Enabled {
+		return false
+	}
+
+	identity := c.Identity()
+	if !identity.Detach(c, s.Bouncer.AlwaysOn && identity.IsAuthenticated) {
+		return false
+	}
+
+	c.WriteToConn(msg.New(nil, "", "", "", "ERROR", []string{reason}, true))
+	c.Close()
+	return true
+}
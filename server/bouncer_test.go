@@ -0,0 +1,233 @@
+package server
+
+import (
+	"bufio"
+	"encoding/base64"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mitchr/gossip/client"
+	"github.com/mitchr/gossip/sasl/plain"
+)
+
+func TestBouncer(t *testing.T) {
+	t.Parallel()
+
+	conf := &Config{Name: "gossip", Port: ":0"}
+	conf.Cloak.Disabled = true
+	conf.Bouncer.Enabled = true
+	conf.Bouncer.AlwaysOn = true
+
+	s, err := New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	go s.Serve()
+
+	cred := plain.NewCredential("alice", "pass")
+	s.persistPlain(cred.Username, "alice", cred.Pass)
+
+	bob, bobR := s.connectAndRegister("bob")
+	defer bob.Close()
+	bob.Write([]byte("JOIN #test\r\n"))
+	readLines(bobR, 3)
+
+	c1, r1 := s.connectAndAuthenticate("alice", "alice", "pass")
+	defer c1.Close()
+	c1.Write([]byte("JOIN #test\r\n"))
+	readLines(r1, 3)
+	bobR.ReadBytes('\n')
+
+	c2, r2 := s.connectAndAuthenticate("alice2", "alice", "pass")
+	defer c2.Close()
+
+	t.Run("AttachReceivesChannels", func(t *testing.T) {
+		join, _ := r2.ReadBytes('\n')
+		assertResponse(join, ":alice!alice@localhost JOIN #test\r\n", t)
+		readLines(r2, 2)
+	})
+
+	t.Run("FanOut", func(t *testing.T) {
+		bob.Write([]byte("PRIVMSG #test :hello\r\n"))
+		resp1, _ := r1.ReadBytes('\n')
+		resp2, _ := r2.ReadBytes('\n')
+		assertResponse(resp1, ":bob!bob@localhost PRIVMSG #test :hello\r\n", t)
+		assertResponse(resp2, ":bob!bob@localhost PRIVMSG #test :hello\r\n", t)
+	})
+
+	t.Run("SessionSpeaksForIdentity", func(t *testing.T) {
+		c2.Write([]byte("PRIVMSG #test :hi\r\n"))
+		resp, _ := bobR.ReadBytes('\n')
+		assertResponse(resp, ":alice!alice@localhost PRIVMSG #test :hi\r\n", t)
+	})
+
+	t.Run("BannedSession", func(t *testing.T) {
+		b, _ := newBan(kline, "evil@*", "go away", "gossip", 0)
+		s.addBan(b)
+		defer s.removeBan(kline, "evil@*")
+
+		c, _ := net.Dial("tcp", ":"+s.port())
+		defer c.Close()
+		r := bufio.NewReader(c)
+
+		creds := base64.StdEncoding.EncodeToString([]byte("\000alice\000pass"))
+		c.Write([]byte("CAP REQ sasl\r\nAUTHENTICATE PLAIN\r\n"))
+		readLines(r, 2)
+		c.Write([]byte("AUTHENTICATE " + creds + "\r\n"))
+		readLines(r, 2)
+
+		c.Write([]byte("NICK alice4\r\nUSER evil 0 0 :evil\r\nCAP END\r\n"))
+		resp, _ := r.ReadBytes('\n')
+		assertResponse(resp, prepMessage(ERR_YOUREBANNEDCREEP, s.Name, "alice4", "go away").String(), t)
+	})
+
+	t.Run("QuitKeepsIdentity", func(t *testing.T) {
+		c1.Write([]byte("QUIT\r\n"))
+		r1.ReadBytes('\n')
+		c2.Write([]byte("QUIT\r\n"))
+		r2.ReadBytes('\n')
+
+		bob.Write([]byte("NAMES #test\r\n"))
+		resp, _ := bobR.ReadBytes('\n')
+		// member order is not fixed
+		if string(resp) != prepMessage(RPL_NAMREPLY, s.Name, "bob", "=", "#test", "@bob alice").String() &&
+			string(resp) != prepMessage(RPL_NAMREPLY, s.Name, "bob", "=", "#test", "alice @bob").String() {
+			t.Error("alice left #test:", string(resp))
+		}
+		bobR.ReadBytes('\n')
+	})
+
+	t.Run("Reattach", func(t *testing.T) {
+		c3, r3 := s.connectAndAuthenticate("alice3", "alice", "pass")
+		defer c3.Close()
+
+		join, _ := r3.ReadBytes('\n')
+		assertResponse(join, ":alice!alice@localhost JOIN #test\r\n", t)
+	})
+}
+
+func TestBouncerNickChange(t *testing.T) {
+	t.Parallel()
+
+	conf := &Config{Name: "gossip", Port: ":0"}
+	conf.Cloak.Disabled = true
+	conf.Bouncer.Enabled = true
+
+	s, err := New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	go s.Serve()
+
+	cred := plain.NewCredential("alice", "pass")
+	s.persistPlain(cred.Username, "alice", cred.Pass)
+
+	bob, bobR := s.connectAndRegister("bob")
+	defer bob.Close()
+	bob.Write([]byte("JOIN #test\r\n"))
+	readLines(bobR, 3)
+
+	c1, r1 := s.connectAndAuthenticate("alice", "alice", "pass")
+	defer c1.Close()
+	c1.Write([]byte("JOIN #test\r\n"))
+	readLines(r1, 3)
+	bobR.ReadBytes('\n')
+
+	c2, r2 := s.connectAndAuthenticate("alice2", "alice", "pass")
+	defer c2.Close()
+	readLines(r2, 3)
+
+	c1.Write([]byte("NICK ali\r\n"))
+	r1.ReadBytes('\n')
+	bobR.ReadBytes('\n')
+
+	// the session that was attached under the old nick is the last one
+	// to leave
+	c1.Write([]byte("QUIT\r\n"))
+	r1.ReadBytes('\n')
+	c2.Write([]byte("QUIT :bye\r\n"))
+	resp, _ := r2.ReadBytes('\n')
+	assertResponse(resp, "ERROR :bye\r\n", t)
+
+	quit, _ := bobR.ReadBytes('\n')
+	assertResponse(quit, ":ali!alice@localhost QUIT :bye\r\n", t)
+	if _, ok := s.getClient("ali"); ok {
+		t.Error("client was left behind after its last session quit")
+	}
+	ch, _ := s.getChannel("#test")
+	if _, ok := ch.GetMember("ali"); ok {
+		t.Error("client was left in #test after its last session quit")
+	}
+}
+
+func TestBouncerKill(t *testing.T) {
+	t.Parallel()
+
+	conf := &Config{Name: "gossip", Port: ":0"}
+	conf.Cloak.Disabled = true
+	conf.Bouncer.Enabled = true
+
+	s, err := New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	go s.Serve()
+
+	cred := plain.NewCredential("alice", "pass")
+	s.persistPlain(cred.Username, "alice", cred.Pass)
+
+	oper, operR := s.connectAndRegister("oper")
+	defer oper.Close()
+	o, _ := s.getClient("oper")
+	o.SetMode(client.Op)
+
+	c1, r1 := s.connectAndAuthenticate("alice", "alice", "pass")
+	defer c1.Close()
+	c2, r2 := s.connectAndAuthenticate("alice2", "alice", "pass")
+	defer c2.Close()
+
+	// a session that is left open would block the test forever
+	c1.SetReadDeadline(time.Now().Add(5 * time.Second))
+	c2.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	oper.Write([]byte("KILL alice :spamming\r\n"))
+	for _, r := range []*bufio.Reader{r1, r2} {
+		kill, _ := r.ReadBytes('\n')
+		errResp, _ := r.ReadBytes('\n')
+		assertResponse(kill, ":oper!oper@localhost KILL alice :spamming\r\n", t)
+		assertResponse(errResp, "ERROR :Killed (oper (spamming))\r\n", t)
+
+		// the connection is closed
+		if _, err := r.ReadBytes('\n'); err == nil {
+			t.Error("expected connection to be closed")
+		}
+	}
+
+	oper.Write([]byte("PING a\r\n"))
+	operR.ReadBytes('\n')
+	if _, ok := s.getClient("alice"); ok {
+		t.Error("expected alice to be removed")
+	}
+}
+
+// connectAndAuthenticate connects to s and logs in to account with
+// SASL PLAIN before registering with nick. The returned reader has
+// already read past the registration burst.
+func (s *Server) connectAndAuthenticate(nick, account, pass string) (net.Conn, *bufio.Reader) {
+	c, _ := net.Dial("tcp", ":"+s.port())
+	r := bufio.NewReader(c)
+
+	creds := base64.StdEncoding.EncodeToString([]byte("\000" + account + "\000" + pass))
+	c.Write([]byte("CAP REQ sasl\r\nAUTHENTICATE PLAIN\r\n"))
+	readLines(r, 2)
+	c.Write([]byte("AUTHENTICATE " + creds + "\r\n"))
+	readLines(r, 2)
+
+	c.Write([]byte("NICK " + nick + "\r\nUSER " + account + " 0 0 :" + account + "\r\nCAP END\r\n"))
+	readLines(r, 14)
+	return c, r
+}
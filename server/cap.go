@@ -3,6 +3,7 @@ package server
 import (
 	"crypto/x509"
 	"fmt"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -123,20 +124,62 @@ func CAP(s *Server, c *client.Client, m *msg.Message) msg.Msg {
 func TAGMSG(s *Server, c *client.Client, m *msg.Message) msg.Msg { return s.communicate(m, c) }
 
 func (s *Server) capString(cap302Enabled bool) string {
-	caps := ""
+	caps := make([]string, len(s.supportedCaps))
 	for i, v := range s.supportedCaps {
-		caps += v.Name
-		if cap302Enabled && len(v.Value) > 0 {
-			if v == cap.STS {
-				caps += "=" + s.getSTSValue()
-			} else {
-				caps += "=" + v.Value
-			}
-		}
-		if i != len(s.supportedCaps)-1 {
-			caps += " "
+		caps[i] = v.Name
+		if value := s.capValue(v); cap302Enabled && value != "" {
+			caps[i] += "=" + value
 		}
 	}
+	return strings.Join(caps, " ")
+}
+
+// capValue returns the value that v is advertised with to clients that
+// support CAP 302.
+func (s *Server) capValue(v cap.Cap) string {
+	if len(v.Value) == 0 {
+		return ""
+	}
+
+	switch v {
+	case cap.STS:
+		return s.getSTSValue()
+	case cap.SASL:
+		return s.saslMechs()
+	case cap.AccountRegistration:
+		return s.accountRegistrationValue()
+	default:
+		return v.Value
+	}
+}
+
+// supportedCaps returns the capabilities that a server with config c
+// supports, sorted by name.
+func supportedCaps(c *Config) []cap.Cap {
+	caps := []cap.Cap{
+		cap.AccountNotify,
+		cap.AccountTag,
+		cap.AwayNotify,
+		cap.Batch,
+		cap.CapNotify,
+		cap.AccountRegistration,
+		cap.ChatHistory,
+		cap.EchoMessage,
+		cap.ExtendedJoin,
+		cap.ExtendedMonitor,
+		cap.InviteNotify,
+		cap.LabeledResponses,
+		cap.MessageTags,
+		cap.MultiPrefix,
+		cap.SASL,
+		cap.ServerTime,
+		cap.Setname,
+		cap.UserhostInNames,
+	}
+	if c.TLS.Enabled && c.TLS.STS.Enabled {
+		caps = append(caps, cap.STS)
+	}
+	slices.SortFunc(caps, func(a, b cap.Cap) int { return strings.Compare(a.Name, b.Name) })
 	return caps
 }
 
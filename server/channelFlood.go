@@ -0,0 +1,96 @@
+package server
+
+import (
+	"strings"
+	"time"
+
+	"github.com/mitchr/gossip/channel"
+	"github.com/mitchr/gossip/client"
+	"github.com/mitchr/gossip/scan/mode"
+	"github.com/mitchr/gossip/scan/msg"
+)
+
+// checkFlood counts an event of kind by c in ch. If this goes over the
+// +f limit of ch, the action of the limit is taken and true is
+// returned. Members with halfop or higher are not counted.
+func (s *Server) checkFlood(ch *channel.Channel, c *client.Client, kind byte) bool {
+	if m, ok := ch.GetMember(c.Nick); ok && m.Prefix&^channel.Voice != 0 {
+		return false
+	}
+	limit, flooded := ch.Flooded(kind, c.Nick)
+	if !flooded {
+		return false
+	}
+	s.snotice(client.SnoFlood, "Channel flood in "+ch.String()+" by "+describe(c))
+
+	switch limit.Action {
+	case channel.FloodKick:
+		s.serverKick(ch, c.Nick, "Flooding")
+	case channel.FloodMute:
+		// muted by account or user@host, so that changing nicks doesn't
+		// get around it
+		mute := "$m:*!" + c.User + "@" + c.Host
+		if c.IsAuthenticated {
+			mute = "$m:$a:" + c.SASLMech.Authn()
+		}
+		mute = strings.ToLower(mute)
+		if _, muted := ch.ListEntry('b', mute); !muted {
+			// the ban list may be full, in which case the flooder is
+			// kicked instead
+			if !s.serverMode(ch, mode.Mode{Type: mode.Add, ModeChar: 'b', Param: mute, Duration: limit.Duration}) {
+				s.serverKick(ch, c.Nick, "Flooding")
+			}
+		}
+	case channel.FloodModerate:
+		s.setTemporaryMode(ch, 'm', limit.Duration)
+	case channel.FloodInvite:
+		s.setTemporaryMode(ch, 'i', limit.Duration)
+	}
+	return true
+}
+
+// setTemporaryMode sets +m or +i on ch, and removes it again after d.
+// If the mode is already set, nothing is done.
+func (s *Server) setTemporaryMode(ch *channel.Channel, char byte, d time.Duration) {
+	isSet := func() bool {
+		if char == 'm' {
+			return ch.Moderated
+		}
+		return ch.Invite
+	}
+	if isSet() {
+		return
+	}
+
+	s.serverMode(ch, mode.Mode{Type: mode.Add, ModeChar: char})
+	time.AfterFunc(d, func() {
+		// the channel may have been deleted, or the mode removed by an
+		// operator in the meantime
+		if current, ok := s.getChannel(ch.String()); !ok || current != ch || !isSet() {
+			return
+		}
+		s.serverMode(ch, mode.Mode{Type: mode.Remove, ModeChar: char})
+	})
+}
+
+// serverMode applies m to ch on behalf of the server, and announces it.
+// It returns false if m could not be applied.
+func (s *Server) serverMode(ch *channel.Channel, m mode.Mode) bool {
+	if ch.ApplyModeBy(m, s.Name) != nil {
+		return false
+	}
+	s.scheduleExpiry(ch, m.ModeChar, m.Param)
+	change := msg.New(nil, s.Name, "", "", "MODE", []string{ch.String(), buildModestr([]mode.Mode{m})}, false)
+	ch.WriteMessage(change)
+	s.propagateChan(ch, change)
+	return true
+}
+
+// serverKick kicks nick from ch on behalf of the server, and announces
+// it.
+func (s *Server) serverKick(ch *channel.Channel, nick, reason string) {
+	kick := msg.New(nil, s.Name, "", "", "KICK", []string{ch.String(), nick, reason}, true)
+	ch.WriteMessage(kick)
+	s.propagateChan(ch, kick)
+	s.removeMember(ch.String(), nick)
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/mitchr/gossip/channel"
+)
+
+func TestChannelFlood(t *testing.T) {
+	t.Parallel()
+
+	s, err := New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	go s.Serve()
+
+	op, opR := s.connectAndRegister("alice")
+	defer op.Close()
+
+	t.Run("InvalidParam", func(t *testing.T) {
+		op.Write([]byte("JOIN #invalid\r\nMODE #invalid +f 5x:10\r\n"))
+		resp, _ := readLines(opR, 4)
+		assertResponse(resp, prepMessage(ERR_INVALIDMODEPARAM, s.Name, "alice", "#invalid", "f", "5x:10").String(), t)
+	})
+
+	t.Run("Messages", func(t *testing.T) {
+		op.Write([]byte("JOIN #flood\r\nMODE #flood +nf 2m:10\r\n"))
+		resp, _ := readLines(opR, 4)
+		assertResponse(resp, ":gossip MODE #flood +nf 2m:10\r\n", t)
+
+		c, r := s.connectAndRegister("bob")
+		defer c.Close()
+		c.Write([]byte("JOIN #flood\r\n"))
+		readLines(r, 3)
+		readLines(opR, 1)
+
+		c.Write([]byte("PRIVMSG #flood :1\r\nPRIVMSG #flood :2\r\nPRIVMSG #flood :3\r\n"))
+		kick, _ := r.ReadBytes('\n')
+		assertResponse(kick, ":gossip KICK #flood bob :Flooding\r\n", t)
+		resp, _ = readLines(opR, 3)
+		assertResponse(resp, ":gossip KICK #flood bob :Flooding\r\n", t)
+	})
+
+	t.Run("Mute", func(t *testing.T) {
+		op.Write([]byte("JOIN #mute\r\nMODE #mute +f 1m#q:10\r\n"))
+		readLines(opR, 4)
+
+		c, r := s.connectAndRegister("ivan")
+		defer c.Close()
+		c.Write([]byte("JOIN #mute\r\n"))
+		readLines(r, 3)
+		readLines(opR, 1)
+
+		c.Write([]byte("PRIVMSG #mute :1\r\nPRIVMSG #mute :2\r\n"))
+		readLines(opR, 2)
+		readLines(r, 1)
+		ch, _ := s.getChannel("#mute")
+		if _, ok := ch.ListEntry('b', "$m:*!ivan@localhost"); !ok {
+			t.Error("expected ivan to be muted by user@host, got", ch.List('b'))
+		}
+
+		// changing nicks does not lift the mute
+		c.Write([]byte("NICK ivan2\r\nPRIVMSG #mute :3\r\n"))
+		resp, _ := readLines(r, 2)
+		assertResponse(resp, prepMessage(ERR_CANNOTSENDTOCHAN, s.Name, "ivan2", "#mute").String(), t)
+		c.Write([]byte("PART #mute\r\n"))
+		readLines(opR, 2)
+	})
+
+	t.Run("MuteListFull", func(t *testing.T) {
+		op.Write([]byte("JOIN #full\r\nMODE #full +f 1m#q:10\r\n"))
+		readLines(opR, 4)
+		ch, _ := s.getChannel("#full")
+		for i := range channel.MaxListEntries {
+			ch.AddListEntry('b', channel.ListEntry{Mask: fmt.Sprintf("spam%d!*@*", i)})
+		}
+
+		c, r := s.connectAndRegister("jack")
+		defer c.Close()
+		c.Write([]byte("JOIN #full\r\n"))
+		readLines(r, 3)
+		readLines(opR, 1)
+
+		c.Write([]byte("PRIVMSG #full :1\r\nPRIVMSG #full :2\r\n"))
+		kick, _ := r.ReadBytes('\n')
+		assertResponse(kick, ":gossip KICK #full jack :Flooding\r\n", t)
+		resp, _ := readLines(opR, 2)
+		assertResponse(resp, ":gossip KICK #full jack :Flooding\r\n", t)
+	})
+
+	t.Run("Joins", func(t *testing.T) {
+		op.Write([]byte("JOIN #joins\r\nMODE #joins +f 1j#i1:10\r\n"))
+		readLines(opR, 4)
+
+		c1, r1 := s.connectAndRegister("carol")
+		defer c1.Close()
+		c1.Write([]byte("JOIN #joins\r\n"))
+		readLines(r1, 3)
+		readLines(opR, 1)
+
+		// the join that goes over the limit is let in
+		c2, r2 := s.connectAndRegister("dan")
+		defer c2.Close()
+		c2.Write([]byte("JOIN #joins\r\n"))
+		readLines(r2, 4)
+		readLines(opR, 1)
+		mode, _ := opR.ReadBytes('\n')
+		assertResponse(mode, ":gossip MODE #joins +i\r\n", t)
+
+		c3, r3 := s.connectAndRegister("gina")
+		defer c3.Close()
+		c3.Write([]byte("JOIN #joins\r\n"))
+		resp, _ := r3.ReadBytes('\n')
+		assertResponse(resp, prepMessage(ERR_INVITEONLYCHAN, s.Name, "gina", "#joins").String(), t)
+	})
+
+	t.Run("RejectedJoins", func(t *testing.T) {
+		op.Write([]byte("JOIN #keyed\r\nMODE #keyed +kf key 1j#i1:10\r\n"))
+		readLines(opR, 4)
+
+		c, r := s.connectAndRegister("hank")
+		defer c.Close()
+		for range 3 {
+			c.Write([]byte("JOIN #keyed\r\n"))
+			resp, _ := r.ReadBytes('\n')
+			assertResponse(resp, prepMessage(ERR_BADCHANNELKEY, s.Name, "hank", "#keyed").String(), t)
+		}
+		if ch, _ := s.getChannel("#keyed"); ch.Invite {
+			t.Error("rejected joins were counted")
+		}
+	})
+
+	t.Run("Throttle", func(t *testing.T) {
+		op.Write([]byte("JOIN #throttle\r\nMODE #throttle +j 1:60\r\n"))
+		readLines(opR, 4)
+
+		c1, r1 := s.connectAndRegister("erin")
+		defer c1.Close()
+		c1.Write([]byte("JOIN #throttle\r\n"))
+		readLines(r1, 3)
+
+		c2, r2 := s.connectAndRegister("frank")
+		defer c2.Close()
+		c2.Write([]byte("JOIN #throttle\r\n"))
+		resp, _ := r2.ReadBytes('\n')
+		assertResponse(resp, prepMessage(ERR_THROTTLE, s.Name, "frank", "#throttle").String(), t)
+	})
+}
@@ -0,0 +1,31 @@
+package server
+
+import (
+	"time"
+
+	"github.com/mitchr/gossip/channel"
+	"github.com/mitchr/gossip/scan/mode"
+)
+
+// scheduleExpiry removes the entry for mask from the list of ch that is
+// set with char once it expires, and announces the removal. Nothing is
+// done if the entry does not expire.
+func (s *Server) scheduleExpiry(ch *channel.Channel, char byte, mask string) {
+	e, ok := ch.ListEntry(char, mask)
+	if !ok || e.Expires.IsZero() {
+		return
+	}
+
+	time.AfterFunc(time.Until(e.Expires), func() {
+		if current, ok := s.getChannel(ch.String()); !ok || current != ch {
+			return
+		}
+		// the entry may have been removed, or set again with a different
+		// expiry in the meantime
+		if current, ok := ch.ListEntry(char, mask); !ok || !current.Expires.Equal(e.Expires) {
+			return
+		}
+		s.serverMode(ch, mode.Mode{Type: mode.Remove, ModeChar: char, Param: mask})
+		s.channelChanged(ch)
+	})
+}
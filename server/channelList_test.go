@@ -0,0 +1,96 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/mitchr/gossip/channel"
+)
+
+func TestTimedBan(t *testing.T) {
+	t.Parallel()
+
+	s, err := New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	go s.Serve()
+
+	c, r := s.connectAndRegister("alice")
+	defer c.Close()
+	c.Write([]byte("JOIN #test\r\nMODE #test +b ~t:1s:bob!*@*\r\n"))
+	set, _ := readLines(r, 4)
+	assertResponse(set, ":gossip MODE #test +b bob!*@*\r\n", t)
+
+	c.Write([]byte("MODE #test +b\r\n"))
+	list, _ := r.ReadBytes('\n')
+	if !strings.HasPrefix(string(list), ":gossip 367 alice #test bob!*@* alice ") {
+		t.Error("unexpected ban list entry", string(list))
+	}
+	r.ReadBytes('\n')
+
+	expired, _ := r.ReadBytes('\n')
+	assertResponse(expired, ":gossip MODE #test -b bob!*@*\r\n", t)
+
+	c.Write([]byte("MODE #test +b\r\n"))
+	end, _ := r.ReadBytes('\n')
+	assertResponse(end, prepMessage(RPL_ENDOFBANLIST, s.Name, "alice", "#test").String(), t)
+}
+
+func TestTimedBanTooLong(t *testing.T) {
+	t.Parallel()
+
+	s, err := New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	go s.Serve()
+
+	c, r := s.connectAndRegister("alice")
+	defer c.Close()
+	c.Write([]byte("JOIN #test\r\n"))
+	readLines(r, 3)
+
+	tests := []string{"~t:9999999999d:bob!*@*", "~t:-1h:bob!*@*", "~t:soon:bob!*@*", "~t:1h:"}
+	for _, v := range tests {
+		t.Run(v, func(t *testing.T) {
+			c.Write([]byte("MODE #test +b " + v + "\r\n"))
+			resp, _ := r.ReadBytes('\n')
+			assertResponse(resp, prepMessage(ERR_INVALIDMODEPARAM, s.Name, "alice", "#test", "b", v).String(), t)
+		})
+	}
+
+	ch, _ := s.getChannel("#test")
+	if list := ch.List('b'); len(list) != 0 {
+		t.Error("expected bad durations to be rejected, got", list)
+	}
+}
+
+func TestMaxList(t *testing.T) {
+	t.Parallel()
+
+	s, err := New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	go s.Serve()
+
+	c, r := s.connectAndRegister("alice")
+	defer c.Close()
+	c.Write([]byte("JOIN #test\r\n"))
+	readLines(r, 3)
+
+	for i := 0; i < channel.MaxListEntries; i++ {
+		s.clients.GetWithoutCheck("alice").FillGrants()
+		c.Write([]byte(fmt.Sprintf("MODE #test +b user%d!*@*\r\n", i)))
+		r.ReadBytes('\n')
+	}
+
+	c.Write([]byte("MODE #test +e full!*@*\r\n"))
+	resp, _ := r.ReadBytes('\n')
+	assertResponse(resp, prepMessage(ERR_BANLISTFULL, s.Name, "alice", "#test", "e").String(), t)
+}
@@ -0,0 +1,225 @@
+package server
+
+import (
+	"database/sql"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/mitchr/gossip/channel"
+	"github.com/mitchr/gossip/client"
+	"github.com/mitchr/gossip/scan/mode"
+)
+
+// loadChannels recreates every registered channel from the database.
+func (s *Server) loadChannels() error {
+	rows, err := s.db.Query(`
+		SELECT c.owner, c.chan, st.created, st.topic, st.topicSetBy, st.topicSetAt, st.key, st.lim, st.flags, st.flood, st.joinThrottle, st.forward
+		FROM channels c LEFT JOIN channel_state st ON st.chan = lower(c.chan)`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var chans []*channel.Channel
+	for rows.Next() {
+		var (
+			owner, name                string
+			created, topicSetAt, limit sql.NullInt64
+			topic, setBy, key, flags   sql.NullString
+			flood, throttle, forward   sql.NullString
+		)
+		err := rows.Scan(&owner, &name, &created, &topic, &setBy, &topicSetAt, &key, &limit, &flags, &flood, &throttle, &forward)
+		if err != nil {
+			return err
+		}
+		if !isValidChannelString(name) {
+			continue
+		}
+
+		ch := channel.New(name[1:], channel.ChanType(name[0]))
+		ch.Registered = true
+		ch.Founder = owner
+		if created.Valid {
+			ch.CreatedAt = time.Unix(created.Int64, 0)
+		}
+		if topic.String != "" {
+			ch.Topic = topic.String
+			ch.TopicSetBy = topicSetter(setBy.String)
+			ch.TopicSetAt = time.Unix(topicSetAt.Int64, 0)
+		}
+		ch.Key = key.String
+		if limit.Valid && limit.Int64 > 0 {
+			ch.Limit = int(limit.Int64)
+		}
+		setChannelFlags(ch, flags.String)
+		ch.Forward = forward.String
+		if flood.String != "" {
+			ch.ApplyMode(mode.Mode{Type: mode.Add, ModeChar: 'f', Param: flood.String})
+		}
+		if throttle.String != "" {
+			ch.ApplyMode(mode.Mode{Type: mode.Add, ModeChar: 'j', Param: throttle.String})
+		}
+		chans = append(chans, ch)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, ch := range chans {
+		lists, err := s.db.Query("SELECT mode, mask, setBy, setAt, expires FROM channel_lists WHERE chan = ?", strings.ToLower(ch.String()))
+		if err != nil {
+			return err
+		}
+		for lists.Next() {
+			var (
+				mode, mask     string
+				setBy          sql.NullString
+				setAt, expires sql.NullInt64
+			)
+			if err := lists.Scan(&mode, &mask, &setBy, &setAt, &expires); err != nil {
+				lists.Close()
+				return err
+			}
+			if len(mode) != 1 {
+				continue
+			}
+			e := channel.ListEntry{Mask: mask, SetBy: setBy.String, SetAt: time.Unix(setAt.Int64, 0)}
+			if expires.Int64 != 0 {
+				e.Expires = time.Unix(expires.Int64, 0)
+			}
+			ch.AddListEntry(mode[0], e)
+		}
+		lists.Close()
+
+		access, err := s.db.Query("SELECT mask, prefix FROM channel_access WHERE chan = ?", strings.ToLower(ch.String()))
+		if err != nil {
+			return err
+		}
+		for access.Next() {
+			var mask, sym string
+			if err := access.Scan(&mask, &sym); err != nil {
+				access.Close()
+				return err
+			}
+			if len(sym) != 1 {
+				continue
+			}
+			if p, ok := channel.MemberPrefix[sym[0]]; ok {
+				ch.SetAccess(mask, p)
+			}
+		}
+		access.Close()
+		s.setChannel(ch)
+
+		for char, list := range map[byte][]channel.ListEntry{'b': ch.List('b'), 'e': ch.List('e'), 'I': ch.List('I')} {
+			for _, e := range list {
+				s.scheduleExpiry(ch, char, e.Mask)
+			}
+		}
+	}
+	return nil
+}
+
+// channelFlags returns the letters of the modes of ch that don't take
+// a parameter.
+func channelFlags(ch *channel.Channel) string {
+	var flags string
+	for f, set := range map[string]bool{"i": ch.Invite, "m": ch.Moderated, "s": ch.Secret, "t": ch.Protected, "n": ch.NoExternal, "R": ch.RegisteredOnly, "M": ch.RegisteredModerated, "c": ch.NoColors, "S": ch.StripColors, "C": ch.NoCTCP, "K": ch.NoKnock, "F": ch.FreeTarget} {
+		if set {
+			flags += f
+		}
+	}
+	return flags
+}
+
+// setChannelFlags sets the modes of ch that don't take a parameter from
+// the letters in flags.
+func setChannelFlags(ch *channel.Channel, flags string) {
+	ch.Invite = strings.Contains(flags, "i")
+	ch.Moderated = strings.Contains(flags, "m")
+	ch.Secret = strings.Contains(flags, "s")
+	ch.Protected = strings.Contains(flags, "t")
+	ch.NoExternal = strings.Contains(flags, "n")
+	ch.RegisteredOnly = strings.Contains(flags, "R")
+	ch.RegisteredModerated = strings.Contains(flags, "M")
+	ch.NoColors = strings.Contains(flags, "c")
+	ch.StripColors = strings.Contains(flags, "S")
+	ch.NoCTCP = strings.Contains(flags, "C")
+	ch.NoKnock = strings.Contains(flags, "K")
+	ch.FreeTarget = strings.Contains(flags, "F")
+}
+
+// topicSetter makes a placeholder client out of the nickmask that set a
+// saved topic.
+func topicSetter(mask string) *client.Client {
+	c := &client.Client{Nick: mask}
+	if nick, userhost, ok := strings.Cut(mask, "!"); ok {
+		c.Nick = nick
+		c.User, c.Host, _ = strings.Cut(userhost, "@")
+	}
+	return c
+}
+
+// saveChannel writes the topic, modes, lists, and access list of ch to
+// the database.
+// Channels that are not registered are not saved.
+func (s *Server) saveChannel(ch *channel.Channel) error {
+	if !ch.Registered {
+		return nil
+	}
+
+	name := strings.ToLower(ch.String())
+	var setBy string
+	if ch.TopicSetBy != nil {
+		setBy = ch.TopicSetBy.String()
+	}
+	limit := 0
+	if ch.Limit != math.MaxInt {
+		limit = ch.Limit
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("INSERT OR REPLACE INTO channel_state VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		name, ch.CreatedAt.Unix(), ch.Topic, setBy, ch.TopicSetAt.Unix(), ch.Key, limit, channelFlags(ch), ch.FloodParam(), ch.JoinThrottleParam(), ch.Forward)
+	if err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec("DELETE FROM channel_lists WHERE chan = ?", name); err != nil {
+		return err
+	}
+	for mode, list := range map[string][]channel.ListEntry{"b": ch.List('b'), "e": ch.List('e'), "I": ch.List('I')} {
+		for _, e := range list {
+			var expires int64
+			if !e.Expires.IsZero() {
+				expires = e.Expires.Unix()
+			}
+			if _, err = tx.Exec("INSERT INTO channel_lists VALUES(?, ?, ?, ?, ?, ?)", name, mode, e.Mask, e.SetBy, e.SetAt.Unix(), expires); err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, err = tx.Exec("DELETE FROM channel_access WHERE chan = ?", name); err != nil {
+		return err
+	}
+	for mask, p := range ch.Access {
+		if _, err = tx.Exec("INSERT INTO channel_access VALUES(?, ?, ?)", name, mask, p.String()); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// channelChanged saves ch after its topic or modes have been changed.
+func (s *Server) channelChanged(ch *channel.Channel) {
+	if err := s.saveChannel(ch); err != nil {
+		s.logger.Error("could not save channel", "channel", ch.String(), "err", err)
+	}
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/mitchr/gossip/sasl/plain"
+)
+
+func TestRegisteredChannelPersistence(t *testing.T) {
+	t.Parallel()
+
+	conf := &Config{Name: "gossip", Port: ":0"}
+	conf.Cloak.Disabled = true
+	conf.Datasource = filepath.Join(t.TempDir(), "gossip.db")
+
+	s, err := New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve()
+
+	cred := plain.NewCredential("alice", "tanstaaftanstaaf")
+	s.persistPlain(cred.Username, "alice", cred.Pass)
+
+	c, r := s.connectAndAuthenticate("alice", "alice", "tanstaaftanstaaf")
+	c.Write([]byte("JOIN #test\r\nREGISTER #test\r\n"))
+	readLines(r, 5)
+	c.Write([]byte("TOPIC #test :hello\r\nMODE #test +k key\r\nMODE #test +b bob!*@*\r\nPART #test\r\n"))
+	readLines(r, 4)
+
+	ch, ok := s.getChannel("#test")
+	if !ok {
+		t.Fatal("registered channel was deleted when it became empty")
+	}
+	if ch.Len() != 0 {
+		t.Error("expected channel to be empty, has", ch.Len())
+	}
+	c.Close()
+	s.Close()
+
+	s, err = New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	go s.Serve()
+
+	ch, ok = s.getChannel("#test")
+	if !ok {
+		t.Fatal("registered channel was not loaded")
+	}
+	if !ch.Registered || ch.Founder != "alice" {
+		t.Error("expected channel registered by alice, got", ch.Registered, ch.Founder)
+	}
+	if ch.Topic != "hello" || ch.TopicSetBy.Nick != "alice" {
+		t.Error("topic was not restored:", ch.Topic, ch.TopicSetBy)
+	}
+	if ch.Key != "key" || len(ch.Ban) != 1 || ch.Ban[0].Mask != "bob!*@*" || ch.Ban[0].SetBy != "alice" {
+		t.Error("modes were not restored:", ch.Key, ch.Ban)
+	}
+
+	c, r = s.connectAndAuthenticate("alice", "alice", "tanstaaftanstaaf")
+	defer c.Close()
+	c.Write([]byte("JOIN #test key\r\n"))
+	readLines(r, 3)
+	names, _ := r.ReadBytes('\n')
+	assertResponse(names, prepMessage(RPL_NAMREPLY, s.Name, "alice", "=", "#test", "~alice").String(), t)
+}
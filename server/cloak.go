@@ -0,0 +1,56 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/mitchr/gossip/client"
+	"github.com/mitchr/gossip/scan/msg"
+)
+
+// cloakSecret returns the cloak secret that is stored in the database,
+// generating one the first time that it is needed.
+func (s *Server) cloakSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	// an existing secret is kept, so that cloaks don't change
+	_, err := s.db.Exec("INSERT OR IGNORE INTO secrets VALUES('cloak', ?)", hex.EncodeToString(b))
+	if err != nil {
+		return "", err
+	}
+
+	var secret string
+	err = s.db.QueryRow("SELECT value FROM secrets WHERE name = 'cloak'").Scan(&secret)
+	return secret, err
+}
+
+// updateHost sets the displayed host of c to its cloak if c is +x, or
+// to its real host otherwise. If the host changed, the change is sent
+// to other servers and true is returned.
+func (s *Server) updateHost(c *client.Client) bool {
+	host := c.RealHost
+	if c.Is(client.Cloaked) && s.cloak != nil {
+		host = s.cloak.Host(c.RealHost)
+	}
+	if host == c.Host {
+		return false
+	}
+
+	c.Host = host
+	if c.Is(client.Registered) {
+		s.propagate(nil, msg.New(nil, c.Nick, "", "", "CHGHOST", []string{host}, false))
+	}
+	return true
+}
+
+// :<nick> CHGHOST <host>
+func linkCHGHOST(s *Server, l *link, m *msg.Message) bool {
+	c, ok := s.getClient(m.Nick)
+	if !ok || len(m.Params) < 1 {
+		return false
+	}
+	c.Host = m.Params[0]
+	return true
+}
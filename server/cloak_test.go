@@ -0,0 +1,109 @@
+package server
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/mitchr/gossip/client"
+	"github.com/mitchr/gossip/cloak"
+)
+
+func TestCloak(t *testing.T) {
+	t.Parallel()
+
+	conf := &Config{Name: "gossip", Port: ":0"}
+	conf.Cloak.Secret = "secret"
+	s, err := New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	go s.Serve()
+
+	c, r := s.connectAndRegister("a")
+	defer c.Close()
+	hidden, _ := r.ReadBytes('\n')
+
+	a, _ := s.getClient("a")
+	cloaked := cloak.New("secret", "gossip").Host(a.RealHost)
+	assertResponse(hidden, prepMessage(RPL_HOSTHIDDEN, s.Name, "a", cloaked).String(), t)
+	if a.Host != cloaked || !a.Is(client.Cloaked) {
+		t.Fatal("expected host to be cloaked, got", a.Host)
+	}
+
+	t.Run("ChannelBan", func(t *testing.T) {
+		op, opR := s.connectAndRegister("op")
+		defer op.Close()
+		readLines(opR, 1)
+
+		op.Write([]byte("JOIN #chan\r\nMODE #chan +b *!*@" + a.RealHost + "\r\n"))
+		readLines(opR, 4)
+
+		c.Write([]byte("JOIN #chan\r\n"))
+		resp, _ := r.ReadBytes('\n')
+		assertResponse(resp, prepMessage(ERR_BANNEDFROMCHAN, s.Name, "a", "#chan").String(), t)
+	})
+
+	t.Run("Oper", func(t *testing.T) {
+		oper, operR := s.connectAndRegister("oper")
+		defer oper.Close()
+		readLines(operR, 1)
+		o, _ := s.getClient("oper")
+		o.SetMode(client.Op)
+
+		oper.Write([]byte("WHOIS a\r\n"))
+		resp, _ := readLines(operR, 3)
+		assertResponse(resp, prepMessage(RPL_WHOISHOST, s.Name, "oper", "a", a.RealHost).String(), t)
+	})
+
+	t.Run("Uncloak", func(t *testing.T) {
+		c.Write([]byte("MODE a -x\r\n"))
+		mode, _ := r.ReadBytes('\n')
+		hidden, _ := r.ReadBytes('\n')
+		assertResponse(mode, ":gossip MODE a -x\r\n", t)
+		assertResponse(hidden, prepMessage(RPL_HOSTHIDDEN, s.Name, "a", a.RealHost).String(), t)
+	})
+}
+
+func TestCloakDisabled(t *testing.T) {
+	t.Parallel()
+
+	s, err := New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	go s.Serve()
+
+	c, r := s.connectAndRegister("a")
+	defer c.Close()
+
+	c.Write([]byte("MODE a +x\r\n"))
+	resp, _ := r.ReadBytes('\n')
+	assertResponse(resp, prepMessage(ERR_UMODEUNKNOWNFLAG, s.Name, "a").String(), t)
+}
+
+func TestCloakByDefault(t *testing.T) {
+	t.Parallel()
+
+	conf := &Config{Name: "gossip", Port: ":0", Datasource: filepath.Join(t.TempDir(), "gossip.db")}
+	s, err := New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.cloak == nil {
+		t.Fatal("expected cloaking to be on by default")
+	}
+	cloaked := s.cloak.Host("192.0.2.1")
+	s.Close()
+
+	// the secret is kept in the database
+	s, err = New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if s.cloak.Host("192.0.2.1") != cloaked {
+		t.Error("expected cloaks to stay the same after a restart")
+	}
+}
@@ -14,9 +14,9 @@ import (
 )
 
 type Config struct {
-	// reference to underlying source of config data; used for rehashing
-	configSource io.Reader
-	Debug        bool `json:"-"`
+	// the file that this config was read from; REHASH reads it again
+	path  string
+	Debug bool `json:"-"`
 
 	// The name of the network associated with the server
 	Network string `json:"network"`
@@ -27,10 +27,7 @@ type Config struct {
 
 	Port string `json:"port"`
 
-	Proxy struct {
-		Enabled   bool     `json:"enabled,omitempty"`
-		Whitelist []string `json:"whitelist,omitempty"`
-	} `json:"proxy,omitempty"`
+	Proxy Proxy `json:"proxy,omitempty"`
 
 	// Location of sqlite db. If nil, assume :memory:
 	Datasource string `json:"datasource"`
@@ -46,10 +43,7 @@ type Config struct {
 		// A path to the server's private key
 		Privkey string `json:"privkey"`
 
-		Proxy struct {
-			Enabled   bool     `json:"enabled,omitempty"`
-			Whitelist []string `json:"whitelist,omitempty"`
-		} `json:"proxy,omitempty"`
+		Proxy Proxy `json:"proxy,omitempty"`
 
 		STS struct {
 			// Enables strict transport security, which provides opportunistic
@@ -75,6 +69,20 @@ type Config struct {
 		} `json:"sts,omitempty"`
 	} `json:"tls,omitempty"`
 
+	WebSocket struct {
+		// The port that browsers connect to using the IRCv3 WebSocket
+		// transport. If empty, websockets are not accepted.
+		Port string `json:"port"`
+
+		// Serve websockets over TLS, using the certificate of the TLS
+		// listener
+		TLS bool `json:"tls"`
+
+		// Origins that browsers may connect from. If empty, any origin is
+		// allowed.
+		Origins []string `json:"origins,omitempty"`
+	} `json:"websocket,omitempty"`
+
 	// A path to a file containg the server's message of the day. A MOTD
 	// is divided when encountering a newline. If a line is too long, it
 	// may run over the 512 byte message limit.
@@ -83,13 +91,177 @@ type Config struct {
 
 	// A map where operator names are the keys and pass is the value
 	Ops map[string][]byte `json:"ops,omitempty"`
+
+	// Operator classes by name. An operator is given the class that
+	// OpClass maps its name to; operators without a class have every
+	// privilege.
+	OperClasses map[string]OperClass `json:"operClasses,omitempty"`
+	OpClass     map[string]string    `json:"opClass,omitempty"`
+
+	Cloak struct {
+		// Hosts are cloaked by default, and users can reveal their own
+		// with MODE -x. This turns cloaking off for everyone.
+		Disabled bool `json:"disabled"`
+
+		// The key used to generate cloaked hosts. If empty, a random
+		// secret is generated and stored in the database, so that cloaks
+		// stay the same across restarts.
+		Secret string `json:"secret"`
+
+		// Prepended to cloaked hostnames. This defaults to the network
+		// name.
+		Prefix string `json:"prefix"`
+	} `json:"cloak,omitempty"`
+
+	Links struct {
+		// The port that other servers connect to in order to link with
+		// this server. If empty, incoming links are not accepted.
+		Port string `json:"port"`
+
+		// Use TLS for links. Incoming links use the same certificate as
+		// the client TLS listener.
+		TLS bool `json:"tls"`
+
+		// Servers that this server is allowed to link with
+		Peers []LinkPeer `json:"peers"`
+	} `json:"links,omitempty"`
+
+	AccountRegistration struct {
+		// Allow REGISTER and VERIFY before connection registration has
+		// completed
+		BeforeConnect bool `json:"beforeConnect"`
+
+		// Require an email address when registering, which a verification
+		// code is sent to
+		EmailRequired bool `json:"emailRequired"`
+
+		// The shortest password that can be used. This defaults to 8.
+		MinPasswordLength int `json:"minPasswordLength"`
+
+		// Verification codes are sent with Sender. If it is nil, they are
+		// emailed through the SMTP server at SMTP.Addr instead.
+		Sender VerificationSender `json:"-"`
+		SMTP   struct {
+			Addr string `json:"addr"`
+			From string `json:"from"`
+		} `json:"smtp,omitempty"`
+	} `json:"accountRegistration,omitempty"`
+
+	OAuth struct {
+		// Paths to JWKS documents or PEM encoded public keys that are
+		// trusted to sign bearer tokens. If empty, OAUTHBEARER is disabled.
+		Keys []string `json:"keys"`
+
+		// If set, tokens must have been issued by Issuer for Audience
+		Issuer   string `json:"issuer"`
+		Audience string `json:"audience"`
+
+		// The claim that holds the account name. This defaults to sub.
+		AccountClaim string `json:"accountClaim"`
+	} `json:"oauth,omitempty"`
+
+	Admin struct {
+		// The address that the HTTP admin API listens on. If no host is
+		// given, like ":8080", it only listens on localhost. If empty, the
+		// API is disabled.
+		Addr string `json:"addr"`
+
+		// Requests to the API must carry this bearer token
+		Token string `json:"token"`
+	} `json:"admin,omitempty"`
+
+	Log struct {
+		// Either "text" or "json". Defaults to text.
+		Format string `json:"format"`
+
+		// The minimum level that is logged: debug, info, warn, or error.
+		// Defaults to info, or debug if the server was started with -d.
+		Level string `json:"level"`
+
+		// Logs are written to this file instead of stderr. Once the file
+		// grows past MaxSize megabytes, it is moved aside and at most
+		// MaxBackups old files are kept. If MaxSize is 0, the file is
+		// never rotated.
+		File       string `json:"file"`
+		MaxSize    int    `json:"maxSize"`
+		MaxBackups int    `json:"maxBackups"`
+	} `json:"log,omitempty"`
+
+	Metrics struct {
+		// The address that Prometheus metrics are served on, at /metrics.
+		// If no host is given, it only listens on localhost. If empty,
+		// metrics are not served.
+		Addr string `json:"addr"`
+	} `json:"metrics,omitempty"`
+
+	History struct {
+		// Messages are deleted from the history once they are this many
+		// days old. This defaults to 30; if negative, messages are kept
+		// forever.
+		Days int `json:"days"`
+	} `json:"history,omitempty"`
+
+	Bouncer struct {
+		// Allows multiple connections that are authenticated to the same
+		// account to share one nick and set of channels
+		Enabled bool `json:"enabled"`
+
+		// Keeps an account's nick, channels, and away state after its
+		// last connection is closed
+		AlwaysOn bool `json:"alwaysOn"`
+	} `json:"bouncer,omitempty"`
+}
+
+// Proxy configures a listener that sits behind a proxy which speaks the
+// PROXY protocol.
+type Proxy struct {
+	Enabled bool `json:"enabled,omitempty"`
+
+	// If set, only these addresses may send a PROXY header
+	Whitelist []string `json:"whitelist,omitempty"`
+}
+
+type LinkPeer struct {
+	// The name that the peer uses for itself
+	Name string `json:"name"`
+
+	// Address used to CONNECT to the peer
+	Addr string `json:"addr"`
+
+	// A password that both servers must agree on
+	Password string `json:"password"`
+
+	// Connect to this peer when the server starts
+	AutoConnect bool `json:"autoconnect"`
+
+	// If set, the sha256 fingerprint of the peer's TLS certificate. This
+	// is checked instead of verifying the certificate chain.
+	Fingerprint string `json:"fingerprint,omitempty"`
+}
+
+// An OperClass is a set of privileges, along with the requirements that
+// a client has to meet in order to OPER up with it.
+type OperClass struct {
+	// One or more of kill, ban, rehash, wallops, routing, override, and
+	// see-hidden.
+	Privileges []string `json:"privileges"`
+
+	// If set, the user@host of the client must match one of these masks
+	Hosts []string `json:"hosts,omitempty"`
+
+	// Require the client to be connected over TLS
+	RequireTLS bool `json:"requireTLS,omitempty"`
+
+	// If set, the client must present a TLS certificate with one of these
+	// sha256 fingerprints
+	Fingerprints []string `json:"fingerprints,omitempty"`
 }
 
 // Unmarshal's the server's config file
 func loadConfig(r io.Reader) (*Config, error) {
 	decoder := json.NewDecoder(r)
 
-	c := &Config{configSource: r, Datasource: ":memory:"}
+	c := &Config{Datasource: ":memory:"}
 	err := decoder.Decode(c)
 	if err != nil {
 		return nil, err
@@ -104,7 +276,8 @@ func loadConfig(r io.Reader) (*Config, error) {
 	return c, nil
 }
 
-// NewConfig reads the file at path into a Config.
+// NewConfig decodes a Config from r, and loads the files that it
+// refers to.
 func NewConfig(r io.Reader) (*Config, error) {
 	c, err := loadConfig(r)
 	if err != nil {
@@ -141,6 +314,43 @@ func NewConfig(r io.Reader) (*Config, error) {
 	return c, nil
 }
 
+// ReadConfig reads the config file at path. The file is read again
+// when the server is rehashed.
+func ReadConfig(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	c, err := NewConfig(f)
+	if err != nil {
+		return nil, err
+	}
+	c.path = path
+	return c, nil
+}
+
+// validate checks the parts of c that depend on each other.
+func (c *Config) validate() error {
+	if err := c.validateOperClasses(); err != nil {
+		return err
+	}
+	if c.WebSocket.TLS && c.TLS.Config == nil {
+		return errors.New("websocket tls requires tls to be enabled")
+	}
+	if c.Links.TLS && c.TLS.Config == nil {
+		return errors.New("link tls requires tls to be enabled")
+	}
+	if c.Admin.Addr != "" && c.Admin.Token == "" {
+		return errors.New("the admin api requires a token")
+	}
+	if c.AccountRegistration.EmailRequired && c.AccountRegistration.Sender == nil && c.AccountRegistration.SMTP.Addr == "" {
+		return ErrNoVerificationPath
+	}
+	return nil
+}
+
 func WriteConfigToPath(c *Config, path string) error {
 	file, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC, 0644)
 	if err != nil {
@@ -3,8 +3,6 @@ package server
 import (
 	"errors"
 	"iter"
-	"net"
-	"os"
 	"slices"
 	"sort"
 	"strconv"
@@ -14,6 +12,7 @@ import (
 	cap "github.com/mitchr/gossip/capability"
 	"github.com/mitchr/gossip/channel"
 	"github.com/mitchr/gossip/client"
+	"github.com/mitchr/gossip/scan/format"
 	"github.com/mitchr/gossip/scan/mode"
 	"github.com/mitchr/gossip/scan/msg"
 	"github.com/mitchr/gossip/scan/wild"
@@ -32,6 +31,7 @@ var commands = map[string]executor{
 	"CAP":          CAP,
 	"AUTHENTICATE": AUTHENTICATE,
 	"REGISTER":     REGISTER,
+	"VERIFY":       VERIFY,
 	"SETNAME":      SETNAME,
 
 	// chanOps
@@ -41,7 +41,9 @@ var commands = map[string]executor{
 	"NAMES":  NAMES,
 	"LIST":   LIST,
 	"INVITE": INVITE,
+	"KNOCK":  KNOCK,
 	"KICK":   KICK,
+	"ACCESS": ACCESS,
 
 	// server queries
 	"MOTD":   MOTD,
@@ -60,16 +62,32 @@ var commands = map[string]executor{
 	"NOTICE":  NOTICE,
 	"TAGMSG":  TAGMSG,
 
+	"CHATHISTORY": CHATHISTORY,
+
+	// server links
+	"CONNECT": CONNECT,
+	"SQUIT":   SQUIT,
+	"LINKS":   LINKS,
+
 	// miscellaneous
 	"PING":    PING,
 	"PONG":    PONG,
 	"WALLOPS": WALLOPS,
+	"KILL":    KILL,
+	"STATS":   STATS,
 	"ERROR":   ERROR,
 
 	"AWAY":     AWAY,
 	"REHASH":   REHASH,
 	"USERHOST": USERHOST,
 	"MONITOR":  MONITOR,
+
+	"KLINE":   addBanCommand(kline),
+	"DLINE":   addBanCommand(dline),
+	"GLINE":   addBanCommand(gline),
+	"UNKLINE": removeBanCommand(kline),
+	"UNDLINE": removeBanCommand(dline),
+	"UNGLINE": removeBanCommand(gline),
 }
 
 func PASS(s *Server, c *client.Client, m *msg.Message) msg.Msg {
@@ -124,6 +142,9 @@ func NICK(s *Server, c *client.Client, m *msg.Message) msg.Msg {
 				member.WriteMessage(msg.New(nil, c.String(), "", "", "NICK", []string{nick}, false))
 			}
 
+			// counted once the member map has been updated
+			defer s.checkFlood(v, c, channel.FloodNick)
+
 			// update member map entry
 			defer func(v *channel.Channel, oldNick string) {
 				m, _ := v.GetMember(oldNick)
@@ -137,11 +158,17 @@ func NICK(s *Server, c *client.Client, m *msg.Message) msg.Msg {
 		}
 
 		previousNuh := c.String()
+		previousNick := c.Nick
 		// update client map entry
 		s.deleteClient(c.Nick)
-		c.Nick = nick
+		c.SetNick(nick)
 		s.setClient(c)
 
+		if c.Is(client.Registered) {
+			s.propagate(nil, msg.New(nil, previousNick, "", "", "NICK", []string{nick}, false))
+			s.snotice(client.SnoNick, "Nick change: "+previousNick+" -> "+nick)
+		}
+
 		if !changingCase {
 			s.notify(c, prepMessage(RPL_MONONLINE, s.Name, "*", c.Id()), cap.None)
 		}
@@ -206,10 +233,20 @@ func OPER(s *Server, c *client.Client, m *msg.Message) msg.Msg {
 
 	// will fail if username doesn't exist or if pass is incorrect
 	if bcrypt.CompareHashAndPassword(s.Ops[name], []byte(pass)) != nil {
+		s.snotice(client.SnoOper, "Failed OPER attempt as "+name+" by "+describe(c)+": bad password")
 		return prepMessage(ERR_PASSWDMISMATCH, s.Name, c.Id())
 	}
 
+	className := s.OpClass[name]
+	if class, ok := s.OperClasses[className]; className != "" && (!ok || !canOper(c, class)) {
+		s.snotice(client.SnoOper, "Failed OPER attempt as "+name+" by "+describe(c)+": requirements of class "+className+" not met")
+		return prepMessage(ERR_NOOPERHOST, s.Name, c.Id())
+	}
+
+	c.OperClass = className
 	c.SetMode(client.Op)
+	s.snotice(client.SnoOper, describe(c)+" is now an operator as "+name)
+	s.propagate(nil, umode(c))
 
 	return msg.Buffer{
 		prepMessage(RPL_YOUREOPER, s.Name, c.Id()),
@@ -229,6 +266,26 @@ func QUIT(s *Server, c *client.Client, m *msg.Message) msg.Msg {
 		return nil
 	}
 
+	if s.detachSession(c, reason) {
+		return nil
+	}
+
+	// c is the last connection of the client that it is a session of,
+	// which leaves along with it
+	if identity := c.Identity(); identity != c {
+		s.quit(identity, reason, nil)
+		c.Close()
+		return nil
+	}
+
+	s.quit(c, reason, nil)
+	return nil
+}
+
+// quit removes a registered client from the server and every channel
+// it belongs to. The QUIT is sent to every linked server except from.
+func (s *Server) quit(c *client.Client, reason string, from *link) {
+	s.snotice(client.SnoExit, "Client exiting: "+describe(c)+" ["+reason+"]")
 	s.whowasHistory.push(c.Nick, c.User, c.Host, c.Realname)
 	s.notify(c, prepMessage(RPL_MONOFFLINE, s.Name, "*", c.Id()), cap.None)
 
@@ -249,8 +306,8 @@ func QUIT(s *Server, c *client.Client, m *msg.Message) msg.Msg {
 		}
 	}
 
+	s.propagate(from, msg.New(nil, c.Nick, "", "", "QUIT", []string{reason}, true))
 	s.ERROR(c, reason)
-	return nil
 }
 
 func (s *Server) endRegistration(c *client.Client) msg.Msg {
@@ -261,6 +318,19 @@ func (s *Server) endRegistration(c *client.Client) msg.Msg {
 		return nil
 	}
 
+	// checked before anything else, so that logging in to an account
+	// that is already online can't get around a ban
+	if b, banned := s.bannedClient(c); banned {
+		c.WriteMessage(prepMessage(ERR_YOUREBANNEDCREEP, s.Name, c.Id(), b.reason))
+		QUIT(s, c, &msg.Message{Params: []string{"Closing Link: Banned: " + b.reason}})
+		return nil
+	}
+
+	// another connection is already using this account
+	if identity := s.identityOf(c); identity != nil && (s.Password == nil || c.ServerPassAccepted) {
+		return s.attachSession(identity, c)
+	}
+
 	// client tried to finish registration with the nick of an already registered account
 	if authn := s.userAccountForNickExists(c.Nick); authn != "" && c.SASLMech.Authn() != authn {
 		return prepMessage(ERR_NICKNAMEINUSE, s.Name, c.Id(), c.Nick)
@@ -280,18 +350,33 @@ func (s *Server) endRegistration(c *client.Client) msg.Msg {
 		return nil
 	}
 
+	if s.cloak != nil {
+		c.SetMode(client.Cloaked)
+		s.updateHost(c)
+	}
+
 	c.SetMode(client.Registered)
 	s.setClient(c)
 	s.unknowns.Dec()
 	s.max.KeepMax(uint64(s.clientLen()))
 
+	buff := s.welcome(c)
+	s.notify(c, prepMessage(RPL_MONONLINE, s.Name, "*", c), cap.None)
+	s.snotice(client.SnoConnect, "Client connecting: "+describe(c)+" ["+c.RemoteAddr().String()+"]")
+	s.propagate(nil, s.uid(c))
+	return buff
+}
+
+// welcome constructs the burst of replies that a client receives once
+// it has registered.
+func (s *Server) welcome(c *client.Client) msg.Buffer {
 	buff := msg.Buffer{
 		// send RPL_WELCOME and friends in acceptance
 		prepMessage(RPL_WELCOME, s.Name, c.Id(), s.Network, c),
 		prepMessage(RPL_YOURHOST, s.Name, c.Id(), s.Name),
 		prepMessage(RPL_CREATED, s.Name, c.Id(), s.created),
 		// serverName, version, userModes, chanModes
-		prepMessage(RPL_MYINFO, s.Name, c.Id(), s.Name, "0", "ioOrw", "beliIkmstn"),
+		prepMessage(RPL_MYINFO, s.Name, c.Id(), s.Name, "0", "ioOrswxR", "bcefFijlLIkKmMRSstnC"),
 	}
 
 	for _, support := range isupportTokens {
@@ -300,11 +385,12 @@ func (s *Server) endRegistration(c *client.Client) msg.Msg {
 
 	buff.AddMsg(LUSERS(s, c, nil))
 	buff.AddMsg(MOTD(s, c, nil))
+	if c.Is(client.Cloaked) {
+		buff.AddMsg(prepMessage(RPL_HOSTHIDDEN, s.Name, c.Id(), c.Host))
+	}
 
 	// after registration burst, give clients max grants
 	c.FillGrants()
-
-	s.notify(c, prepMessage(RPL_MONONLINE, s.Name, "*", c), cap.None)
 	return buff
 }
 
@@ -334,11 +420,17 @@ func SETNAME(s *Server, c *client.Client, m *msg.Message) msg.Msg {
 
 	resp := msg.New(nil, c.String(), "", "", "SETNAME", []string{c.Realname}, true)
 	s.notify(c, resp, cap.Setname)
+	s.propagate(nil, msg.New(nil, c.Nick, "", "", "SETNAME", []string{c.Realname}, true))
 
 	return resp
 }
 
-func JOIN(s *Server, c *client.Client, m *msg.Message) msg.Msg {
+func JOIN(s *Server, c *client.Client, m *msg.Message) msg.Msg { return s.join(c, m, true) }
+
+// join is JOIN, but only follows the +L of channels that c cannot join
+// if canForward is true. Forwarded joins cannot be forwarded again, so
+// that channels cannot forward in a loop.
+func (s *Server) join(c *client.Client, m *msg.Message, canForward bool) msg.Msg {
 	if len(m.Params) < 1 {
 		return prepMessage(ERR_NEEDMOREPARAMS, s.Name, c.Id(), "JOIN")
 	}
@@ -366,6 +458,16 @@ func JOIN(s *Server, c *client.Client, m *msg.Message) msg.Msg {
 	chanExists:
 		if ch, ok := s.getChannel(chans[i]); ok { // channel already exists
 			err := ch.Admit(c, keys[i])
+			if canForward && (err == channel.ErrLimitReached || err == channel.ErrNotInvited || err == channel.ErrBanned) {
+				// the target may have been deleted since +L was set
+				if target, ok := s.getChannel(ch.Forward); ok {
+					buff.AddMsg(prepMessage(ERR_LINKCHANNEL, s.Name, c.Id(), ch, target))
+					if _, belongs := target.GetMember(c.Nick); !belongs {
+						buff.AddMsg(s.join(c, &msg.Message{Params: []string{target.String()}}, false))
+					}
+					continue
+				}
+			}
 			if err != nil {
 				if err == channel.ErrKeyMissing {
 					buff.AddMsg(prepMessage(ERR_BADCHANNELKEY, s.Name, c.Id(), ch))
@@ -375,10 +477,16 @@ func JOIN(s *Server, c *client.Client, m *msg.Message) msg.Msg {
 					buff.AddMsg(prepMessage(ERR_INVITEONLYCHAN, s.Name, c.Id(), ch))
 				} else if err == channel.ErrBanned { // client is banned
 					buff.AddMsg(prepMessage(ERR_BANNEDFROMCHAN, s.Name, c.Id(), ch))
+				} else if err == channel.ErrThrottled { // too many clients joined recently
+					buff.AddMsg(prepMessage(ERR_THROTTLE, s.Name, c.Id(), ch))
+				} else if err == channel.ErrNeedAccount { // channel is +R
+					buff.AddMsg(prepMessage(ERR_NEEDREGGEDNICK, s.Name, c.Id(), ch))
 				}
 				return buff
 			}
 
+			s.propagateChan(ch, msg.New(nil, c.Nick, "", "", "JOIN", []string{ch.String()}, false))
+
 			// send JOIN to all participants of channel
 			joinMsgParams := []string{ch.String(), c.SASLMech.Authn(), c.Realname}
 			for member := range ch.AllExcept(c) {
@@ -389,6 +497,8 @@ func JOIN(s *Server, c *client.Client, m *msg.Message) msg.Msg {
 				}
 			}
 
+			grant := s.grantAccess(ch, c)
+
 			if c.Caps[cap.ExtendedJoin.Name] {
 				buff.AddMsg(msg.New(nil, c.Nick, c.User, c.Host, "JOIN", joinMsgParams, false))
 			} else {
@@ -400,7 +510,14 @@ func JOIN(s *Server, c *client.Client, m *msg.Message) msg.Msg {
 				buff.AddMsg(TOPIC(s, c, &msg.Message{Params: []string{ch.String()}}))
 			}
 			buff.AddMsg(NAMES(s, c, &msg.Message{Params: []string{ch.String()}}))
+			if grant != nil {
+				buff.AddMsg(grant)
+			}
 			s.awayNotify(c, ch)
+
+			// only joins that were accepted are counted, so that clients who
+			// can't join can't push ch into +i
+			s.checkFlood(ch, c, channel.FloodJoin)
 		} else { // create new channel
 			s.joinLock.Lock()
 			// two clients tried to create this channel at the same time, and
@@ -428,6 +545,7 @@ func JOIN(s *Server, c *client.Client, m *msg.Message) msg.Msg {
 
 			buff.AddMsg(NAMES(s, c, &msg.Message{Params: []string{newChan.String()}}))
 			s.joinLock.Unlock()
+			s.propagateChan(newChan, sjoin(newChan))
 		}
 	}
 	return buff
@@ -471,6 +589,7 @@ func PART(s *Server, c *client.Client, m *msg.Message) msg.Msg {
 
 		params[0] = ch.String()
 		ch.WriteMessage(msg.New(nil, c.String(), "", "", "PART", params, len(params) > 1))
+		s.propagateChan(ch, msg.New(nil, c.Nick, "", "", "PART", params[:1], false))
 
 		if ch.Len() == 1 {
 			s.deleteChannel(ch.String())
@@ -493,13 +612,15 @@ func TOPIC(s *Server, c *client.Client, m *msg.Message) msg.Msg {
 	}
 
 	if len(m.Params) >= 2 { // modify topic
-		if m, _ := ch.GetMember(c.Nick); ch.Protected && !m.Is(channel.Operator) {
+		if m, _ := ch.GetMember(c.Nick); ch.Protected && !m.Is(channel.Operator) && !s.hasPrivilege(c, privOverride) {
 			return prepMessage(ERR_CHANOPRIVSNEEDED, s.Name, c.Id(), ch)
 		}
 		ch.Topic = m.Params[1]
 		ch.TopicSetBy = c
 		ch.TopicSetAt = time.Now()
 		ch.WriteMessage(msg.New(nil, s.Name, "", "", "TOPIC", []string{ch.String(), ch.Topic}, true))
+		s.propagateChan(ch, msg.New(nil, c.Nick, c.User, c.Host, "TOPIC", []string{ch.String(), strconv.FormatInt(ch.TopicSetAt.Unix(), 10), ch.Topic}, true))
+		s.channelChanged(ch)
 		return nil
 	} else {
 		if ch.Topic == "" {
@@ -542,6 +663,7 @@ func INVITE(s *Server, c *client.Client, m *msg.Message) msg.Msg {
 	}
 
 	ch.Invited = append(ch.Invited, nick)
+	s.propagateChan(ch, msg.New(nil, c.Nick, "", "", "INVITE", []string{nick, ch.String()}, false))
 
 	recipient.WriteMessageFrom(msg.New(nil, sender.Nick, sender.User, sender.Host, "INVITE", []string{nick, ch.String()}, false), c)
 	for member := range ch.All() {
@@ -553,6 +675,46 @@ func INVITE(s *Server, c *client.Client, m *msg.Message) msg.Msg {
 	return prepMessage(RPL_INVITING, s.Name, c.Id(), nick, ch)
 }
 
+// KNOCK <channel> [<reason>]
+//
+// Asks the operators of an invite-only channel for an INVITE.
+func KNOCK(s *Server, c *client.Client, m *msg.Message) msg.Msg {
+	if len(m.Params) < 1 {
+		return prepMessage(ERR_NEEDMOREPARAMS, s.Name, c.Id(), "KNOCK")
+	}
+
+	ch, ok := s.getChannel(m.Params[0])
+	if !ok {
+		return prepMessage(ERR_NOSUCHCHANNEL, s.Name, c.Id(), m.Params[0])
+	}
+	if _, ok := ch.GetMember(c.Nick); ok {
+		return prepMessage(ERR_KNOCKONCHAN, s.Name, c.Id(), ch)
+	}
+	if !ch.Invite {
+		return prepMessage(ERR_CHANOPEN, s.Name, c.Id(), ch)
+	}
+	if ch.NoKnock {
+		return prepMessage(ERR_CANNOTKNOCK, s.Name, c.Id(), ch)
+	}
+	if ch.Banned(c) {
+		return prepMessage(ERR_BANNEDFROMCHAN, s.Name, c.Id(), ch)
+	}
+	if !ch.Knock(c.Nick) {
+		return prepMessage(ERR_TOOMANYKNOCK, s.Name, c.Id(), ch)
+	}
+
+	var reason string
+	if len(m.Params) > 1 && m.Params[1] != "" {
+		reason = " (" + m.Params[1] + ")"
+	}
+	for member := range ch.All() {
+		if member.Is(channel.Operator) {
+			member.WriteMessage(prepMessage(RPL_KNOCK, s.Name, member.Id(), ch, c, reason))
+		}
+	}
+	return prepMessage(RPL_KNOCKDLVR, s.Name, c.Id(), ch)
+}
+
 // if c belongs to the channel associated with chanName, return that
 // channel. If it doesn't, or if the channel doesn't exist, write a
 // numeric reply to the client and return nil.
@@ -604,7 +766,7 @@ func KICK(s *Server, c *client.Client, m *msg.Message) msg.Msg {
 		self, _ := ch.GetMember(c.Nick)
 		if self == nil {
 			return prepMessage(ERR_NOTONCHANNEL, s.Name, c.Id(), ch)
-		} else if !self.Is(channel.Operator) {
+		} else if !self.Is(channel.Operator) && !s.hasPrivilege(c, privOverride) {
 			return prepMessage(ERR_CHANOPRIVSNEEDED, s.Name, c.Id(), ch)
 		}
 
@@ -629,6 +791,7 @@ func (s *Server) kickMember(c *client.Client, ch *channel.Channel, memberNick st
 	}
 
 	ch.DeleteMember(u.Nick)
+	s.propagateChan(ch, msg.New(nil, c.Nick, "", "", "KICK", []string{ch.String(), u.Nick, comment}, true))
 	return nil
 }
 
@@ -813,9 +976,13 @@ func LUSERS(s *Server, c *client.Client, m *msg.Message) msg.Msg {
 	invis := 0
 	ops := 0
 	clientSize := s.clientLen()
+	localSize := 0
 	max := s.max.Get()
 
 	for _, v := range s.clients.All() {
+		if v.Server == "" {
+			localSize++
+		}
 		if v.Is(client.Invisible) {
 			invis++
 			continue
@@ -826,12 +993,12 @@ func LUSERS(s *Server, c *client.Client, m *msg.Message) msg.Msg {
 	}
 
 	return msg.Buffer{
-		prepMessage(RPL_LUSERCLIENT, s.Name, c.Id(), clientSize, invis, 1),
+		prepMessage(RPL_LUSERCLIENT, s.Name, c.Id(), clientSize, invis, s.serverCount()),
 		prepMessage(RPL_LUSEROP, s.Name, c.Id(), ops),
 		prepMessage(RPL_LUSERUNKNOWN, s.Name, c.Id(), s.unknowns.Get()),
 		prepMessage(RPL_LUSERCHANNELS, s.Name, c.Id(), s.channelLen()),
-		prepMessage(RPL_LUSERME, s.Name, c.Id(), clientSize, 1),
-		prepMessage(RPL_LOCALUSERS, s.Name, c.Id(), clientSize, max, clientSize, max),
+		prepMessage(RPL_LUSERME, s.Name, c.Id(), localSize, s.serverCount()),
+		prepMessage(RPL_LOCALUSERS, s.Name, c.Id(), localSize, max, localSize, max),
 		prepMessage(RPL_GLOBALUSERS, s.Name, c.Id(), clientSize, max, clientSize, max),
 	}
 }
@@ -848,28 +1015,62 @@ func MODE(s *Server, c *client.Client, m *msg.Message) msg.Msg {
 
 	target := m.Params[0]
 	if !isValidChannelString(target) {
-		client, ok := s.getClient(target)
+		v, ok := s.getClient(target)
 		if !ok {
 			return prepMessage(ERR_NOSUCHNICK, s.Name, c.Id(), target)
 		}
-		if client.Nick != c.Nick { // can't modify another user
+		if v.Nick != c.Nick { // can't modify another user
 			return prepMessage(ERR_USERSDONTMATCH, s.Name, c.Id())
 		}
 
-		if len(m.Params) == 2 { // modify own mode
+		if len(m.Params) >= 2 { // modify own mode
 			var buff msg.Buffer
 			appliedModes := []mode.Mode{}
+			snomaskChanged := false
+			hadNotices := c.Is(client.ServerNotice)
 			for _, v := range mode.Parse([]byte(m.Params[1])) {
-				found := c.ApplyMode(v)
+				// +x does nothing unless cloaking is enabled
+				found := (v.ModeChar != 'x' || s.cloak != nil) && c.ApplyMode(v)
 				if !found {
 					buff.AddMsg(prepMessage(ERR_UMODEUNKNOWNFLAG, s.Name, c.Id()))
 				} else {
 					appliedModes = append(appliedModes, v)
+					snomaskChanged = snomaskChanged || v.ModeChar == 's'
+				}
+			}
+
+			// MODE <nick> +s <snomask>
+			if len(m.Params) > 2 && c.Is(client.ServerNotice) {
+				c.ApplySnomask(mode.Parse([]byte(m.Params[2])))
+				snomaskChanged = true
+			} else if c.Is(client.ServerNotice) && c.Snomask() == 0 {
+				c.SetSnomask(client.AllSnomasks)
+			}
+			// server notices are only for operators, so a client that gave
+			// up op (e.g. MODE nick +s-o) doesn't get to keep +s either
+			if !c.Is(client.Op) && c.Is(client.ServerNotice) {
+				c.ApplyMode(mode.Mode{ModeChar: 's', Type: mode.Remove})
+				kept := appliedModes[:0]
+				for _, v := range appliedModes {
+					if v.ModeChar != 's' {
+						kept = append(kept, v)
+					}
+				}
+				appliedModes = kept
+				if hadNotices {
+					appliedModes = append(appliedModes, mode.Mode{ModeChar: 's', Type: mode.Remove})
 				}
 			}
 
 			modeStr := buildModestr(appliedModes)
 			buff.AddMsg(msg.New(nil, s.Name, "", "", "MODE", []string{c.Nick, modeStr}, false))
+			if snomaskChanged && c.Is(client.ServerNotice) {
+				buff.AddMsg(prepMessage(RPL_SNOMASK, s.Name, c.Id(), c.Snomask()))
+			}
+			s.propagate(nil, umode(c))
+			if s.updateHost(c) {
+				buff.AddMsg(prepMessage(RPL_HOSTHIDDEN, s.Name, c.Id(), c.Host))
+			}
 			return buff
 		} else { // give back own mode
 			return prepMessage(RPL_UMODEIS, s.Name, c.Id(), c.Mode)
@@ -891,7 +1092,7 @@ func MODE(s *Server, c *client.Client, m *msg.Message) msg.Msg {
 				prepMessage(RPL_CREATIONTIME, s.Name, c.Id(), ch, ch.CreatedAt),
 			}
 		} else { // modeStr given
-			if self, belongs := ch.GetMember(c.Id()); !belongs || !self.Is(channel.Operator) {
+			if self, belongs := ch.GetMember(c.Id()); (!belongs || !self.Is(channel.Operator)) && !s.hasPrivilege(c, privOverride) {
 				return prepMessage(ERR_CHANOPRIVSNEEDED, s.Name, c.Id(), ch)
 			}
 
@@ -903,15 +1104,21 @@ func MODE(s *Server, c *client.Client, m *msg.Message) msg.Msg {
 				if m.Type == mode.List {
 					switch m.ModeChar {
 					case 'b':
-						buff.AddMsg(s.sendChannelModeList(c, ch, ch.Ban, RPL_BANLIST, RPL_ENDOFBANLIST))
+						buff.AddMsg(s.sendChannelModeList(c, ch, ch.List('b'), RPL_BANLIST, RPL_ENDOFBANLIST))
 					case 'e':
-						buff.AddMsg(s.sendChannelModeList(c, ch, ch.BanExcept, RPL_EXCEPTLIST, RPL_ENDOFEXCEPTLIST))
+						buff.AddMsg(s.sendChannelModeList(c, ch, ch.List('e'), RPL_EXCEPTLIST, RPL_ENDOFEXCEPTLIST))
 					case 'I':
-						buff.AddMsg(s.sendChannelModeList(c, ch, ch.InviteExcept, RPL_INVEXLIST, RPL_ENFOFINVEXLIST))
+						buff.AddMsg(s.sendChannelModeList(c, ch, ch.List('I'), RPL_INVEXLIST, RPL_ENFOFINVEXLIST))
 					}
 					continue
 				}
-				err := ch.ApplyMode(m)
+				if m.ModeChar == 'L' && m.Type == mode.Add && m.Param != "" {
+					if reply := s.checkForward(c, ch, m.Param); reply != nil {
+						return reply
+					}
+				}
+				err := ch.ApplyModeBy(m, c.Nick)
+				var invalidParam *channel.InvalidModeParamError
 				if errors.Is(err, channel.ErrNeedMoreParams) {
 					return prepMessage(ERR_NEEDMOREPARAMS, s.Name, c.Id(), err)
 				} else if errors.Is(err, channel.ErrUnknownMode) {
@@ -925,7 +1132,12 @@ func MODE(s *Server, c *client.Client, m *msg.Message) msg.Msg {
 					return prepMessage(ERR_USERNOTINCHANNEL, s.Name, c.Id(), err, ch)
 				} else if errors.Is(err, channel.ErrInvalidKey) {
 					return prepMessage(ERR_INVALIDKEY, s.Name, c.Id(), ch)
+				} else if errors.As(err, &invalidParam) {
+					return prepMessage(ERR_INVALIDMODEPARAM, s.Name, c.Id(), ch, string(invalidParam.ModeChar), invalidParam.Param)
+				} else if errors.Is(err, channel.ErrListFull) {
+					return prepMessage(ERR_BANLISTFULL, s.Name, c.Id(), ch, err)
 				} else {
+					s.scheduleExpiry(ch, m.ModeChar, m.Param)
 					appliedModes = append(appliedModes, m)
 				}
 			}
@@ -935,12 +1147,30 @@ func MODE(s *Server, c *client.Client, m *msg.Message) msg.Msg {
 			// only write final MODE to channel if any mode was actually altered
 			if modeStr != "" {
 				ch.WriteMessageFrom(msg.New(nil, s.Name, "", "", "MODE", []string{ch.String(), modeStr}, false), c)
+				s.propagateChan(ch, msg.New(nil, c.Nick, "", "", "MODE", []string{ch.String(), modeStr}, false))
+				s.channelChanged(ch)
 			}
 			return buff
 		}
 	}
 }
 
+// checkForward makes sure that c can set +L on ch to forward clients to
+// target. If it can't, the reply to c is returned.
+func (s *Server) checkForward(c *client.Client, ch *channel.Channel, target string) msg.Msg {
+	t, ok := s.getChannel(target)
+	if !ok {
+		return prepMessage(ERR_NOSUCHCHANNEL, s.Name, c.Id(), target)
+	}
+	if t == ch {
+		return prepMessage(ERR_INVALIDMODEPARAM, s.Name, c.Id(), ch, "L", target)
+	}
+	if self, belongs := t.GetMember(c.Nick); !t.FreeTarget && (!belongs || !self.Is(channel.Operator)) && !s.hasPrivilege(c, privOverride) {
+		return prepMessage(ERR_CHANOPRIVSNEEDED, s.Name, c.Id(), t)
+	}
+	return nil
+}
+
 func buildModestr(modes []mode.Mode) string {
 	applied := []byte{}
 	removed := []byte{}
@@ -969,10 +1199,14 @@ func buildModestr(modes []mode.Mode) string {
 }
 
 // used for responding to requests to list the various channel mode lists
-func (s *Server) sendChannelModeList(c *client.Client, ch *channel.Channel, list []string, dataResponse *msg.Message, endResponse *msg.Message) msg.Msg {
+func (s *Server) sendChannelModeList(c *client.Client, ch *channel.Channel, list []channel.ListEntry, dataResponse *msg.Message, endResponse *msg.Message) msg.Msg {
 	var buff msg.Buffer
 	for _, v := range list {
-		buff.AddMsg(prepMessage(dataResponse, s.Name, c.Id(), ch, v))
+		setBy := v.SetBy
+		if setBy == "" {
+			setBy = s.Name
+		}
+		buff.AddMsg(prepMessage(dataResponse, s.Name, c.Id(), ch, v.Mask, setBy, v.SetAt.Unix()))
 	}
 	buff.AddMsg(prepMessage(endResponse, s.Name, c.Id(), ch))
 	return buff
@@ -1110,8 +1344,11 @@ func constructSpcrplResponse(params string, c *client.Client, s *Server) string
 		case 'u':
 			resp[i] = c.User
 		case 'i':
-			// TODO: will this assertion fail if we add support for websockets?
-			resp[i] = c.RemoteAddr().(*net.TCPAddr).IP.String()
+			// clients on other servers have no address that we know of
+			resp[i] = "255.255.255.255"
+			if ip := addrIP(c.RemoteAddr()); ip != nil {
+				resp[i] = ip.String()
+			}
 		case 'h':
 			resp[i] = c.Host
 		case 's':
@@ -1199,14 +1436,21 @@ func (s *Server) sendWHOIS(c *client.Client, v *client.Client) msg.Buffer {
 	}
 
 	buff.AddMsg(prepMessage(RPL_WHOISUSER, s.Name, c.Id(), v.Nick, v.User, v.Host, v.Realname))
-	buff.AddMsg(prepMessage(RPL_WHOISSERVER, s.Name, c.Id(), v.Nick, s.Name, "wip irc server"))
+	server := s.Name
+	if v.Server != "" {
+		server = v.Server
+	}
+	buff.AddMsg(prepMessage(RPL_WHOISSERVER, s.Name, c.Id(), v.Nick, server, "wip irc server"))
 	if v.Is(client.Bot) {
 		buff.AddMsg(prepMessage(RPL_WHOISBOT, s.Name, c.Id(), v.Nick))
 	}
 	if v.Is(client.Op) {
 		buff.AddMsg(prepMessage(RPL_WHOISOPERATOR, s.Name, c.Id(), v.Nick))
 	}
-	if v == c || c.Is(client.Op) { // querying whois on self or self is an op
+	if v == c || s.hasPrivilege(c, privSeeHidden) { // querying whois on self or self can see hidden information
+		if v.RealHost != v.Host {
+			buff.AddMsg(prepMessage(RPL_WHOISHOST, s.Name, c.Id(), v.Nick, v.RealHost))
+		}
 		certPrint, err := v.CertificateFingerprint()
 		if err == nil {
 			buff.AddMsg(prepMessage(RPL_WHOISCERTFP, s.Name, c.Id(), v.Nick, certPrint))
@@ -1221,7 +1465,7 @@ func (s *Server) sendWHOIS(c *client.Client, v *client.Client) msg.Buffer {
 
 		// if client is invisible or this channel is secret, only send
 		// a response if the sender shares a channel with this client
-		if (k.Secret || v.Is(client.Invisible)) && !(senderBelongs && clientBelongs) {
+		if (k.Secret || v.Is(client.Invisible)) && !(senderBelongs && clientBelongs) && !s.hasPrivilege(c, privSeeHidden) {
 			continue
 		}
 		hasMultiPrefix := c.Caps[cap.MultiPrefix.Name]
@@ -1283,14 +1527,6 @@ func NOTICE(s *Server, c *client.Client, m *msg.Message) msg.Msg  { return s.com
 
 // communicate is used for PRIVMSG/NOTICE
 func (s *Server) communicate(m *msg.Message, c *client.Client) msg.Msg {
-	msgCopy := *m
-	// "Tags without the client-only prefix MUST be removed by the
-	// server before being relayed with any message to another client."
-	msgCopy.TrimNonClientTags()
-	msgCopy.Nick = c.Nick
-	msgCopy.Host = c.Host
-	msgCopy.User = c.User
-
 	skipReplies := m.Command == "NOTICE" || m.Command == "TAGMSG"
 
 	if (len(m.Params) < 2 || m.Params[1] == "") && m.Command != "TAGMSG" {
@@ -1303,7 +1539,18 @@ func (s *Server) communicate(m *msg.Message, c *client.Client) msg.Msg {
 	var buff msg.Buffer
 	recipients := strings.Split(m.Params[0], ",")
 	for _, v := range recipients {
+		// each recipient gets its own copy so that it can be given its
+		// own msgid and stored in history separately
+		msgCopy := *m
+		msgCopy.Params = slices.Clone(m.Params)
+		// "Tags without the client-only prefix MUST be removed by the
+		// server before being relayed with any message to another client."
+		msgCopy.TrimNonClientTags()
+		msgCopy.Nick = c.Nick
+		msgCopy.Host = c.Host
+		msgCopy.User = c.User
 		msgCopy.Params[0] = v
+		msgCopy.SetMsgid()
 
 		if isValidChannelString(v) {
 			chanName := v
@@ -1336,6 +1583,32 @@ func (s *Server) communicate(m *msg.Message, c *client.Client) msg.Msg {
 				}
 				continue
 			}
+			if (self == nil || self.Prefix == 0) && (ch.Muted(c) || ch.RegisteredModerated && !c.IsAuthenticated) {
+				if !skipReplies {
+					buff.AddMsg(prepMessage(ERR_CANNOTSENDTOCHAN, s.Name, c.Id(), ch))
+				}
+				continue
+			}
+			if len(msgCopy.Params) > 1 {
+				text := msgCopy.Params[1]
+				if ch.NoCTCP && isCTCP(text) || ch.NoColors && format.Contains(text) {
+					if !skipReplies {
+						buff.AddMsg(prepMessage(ERR_CANNOTSENDTOCHAN, s.Name, c.Id(), ch))
+					}
+					continue
+				}
+				if ch.StripColors {
+					msgCopy.Params[1] = format.Strip(text)
+				}
+			}
+			if self != nil && s.checkFlood(ch, c, channel.FloodMessage) {
+				continue
+			}
+
+			// messages sent only to a subset of members are not kept
+			if !hasPrefix {
+				s.storeHistory(chanName, c, &msgCopy)
+			}
 
 			// write to everybody else in the chan besides self
 			for member := range ch.AllExcept(c) {
@@ -1346,9 +1619,6 @@ func (s *Server) communicate(m *msg.Message, c *client.Client) msg.Msg {
 				if msgCopy.Command == "TAGMSG" && !member.Caps[cap.MessageTags.Name] {
 					continue
 				}
-				if member.Caps[cap.MessageTags.Name] {
-					msgCopy.SetMsgid()
-				}
 				member.WriteMessageFrom(&msgCopy, c)
 			}
 		} else { // client->client
@@ -1360,6 +1630,12 @@ func (s *Server) communicate(m *msg.Message, c *client.Client) msg.Msg {
 				continue
 			}
 
+			if target.Is(client.AuthOnly) && !c.IsAuthenticated && target != c {
+				if !skipReplies {
+					buff.AddMsg(prepMessage(ERR_NONONREG, s.Name, c.Id(), target.Nick))
+				}
+				continue
+			}
 			if target.Is(client.Away) {
 				buff.AddMsg(prepMessage(RPL_AWAY, s.Name, c.Id(), target.Nick, target.AwayMsg))
 				continue
@@ -1367,9 +1643,7 @@ func (s *Server) communicate(m *msg.Message, c *client.Client) msg.Msg {
 			if msgCopy.Command == "TAGMSG" && !target.Caps[cap.MessageTags.Name] {
 				continue
 			}
-			if target.Caps[cap.MessageTags.Name] {
-				msgCopy.SetMsgid()
-			}
+			s.storeDirectHistory(target, c, &msgCopy)
 			target.WriteMessageFrom(&msgCopy, c)
 		}
 
@@ -1385,6 +1659,11 @@ func (s *Server) communicate(m *msg.Message, c *client.Client) msg.Msg {
 	return buff
 }
 
+// isCTCP returns true if text is a CTCP other than ACTION.
+func isCTCP(text string) bool {
+	return strings.HasPrefix(text, "\x01") && !strings.HasPrefix(text, "\x01ACTION")
+}
+
 func PING(s *Server, c *client.Client, m *msg.Message) msg.Msg {
 	if len(m.Params) < 1 {
 		return prepMessage(ERR_NEEDMOREPARAMS, s.Name, c.Id(), "PING")
@@ -1403,6 +1682,7 @@ func ERROR(s *Server, c *client.Client, m *msg.Message) msg.Msg { return nil }
 
 func AWAY(s *Server, c *client.Client, m *msg.Message) msg.Msg {
 	defer s.awayNotify(c, slices.Collect(s.channelsOf(c))...)
+	defer func() { s.propagate(nil, msg.New(nil, c.Nick, "", "", "AWAY", []string{c.AwayMsg}, true)) }()
 
 	// remove away
 	if len(m.Params) == 0 || m.Params[0] == "" {
@@ -1428,15 +1708,14 @@ func (s *Server) awayNotify(c *client.Client, chans ...*channel.Channel) {
 }
 
 func REHASH(s *Server, c *client.Client, m *msg.Message) msg.Msg {
-	if !c.Is(client.Op) {
+	if !s.hasPrivilege(c, privRehash) {
 		return prepMessage(ERR_NOPRIVILEGES, s.Name, c.Id())
 	}
 
-	conf, _ := NewConfig(s.configSource)
-	s.Config = conf
-
-	fileName := s.configSource.(*os.File).Name()
-	return prepMessage(RPL_REHASHING, s.Name, c.Id(), fileName)
+	// commands hold configLock while they execute, so the rehash has
+	// to wait until this one is done
+	go s.rehashFor(c)
+	return prepMessage(RPL_REHASHING, s.Name, c.Id(), s.path)
 }
 
 func USERHOST(s *Server, c *client.Client, m *msg.Message) msg.Msg {
@@ -1474,7 +1753,7 @@ func WALLOPS(s *Server, c *client.Client, m *msg.Message) msg.Msg {
 		return prepMessage(ERR_NEEDMOREPARAMS, s.Name, c.Id(), "WALLOPS")
 	}
 
-	if !c.Is(client.Op) {
+	if !s.hasPrivilege(c, privWallops) {
 		return prepMessage(ERR_NOPRIVILEGES, s.Name, c.Id())
 	}
 
@@ -1490,24 +1769,43 @@ func WALLOPS(s *Server, c *client.Client, m *msg.Message) msg.Msg {
 	return nil
 }
 
+// commands that only affect the connection they were sent on. Every
+// other command is executed on behalf of the client's identity.
+var sessionCommands = map[string]bool{
+	"CAP":  true,
+	"PING": true,
+	"PONG": true,
+	"QUIT": true,
+}
+
 func (s *Server) executeMessage(m *msg.Message, c *client.Client) {
+	s.configLock.RLock()
+	defer s.configLock.RUnlock()
+
 	upper := strings.ToUpper(m.Command)
 	// ignore unregistered user commands until registration completes
-	if !c.Is(client.Registered) && (upper != "CAP" && upper != "NICK" && upper != "USER" && upper != "PASS" && upper != "AUTHENTICATE" && upper != "QUIT" && upper != "PING") {
+	if !c.Is(client.Registered) && (upper != "CAP" && upper != "NICK" && upper != "USER" && upper != "PASS" && upper != "AUTHENTICATE" && upper != "QUIT" && upper != "PING" && upper != "REGISTER" && upper != "VERIFY") {
 		s.writeReply(c, ERR_NOTREGISTERED)
 		return
 	}
 
+	// replies are only sent back to the connection that sent m
+	conn := c
+	if !sessionCommands[upper] {
+		c = c.Identity()
+	}
+
 	hasLabel, label := m.HasTag("label")
 
-	if e, ok := commands[upper]; ok {
+	if e, ok := s.commands[upper]; ok {
+		s.metrics.command(upper)
 		resp := e(s, c, m)
 
 		// check if we need to batch these messages
-		if c.Caps[cap.LabeledResponses.Name] {
+		if conn.Caps[cap.LabeledResponses.Name] {
 			// send ACK
 			if hasLabel && resp == nil {
-				c.WriteMessage(msg.New([]msg.Tag{{Key: "label", Value: label}}, s.Name, "", "", "ACK", nil, false))
+				conn.WriteToConn(msg.New([]msg.Tag{{Key: "label", Value: label}}, s.Name, "", "", "ACK", nil, false))
 				return
 			}
 
@@ -1520,14 +1818,14 @@ func (s *Server) executeMessage(m *msg.Message, c *client.Client) {
 		}
 
 		if resp != nil {
-			c.WriteMessage(resp)
+			conn.WriteToConn(resp)
 		}
 		c.UpdateIdleTime(time.Now())
 	} else {
 		errMsg := prepMessage(ERR_UNKNOWNCOMMAND, s.Name, c.Id(), m.Command)
-		if c.Caps[cap.LabeledResponses.Name] && hasLabel {
+		if conn.Caps[cap.LabeledResponses.Name] && hasLabel {
 			errMsg.AddTag("label", label)
 		}
-		c.WriteMessage(errMsg)
+		conn.WriteToConn(errMsg)
 	}
 }
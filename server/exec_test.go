@@ -13,6 +13,13 @@ import (
 
 var conf = &Config{Name: "gossip", Port: ":0"}
 
+func init() {
+	// keep the output of the tests readable
+	conf.Log.Level = "error"
+	// hosts are checked as they are, except by the cloak tests
+	conf.Cloak.Disabled = true
+}
+
 func TestRegistration(t *testing.T) {
 	t.Parallel()
 
@@ -899,6 +906,7 @@ func TestMODEChannel(t *testing.T) {
 	})
 
 	t.Run("TestRPLBANLIST", func(t *testing.T) {
+		local, _ := s.getChannel("#local")
 		s.clients.GetWithoutCheck("alice").FillGrants()
 
 		c1.Write([]byte("MODE #local +b abc\r\nMODE #local +b def\r\nMODE #local +b ghi\r\n"))
@@ -909,9 +917,9 @@ func TestMODEChannel(t *testing.T) {
 		ghi, _ := r1.ReadBytes('\n')
 		end, _ := r1.ReadBytes('\n')
 
-		assertResponse(abc, fmt.Sprintf(":%s 367 alice #local abc\r\n", s.Name), t)
-		assertResponse(def, fmt.Sprintf(":%s 367 alice #local def\r\n", s.Name), t)
-		assertResponse(ghi, fmt.Sprintf(":%s 367 alice #local ghi\r\n", s.Name), t)
+		assertResponse(abc, fmt.Sprintf(":%s 367 alice #local abc alice %d\r\n", s.Name, local.Ban[0].SetAt.Unix()), t)
+		assertResponse(def, fmt.Sprintf(":%s 367 alice #local def alice %d\r\n", s.Name, local.Ban[1].SetAt.Unix()), t)
+		assertResponse(ghi, fmt.Sprintf(":%s 367 alice #local ghi alice %d\r\n", s.Name, local.Ban[2].SetAt.Unix()), t)
 		assertResponse(end, fmt.Sprintf(":%s 368 alice #local :End of channel ban list\r\n", s.Name), t)
 
 		// test that 'MODE #local b' works
@@ -920,13 +928,14 @@ func TestMODEChannel(t *testing.T) {
 		def, _ = r1.ReadBytes('\n')
 		ghi, _ = r1.ReadBytes('\n')
 		end, _ = r1.ReadBytes('\n')
-		assertResponse(abc, fmt.Sprintf(":%s 367 alice #local abc\r\n", s.Name), t)
-		assertResponse(def, fmt.Sprintf(":%s 367 alice #local def\r\n", s.Name), t)
-		assertResponse(ghi, fmt.Sprintf(":%s 367 alice #local ghi\r\n", s.Name), t)
+		assertResponse(abc, fmt.Sprintf(":%s 367 alice #local abc alice %d\r\n", s.Name, local.Ban[0].SetAt.Unix()), t)
+		assertResponse(def, fmt.Sprintf(":%s 367 alice #local def alice %d\r\n", s.Name, local.Ban[1].SetAt.Unix()), t)
+		assertResponse(ghi, fmt.Sprintf(":%s 367 alice #local ghi alice %d\r\n", s.Name, local.Ban[2].SetAt.Unix()), t)
 		assertResponse(end, fmt.Sprintf(":%s 368 alice #local :End of channel ban list\r\n", s.Name), t)
 	})
 
 	t.Run("TestRPLEXCEPTLIST", func(t *testing.T) {
+		local, _ := s.getChannel("#local")
 		s.clients.GetWithoutCheck("alice").FillGrants()
 
 		c1.Write([]byte("MODE #local +e abc\r\nMODE #local +e def\r\nMODE #local +e ghi\r\n"))
@@ -939,13 +948,14 @@ func TestMODEChannel(t *testing.T) {
 		ghi, _ := r1.ReadBytes('\n')
 		end, _ := r1.ReadBytes('\n')
 
-		assertResponse(abc, fmt.Sprintf(":%s 348 alice #local abc\r\n", s.Name), t)
-		assertResponse(def, fmt.Sprintf(":%s 348 alice #local def\r\n", s.Name), t)
-		assertResponse(ghi, fmt.Sprintf(":%s 348 alice #local ghi\r\n", s.Name), t)
+		assertResponse(abc, fmt.Sprintf(":%s 348 alice #local abc alice %d\r\n", s.Name, local.BanExcept[0].SetAt.Unix()), t)
+		assertResponse(def, fmt.Sprintf(":%s 348 alice #local def alice %d\r\n", s.Name, local.BanExcept[1].SetAt.Unix()), t)
+		assertResponse(ghi, fmt.Sprintf(":%s 348 alice #local ghi alice %d\r\n", s.Name, local.BanExcept[2].SetAt.Unix()), t)
 		assertResponse(end, fmt.Sprintf(":%s 349 alice #local :End of channel exception list\r\n", s.Name), t)
 	})
 
 	t.Run("TestRPLINVITELIST", func(t *testing.T) {
+		local, _ := s.getChannel("#local")
 		s.clients.GetWithoutCheck("alice").FillGrants()
 
 		c1.Write([]byte("MODE #local +I abc\r\nMODE #local +I def\r\nMODE #local +I ghi\r\n"))
@@ -958,9 +968,9 @@ func TestMODEChannel(t *testing.T) {
 		ghi, _ := r1.ReadBytes('\n')
 		end, _ := r1.ReadBytes('\n')
 
-		assertResponse(abc, fmt.Sprintf(":%s 346 alice #local abc\r\n", s.Name), t)
-		assertResponse(def, fmt.Sprintf(":%s 346 alice #local def\r\n", s.Name), t)
-		assertResponse(ghi, fmt.Sprintf(":%s 346 alice #local ghi\r\n", s.Name), t)
+		assertResponse(abc, fmt.Sprintf(":%s 346 alice #local abc alice %d\r\n", s.Name, local.InviteExcept[0].SetAt.Unix()), t)
+		assertResponse(def, fmt.Sprintf(":%s 346 alice #local def alice %d\r\n", s.Name, local.InviteExcept[1].SetAt.Unix()), t)
+		assertResponse(ghi, fmt.Sprintf(":%s 346 alice #local ghi alice %d\r\n", s.Name, local.InviteExcept[2].SetAt.Unix()), t)
 		assertResponse(end, fmt.Sprintf(":%s 347 alice #local :End of channel invite list\r\n", s.Name), t)
 	})
 
@@ -1743,7 +1753,6 @@ func TestWALLOPS(t *testing.T) {
 func TestREHASH(t *testing.T) {
 	t.Parallel()
 
-	conf.configSource = strings.NewReader(`{"name": "gossip"}`)
 	s, err := New(conf)
 	if err != nil {
 		t.Fatal(err)
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"testing"
+)
+
+func TestExtbans(t *testing.T) {
+	t.Parallel()
+
+	s, err := New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	go s.Serve()
+
+	op, opR := s.connectAndRegister("alice")
+	defer op.Close()
+	op.Write([]byte("JOIN #chan\r\n"))
+	readLines(opR, 3)
+
+	t.Run("Realname", func(t *testing.T) {
+		op.Write([]byte("MODE #chan +b $r:bo?\r\n"))
+		readLines(opR, 1)
+
+		c, r := s.connectAndRegister("bob")
+		defer c.Close()
+		c.Write([]byte("JOIN #chan\r\n"))
+		resp, _ := r.ReadBytes('\n')
+		assertResponse(resp, prepMessage(ERR_BANNEDFROMCHAN, s.Name, "bob", "#chan").String(), t)
+
+		op.Write([]byte("MODE #chan -b $r:bo?\r\n"))
+		readLines(opR, 1)
+	})
+
+	t.Run("Mute", func(t *testing.T) {
+		op.Write([]byte("MODE #chan +b $m:carol!*@*\r\n"))
+		readLines(opR, 1)
+
+		c, r := s.connectAndRegister("carol")
+		defer c.Close()
+		c.Write([]byte("JOIN #chan\r\nPRIVMSG #chan :hi\r\n"))
+		resp, _ := readLines(r, 4)
+		assertResponse(resp, prepMessage(ERR_CANNOTSENDTOCHAN, s.Name, "carol", "#chan").String(), t)
+		readLines(opR, 1)
+
+		// an exception lifts the mute
+		op.Write([]byte("MODE #chan +e $r:carol\r\n"))
+		readLines(opR, 1)
+		readLines(r, 1)
+		c.Write([]byte("PRIVMSG #chan :hi\r\n"))
+		resp, _ = opR.ReadBytes('\n')
+		assertResponse(resp, ":carol!carol@localhost PRIVMSG #chan :hi\r\n", t)
+	})
+
+	t.Run("MuteMixedCase", func(t *testing.T) {
+		op.Write([]byte("MODE #chan +b $M:erin!*@*\r\n"))
+		readLines(opR, 1)
+
+		c, r := s.connectAndRegister("erin")
+		defer c.Close()
+		c.Write([]byte("JOIN #chan\r\nPRIVMSG #chan :hi\r\n"))
+		resp, _ := readLines(r, 4)
+		assertResponse(resp, prepMessage(ERR_CANNOTSENDTOCHAN, s.Name, "erin", "#chan").String(), t)
+		readLines(opR, 1)
+	})
+
+	t.Run("Insecure", func(t *testing.T) {
+		op.Write([]byte("MODE #chan +b $z\r\n"))
+		readLines(opR, 2)
+
+		c, r := s.connectAndRegister("dan")
+		defer c.Close()
+		c.Write([]byte("JOIN #chan\r\n"))
+		resp, _ := r.ReadBytes('\n')
+		assertResponse(resp, prepMessage(ERR_BANNEDFROMCHAN, s.Name, "dan", "#chan").String(), t)
+	})
+}
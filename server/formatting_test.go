@@ -0,0 +1,49 @@
+package server
+
+import "testing"
+
+func TestColorModes(t *testing.T) {
+	t.Parallel()
+
+	s, err := New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	go s.Serve()
+
+	op, opR := s.connectAndRegister("alice")
+	defer op.Close()
+	op.Write([]byte("JOIN #c\r\nMODE #c +cC\r\nJOIN #s\r\nMODE #s +S\r\n"))
+	readLines(opR, 8)
+
+	c, r := s.connectAndRegister("bob")
+	defer c.Close()
+	c.Write([]byte("JOIN #c\r\nJOIN #s\r\n"))
+	readLines(r, 6)
+	readLines(opR, 2)
+
+	t.Run("NoColors", func(t *testing.T) {
+		c.Write([]byte("PRIVMSG #c :\x0304red\r\n"))
+		resp, _ := r.ReadBytes('\n')
+		assertResponse(resp, prepMessage(ERR_CANNOTSENDTOCHAN, s.Name, "bob", "#c").String(), t)
+	})
+
+	t.Run("NoCTCP", func(t *testing.T) {
+		c.Write([]byte("PRIVMSG #c :\x01VERSION\x01\r\nPRIVMSG #c :\x01ACTION waves\x01\r\n"))
+		resp, _ := r.ReadBytes('\n')
+		assertResponse(resp, prepMessage(ERR_CANNOTSENDTOCHAN, s.Name, "bob", "#c").String(), t)
+
+		action, _ := opR.ReadBytes('\n')
+		assertResponse(action, ":bob!bob@localhost PRIVMSG #c :\x01ACTION waves\x01\r\n", t)
+	})
+
+	t.Run("StripColors", func(t *testing.T) {
+		c.Write([]byte("PRIVMSG #s :\x02bold\x02 and \x0304,12red\r\n"))
+		resp, _ := opR.ReadBytes('\n')
+		assertResponse(resp, ":bob!bob@localhost PRIVMSG #s :bold and red\r\n", t)
+	})
+
+	c.Write([]byte("PART #c\r\nPART #s\r\n"))
+	readLines(r, 2)
+}
@@ -0,0 +1,55 @@
+package server
+
+import "testing"
+
+func TestForward(t *testing.T) {
+	t.Parallel()
+
+	s, err := New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	go s.Serve()
+
+	op, opR := s.connectAndRegister("alice")
+	defer op.Close()
+	op.Write([]byte("JOIN #announce\r\nMODE #announce +l 1\r\nJOIN #overflow\r\nMODE #announce +L #overflow\r\n"))
+	set, _ := readLines(opR, 8)
+	assertResponse(set, ":gossip MODE #announce +L #overflow\r\n", t)
+
+	c, r := s.connectAndRegister("bob")
+	defer c.Close()
+
+	t.Run("Forwarded", func(t *testing.T) {
+		c.Write([]byte("JOIN #announce\r\n"))
+		link, _ := r.ReadBytes('\n')
+		assertResponse(link, prepMessage(ERR_LINKCHANNEL, s.Name, "bob", "#announce", "#overflow").String(), t)
+		join, _ := r.ReadBytes('\n')
+		assertResponse(join, ":bob!bob@localhost JOIN #overflow\r\n", t)
+		readLines(r, 2)
+
+		opJoin, _ := opR.ReadBytes('\n')
+		assertResponse(opJoin, ":bob!bob@localhost JOIN #overflow\r\n", t)
+	})
+
+	t.Run("NotOperatorOfTarget", func(t *testing.T) {
+		c.Write([]byte("JOIN #mine\r\nMODE #mine +L #overflow\r\n"))
+		resp, _ := readLines(r, 4)
+		assertResponse(resp, prepMessage(ERR_CHANOPRIVSNEEDED, s.Name, "bob", "#overflow").String(), t)
+	})
+
+	t.Run("FreeTarget", func(t *testing.T) {
+		op.Write([]byte("MODE #overflow +F\r\n"))
+		opR.ReadBytes('\n')
+		r.ReadBytes('\n')
+
+		c.Write([]byte("MODE #mine +L #overflow\r\n"))
+		resp, _ := r.ReadBytes('\n')
+		assertResponse(resp, ":gossip MODE #mine +L #overflow\r\n", t)
+	})
+
+	c.Write([]byte("PART #overflow\r\n"))
+	r.ReadBytes('\n')
+	opR.ReadBytes('\n')
+}
@@ -0,0 +1,471 @@
+package server
+
+import (
+	"cmp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	cap "github.com/mitchr/gossip/capability"
+	"github.com/mitchr/gossip/client"
+	"github.com/mitchr/gossip/scan"
+	"github.com/mitchr/gossip/scan/msg"
+)
+
+// the maximum number of messages that can be requested by a single
+// CHATHISTORY command
+const chathistoryLimit = 100
+
+const historyTimeFormat = "2006-01-02T15:04:05.000Z"
+
+// how many messages can be waiting to be written to the history table
+// before new ones are dropped
+const historyQueueSize = 1024
+
+// how often messages that are older than History.Days are deleted
+const historyPruneInterval = time.Hour
+
+// how many days messages are kept for if History.Days is not set
+const defaultHistoryDays = 30
+
+type historyEntry struct {
+	account string
+	time    int64 // unix milliseconds
+	message []byte
+}
+
+// A historyRecord is a row of the history table that is waiting to be
+// written.
+type historyRecord struct {
+	msgid   string
+	target  string
+	sender  string
+	account string
+	time    int64
+	message []byte
+
+	// if non-nil, this is not a row; it is closed once every record
+	// that was queued before it has been written
+	flushed chan struct{}
+}
+
+// storeHistory queues m to be persisted so that it can later be
+// replayed with CHATHISTORY. Channel messages are stored by the channel
+// and the nick of their sender.
+func (s *Server) storeHistory(channel string, from *client.Client, m *msg.Message) {
+	s.queueHistory(strings.ToLower(channel), strings.ToLower(from.Nick), from, m)
+}
+
+// storeDirectHistory queues a private message from from to target. The
+// conversation is stored by the accounts of both clients, so that
+// someone else who later uses either nick can't read it. Messages
+// between clients that are not both logged in are not kept.
+func (s *Server) storeDirectHistory(target, from *client.Client, m *msg.Message) {
+	if !from.IsAuthenticated || !target.IsAuthenticated {
+		return
+	}
+	s.queueHistory(strings.ToLower(target.SASLMech.Authn()), strings.ToLower(from.SASLMech.Authn()), from, m)
+}
+
+func (s *Server) queueHistory(target, sender string, from *client.Client, m *msg.Message) {
+	account := ""
+	if from.IsAuthenticated {
+		account = from.SASLMech.Authn()
+	}
+
+	_, msgid := m.HasTag("msgid")
+	r := historyRecord{
+		msgid:   msgid,
+		target:  target,
+		sender:  sender,
+		account: account,
+		time:    time.Now().UnixMilli(),
+		message: m.Bytes(),
+	}
+
+	// the database is shared by every connection, so messages are written
+	// by writeHistory instead of holding up the command
+	select {
+	case s.history <- r:
+	default:
+		s.logger.Warn("history queue is full; dropping message", "target", target)
+	}
+}
+
+// flushHistory waits until every message that has been queued so far
+// is written, so that queries see them.
+func (s *Server) flushHistory() {
+	flushed := make(chan struct{})
+	select {
+	case s.history <- historyRecord{flushed: flushed}:
+	case <-s.historyDone:
+		return
+	}
+	select {
+	case <-flushed:
+	case <-s.historyDone:
+	}
+}
+
+// writeHistory writes queued messages to the database until s is
+// closed.
+func (s *Server) writeHistory() {
+	defer close(s.historyDone)
+
+	for {
+		select {
+		case r := <-s.history:
+			s.writeHistoryBatch(r)
+		case <-s.ctx.Done():
+			// write what is left before giving up
+			for {
+				select {
+				case r := <-s.history:
+					s.writeHistoryBatch(r)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// writeHistoryBatch writes r, along with every other record that is
+// already waiting, in a single transaction.
+func (s *Server) writeHistoryBatch(r historyRecord) {
+	batch := []historyRecord{r}
+loop:
+	for len(batch) < historyQueueSize {
+		select {
+		case r := <-s.history:
+			batch = append(batch, r)
+		default:
+			break loop
+		}
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		s.logger.Error("could not store history", "err", err)
+	} else {
+		for _, r := range batch {
+			if r.flushed != nil {
+				continue
+			}
+			_, err = tx.Exec("INSERT INTO history VALUES(?, ?, ?, ?, ?, ?)", r.msgid, r.target, r.sender, r.account, r.time, r.message)
+			if err != nil {
+				s.logger.Error("could not store history", "target", r.target, "err", err)
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			s.logger.Error("could not store history", "err", err)
+		}
+	}
+
+	for _, r := range batch {
+		if r.flushed != nil {
+			close(r.flushed)
+		}
+	}
+}
+
+// pruneHistory deletes messages that are older than History.Days every
+// historyPruneInterval until s is closed. This is kept apart from
+// writeHistory, which commands wait on while holding configLock.
+func (s *Server) pruneHistory() {
+	tick := time.NewTicker(historyPruneInterval)
+	defer tick.Stop()
+
+	for {
+		s.deleteOldHistory()
+		select {
+		case <-tick.C:
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+// deleteOldHistory deletes messages that are older than History.Days.
+func (s *Server) deleteOldHistory() {
+	days := s.config().History.Days
+	if days < 0 {
+		return
+	}
+	if days == 0 {
+		days = defaultHistoryDays
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -days).UnixMilli()
+	if _, err := s.db.Exec("DELETE FROM history WHERE time < ?", cutoff); err != nil {
+		s.logger.Error("could not prune history", "err", err)
+	}
+}
+
+// conversation returns a condition that selects every message that c
+// is allowed to see in target. Private messages can only be seen by
+// clients that are logged in, and target has to be the nick of someone
+// who is logged in or who has registered it.
+func (s *Server) conversation(c *client.Client, target string) (string, []any, bool) {
+	target = strings.ToLower(target)
+	if isValidChannelString(target) {
+		return "target = ?", []any{target}, true
+	}
+
+	if !c.IsAuthenticated {
+		return "", nil, false
+	}
+	partner := ""
+	if t, ok := s.getClient(target); ok {
+		if t.IsAuthenticated {
+			partner = t.SASLMech.Authn()
+		}
+	} else {
+		partner = s.userAccountForNickExists(target)
+	}
+	if partner == "" {
+		return "", nil, false
+	}
+
+	self, partner := strings.ToLower(c.SASLMech.Authn()), strings.ToLower(partner)
+	return "((target = ? AND sender = ?) OR (target = ? AND sender = ?))", []any{self, partner, partner, self}, true
+}
+
+// queryHistory selects at most limit messages from the conversation
+// matching cond whose time satisfies bound. Messages are always
+// returned in chronological order; if newestFirst is true, the newest
+// messages that satisfy bound are selected.
+func (s *Server) queryHistory(cond string, args []any, bound string, boundArgs []any, newestFirst bool, limit int) []historyEntry {
+	order := "ASC"
+	if newestFirst {
+		order = "DESC"
+	}
+
+	query := "SELECT account, time, message FROM history WHERE " + cond
+	if bound != "" {
+		query += " AND " + bound
+	}
+	query += " ORDER BY time " + order + " LIMIT ?"
+
+	rows, err := s.db.Query(query, slices.Concat(args, boundArgs, []any{limit})...)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+
+	var entries []historyEntry
+	for rows.Next() {
+		var e historyEntry
+		if rows.Scan(&e.account, &e.time, &e.message) == nil {
+			entries = append(entries, e)
+		}
+	}
+
+	if newestFirst {
+		slices.Reverse(entries)
+	}
+	return entries
+}
+
+// resolveMsgRef converts a msgref ("timestamp=..." or "msgid=...") into
+// a unix millisecond timestamp.
+func (s *Server) resolveMsgRef(cond string, args []any, ref string) (int64, bool) {
+	k, v, found := strings.Cut(ref, "=")
+	if !found {
+		return 0, false
+	}
+
+	switch k {
+	case "timestamp":
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return 0, false
+		}
+		return t.UnixMilli(), true
+	case "msgid":
+		var t int64
+		err := s.db.QueryRow("SELECT time FROM history WHERE msgid = ? AND "+cond, append([]any{v}, args...)...).Scan(&t)
+		return t, err == nil
+	}
+	return 0, false
+}
+
+// replay prepares a stored message to be sent to c, or returns nil if c
+// should not receive it.
+func (s *Server) replay(c *client.Client, e historyEntry) msg.Msg {
+	p := &scan.Parser{Lexer: scan.Lex(e.message, msg.LexMessage)}
+	m, err := msg.Parse(p)
+	if err != nil {
+		return nil
+	}
+
+	if !c.HasMessageTags() {
+		if m.Command == "TAGMSG" {
+			return nil
+		}
+		m = m.RemoveAllTags().(*msg.Message)
+	}
+	if c.Caps[cap.ServerTime.Name] {
+		m.AddTag("time", time.UnixMilli(e.time).UTC().Format(historyTimeFormat))
+	}
+	if e.account != "" && c.Caps[cap.AccountTag.Name] {
+		m.AddTag("account", e.account)
+	}
+	return m
+}
+
+// CHATHISTORY LATEST <target> <* | msgref> <limit>
+// CHATHISTORY BEFORE <target> <msgref> <limit>
+// CHATHISTORY AFTER <target> <msgref> <limit>
+// CHATHISTORY AROUND <target> <msgref> <limit>
+// CHATHISTORY BETWEEN <target> <msgref> <msgref> <limit>
+// CHATHISTORY TARGETS <timestamp> <timestamp> <limit>
+func CHATHISTORY(s *Server, c *client.Client, m *msg.Message) msg.Msg {
+	if len(m.Params) < 4 {
+		s.stdReply(c, FAIL, "CHATHISTORY", "NEED_MORE_PARAMS", "", "Missing parameters")
+		return nil
+	}
+
+	subcommand := strings.ToUpper(m.Params[0])
+	if subcommand == "TARGETS" {
+		return s.chathistoryTargets(c, m.Params[1:])
+	}
+
+	target := m.Params[1]
+	if isValidChannelString(target) {
+		if ch, _ := s.clientBelongstoChan(c, target); ch == nil {
+			s.stdReply(c, FAIL, "CHATHISTORY", "INVALID_TARGET", subcommand+" "+target, "Messages could not be retrieved")
+			return nil
+		}
+	}
+	cond, args, ok := s.conversation(c, target)
+	if !ok {
+		s.stdReply(c, FAIL, "CHATHISTORY", "INVALID_TARGET", subcommand+" "+target, "Private messages are only kept between users who are logged in")
+		return nil
+	}
+
+	limitParam := m.Params[len(m.Params)-1]
+	limit, err := strconv.Atoi(limitParam)
+	if err != nil || limit <= 0 {
+		s.stdReply(c, FAIL, "CHATHISTORY", "INVALID_PARAMS", subcommand+" "+limitParam, "Invalid limit")
+		return nil
+	}
+	limit = min(limit, chathistoryLimit)
+
+	s.flushHistory()
+	ref, ok := s.resolveMsgRef(cond, args, m.Params[2])
+	if !ok && !(subcommand == "LATEST" && m.Params[2] == "*") {
+		s.stdReply(c, FAIL, "CHATHISTORY", "INVALID_PARAMS", subcommand+" "+m.Params[2], "Invalid message reference")
+		return nil
+	}
+
+	var entries []historyEntry
+	switch subcommand {
+	case "LATEST":
+		if m.Params[2] == "*" {
+			entries = s.queryHistory(cond, args, "", nil, true, limit)
+		} else {
+			entries = s.queryHistory(cond, args, "time > ?", []any{ref}, true, limit)
+		}
+	case "BEFORE":
+		entries = s.queryHistory(cond, args, "time < ?", []any{ref}, true, limit)
+	case "AFTER":
+		entries = s.queryHistory(cond, args, "time > ?", []any{ref}, false, limit)
+	case "AROUND":
+		entries = s.queryHistory(cond, args, "time < ?", []any{ref}, true, limit/2)
+		entries = append(entries, s.queryHistory(cond, args, "time >= ?", []any{ref}, false, limit-len(entries))...)
+	case "BETWEEN":
+		if len(m.Params) < 5 {
+			s.stdReply(c, FAIL, "CHATHISTORY", "NEED_MORE_PARAMS", subcommand, "Missing parameters")
+			return nil
+		}
+		end, ok := s.resolveMsgRef(cond, args, m.Params[3])
+		if !ok {
+			s.stdReply(c, FAIL, "CHATHISTORY", "INVALID_PARAMS", subcommand+" "+m.Params[3], "Invalid message reference")
+			return nil
+		}
+
+		if ref < end {
+			entries = s.queryHistory(cond, args, "time > ? AND time < ?", []any{ref, end}, false, limit)
+		} else {
+			entries = s.queryHistory(cond, args, "time < ? AND time > ?", []any{ref, end}, true, limit)
+		}
+	default:
+		s.stdReply(c, FAIL, "CHATHISTORY", "INVALID_PARAMS", subcommand, "Unknown subcommand")
+		return nil
+	}
+
+	buff := msg.Buffer{}
+	for _, e := range entries {
+		if r := s.replay(c, e); r != nil {
+			buff.AddMsg(r)
+		}
+	}
+	return buff.WrapInBatch(msg.ChatHistory, target)
+}
+
+// chathistoryTargets lists the conversations that c had between two
+// timestamps, along with the time of the latest message in each.
+func (s *Server) chathistoryTargets(c *client.Client, params []string) msg.Msg {
+	start, err1 := time.Parse(time.RFC3339Nano, strings.TrimPrefix(params[0], "timestamp="))
+	end, err2 := time.Parse(time.RFC3339Nano, strings.TrimPrefix(params[1], "timestamp="))
+	limit, err3 := strconv.Atoi(params[2])
+	if err1 != nil || err2 != nil || err3 != nil || limit <= 0 {
+		s.stdReply(c, FAIL, "CHATHISTORY", "INVALID_PARAMS", "TARGETS", "Invalid parameters")
+		return nil
+	}
+	limit = min(limit, chathistoryLimit)
+
+	lo, hi := start.UnixMilli(), end.UnixMilli()
+	if lo > hi {
+		lo, hi = hi, lo
+	}
+	s.flushHistory()
+
+	type target struct {
+		name   string
+		latest int64
+	}
+	var targets []target
+
+	for ch := range s.channelsOf(c) {
+		var latest *int64
+		s.db.QueryRow("SELECT MAX(time) FROM history WHERE target = ? AND time > ? AND time < ?", strings.ToLower(ch.String()), lo, hi).Scan(&latest)
+		if latest != nil {
+			targets = append(targets, target{ch.String(), *latest})
+		}
+	}
+
+	// private messages are stored by account, so only clients that are
+	// logged in have any
+	if c.IsAuthenticated {
+		self := strings.ToLower(c.SASLMech.Authn())
+		rows, err := s.db.Query(`
+			SELECT partner, MAX(time) FROM (
+				SELECT sender AS partner, time FROM history WHERE target = ?
+				UNION ALL
+				SELECT target AS partner, time FROM history WHERE sender = ? AND substr(target, 1, 1) NOT IN ('#', '&')
+			) WHERE time > ? AND time < ? GROUP BY partner`, self, self, lo, hi)
+		if err == nil {
+			for rows.Next() {
+				var t target
+				if rows.Scan(&t.name, &t.latest) == nil {
+					targets = append(targets, t)
+				}
+			}
+			rows.Close()
+		}
+	}
+
+	slices.SortFunc(targets, func(a, b target) int { return cmp.Compare(a.latest, b.latest) })
+	if len(targets) > limit {
+		targets = targets[:limit]
+	}
+
+	buff := msg.Buffer{}
+	for _, t := range targets {
+		buff.AddMsg(msg.New(nil, s.Name, "", "", "CHATHISTORY", []string{"TARGETS", t.name, time.UnixMilli(t.latest).UTC().Format(historyTimeFormat)}, false))
+	}
+	return buff.WrapInBatch(msg.ChatHistoryTargets)
+}
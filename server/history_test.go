@@ -0,0 +1,161 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mitchr/gossip/sasl/plain"
+)
+
+func TestCHATHISTORY(t *testing.T) {
+	t.Parallel()
+
+	s, err := New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	go s.Serve()
+
+	c1, r1 := s.connectAndRegister("a")
+	defer c1.Close()
+	c2, r2 := s.connectAndRegister("b")
+	defer c2.Close()
+
+	c1.Write([]byte("JOIN #test\r\n"))
+	readLines(r1, 3)
+	c2.Write([]byte("JOIN #test\r\n"))
+	readLines(r2, 3)
+	r1.ReadBytes('\n')
+
+	c2.Write([]byte("CAP REQ :batch draft/chathistory\r\n"))
+	r2.ReadBytes('\n')
+
+	c1.Write([]byte("PRIVMSG #test :one\r\nPRIVMSG #test :two\r\nPRIVMSG b :three\r\n"))
+	readLines(r2, 3)
+
+	t.Run("LATEST", func(t *testing.T) {
+		c2.Write([]byte("CHATHISTORY LATEST #test * 10\r\n"))
+		start, _ := r2.ReadString('\n')
+		one, _ := r2.ReadString('\n')
+		two, _ := r2.ReadString('\n')
+		end, _ := r2.ReadString('\n')
+
+		if !strings.HasPrefix(start, "BATCH +") || !strings.HasSuffix(start, " chathistory #test\r\n") {
+			t.Error("expected chathistory batch, got", start)
+		}
+		if !strings.HasSuffix(one, " :a!a@localhost PRIVMSG #test :one\r\n") || !strings.HasSuffix(two, " :a!a@localhost PRIVMSG #test :two\r\n") {
+			t.Error("history was not replayed in order, got", one, two)
+		}
+		if !strings.HasPrefix(end, "BATCH -") {
+			t.Error("expected end of batch, got", end)
+		}
+	})
+
+	t.Run("LATESTLimit", func(t *testing.T) {
+		c2.Write([]byte("CHATHISTORY LATEST #test * 1\r\n"))
+		resp, _ := readLines(r2, 2)
+		r2.ReadBytes('\n')
+		if !strings.HasSuffix(string(resp), " :a!a@localhost PRIVMSG #test :two\r\n") {
+			t.Error("expected only the latest message, got", string(resp))
+		}
+	})
+
+	t.Run("PrivateMessagesNotLoggedIn", func(t *testing.T) {
+		c2.Write([]byte("CHATHISTORY LATEST a * 10\r\n"))
+		resp, _ := r2.ReadBytes('\n')
+		assertResponse(resp, "FAIL CHATHISTORY INVALID_TARGET LATEST a :Private messages are only kept between users who are logged in\r\n", t)
+	})
+
+	t.Run("BEFORE", func(t *testing.T) {
+		c2.Write([]byte("CHATHISTORY BEFORE #test timestamp=2000-01-01T00:00:00.000Z 10\r\n"))
+		r2.ReadBytes('\n')
+		end, _ := r2.ReadString('\n')
+		if !strings.HasPrefix(end, "BATCH -") {
+			t.Error("expected empty batch, got", end)
+		}
+	})
+
+	t.Run("NotAMember", func(t *testing.T) {
+		c3, r3 := s.connectAndRegister("c")
+		defer c3.Close()
+
+		c3.Write([]byte("CHATHISTORY LATEST #test * 10\r\n"))
+		resp, _ := r3.ReadBytes('\n')
+		assertResponse(resp, "FAIL CHATHISTORY INVALID_TARGET LATEST #test :Messages could not be retrieved\r\n", t)
+	})
+}
+
+func TestCHATHISTORYPrivate(t *testing.T) {
+	t.Parallel()
+
+	s, err := New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	go s.Serve()
+
+	for _, account := range []string{"alice", "bob", "carol"} {
+		cred := plain.NewCredential(account, "pass")
+		s.persistPlain(cred.Username, account, cred.Pass)
+	}
+
+	alice, _ := s.connectAndAuthenticate("alice", "alice", "pass")
+	defer alice.Close()
+	bob, bobR := s.connectAndAuthenticate("bob", "bob", "pass")
+	defer bob.Close()
+
+	alice.Write([]byte("PRIVMSG bob :hi\r\n"))
+	bobR.ReadBytes('\n')
+
+	bob.Write([]byte("CAP REQ batch\r\n"))
+	bobR.ReadBytes('\n')
+	bob.Write([]byte("CHATHISTORY LATEST alice * 10\r\n"))
+	resp, _ := readLines(bobR, 2)
+	bobR.ReadBytes('\n')
+	if !strings.HasSuffix(string(resp), " :alice!alice@localhost PRIVMSG bob :hi\r\n") {
+		t.Error("expected private message, got", string(resp))
+	}
+
+	// the conversation belongs to the accounts of alice and bob, not to
+	// whoever is using their nicks
+	carol, carolR := s.connectAndAuthenticate("bob2", "carol", "pass")
+	defer carol.Close()
+	carol.Write([]byte("CAP REQ batch\r\n"))
+	carolR.ReadBytes('\n')
+	carol.Write([]byte("CHATHISTORY LATEST alice * 10\r\n"))
+	carolR.ReadBytes('\n')
+	end, _ := carolR.ReadString('\n')
+	if !strings.HasPrefix(end, "BATCH -") {
+		t.Error("expected empty batch, got", end)
+	}
+}
+
+func TestHistoryPruning(t *testing.T) {
+	t.Parallel()
+
+	s, err := New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	old := time.Now().AddDate(0, 0, -defaultHistoryDays-1).UnixMilli()
+	s.db.Exec("INSERT INTO history VALUES('old', '#test', 'a', '', ?, '')", old)
+	s.db.Exec("INSERT INTO history VALUES('new', '#test', 'a', '', ?, '')", time.Now().UnixMilli())
+	s.deleteOldHistory()
+
+	var msgids []string
+	rows, _ := s.db.Query("SELECT msgid FROM history")
+	for rows.Next() {
+		var id string
+		rows.Scan(&id)
+		msgids = append(msgids, id)
+	}
+	rows.Close()
+	if len(msgids) != 1 || msgids[0] != "new" {
+		t.Error("expected only recent history to be kept, got", msgids)
+	}
+}
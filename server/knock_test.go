@@ -0,0 +1,67 @@
+package server
+
+import "testing"
+
+func TestKNOCK(t *testing.T) {
+	t.Parallel()
+
+	s, err := New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	go s.Serve()
+
+	op, opR := s.connectAndRegister("alice")
+	defer op.Close()
+	op.Write([]byte("JOIN #invite\r\nMODE #invite +i\r\nJOIN #open\r\nJOIN #noknock\r\nMODE #noknock +iK\r\n"))
+	readLines(opR, 11)
+
+	c, r := s.connectAndRegister("bob")
+	defer c.Close()
+
+	t.Run("Open", func(t *testing.T) {
+		c.Write([]byte("KNOCK #open\r\n"))
+		resp, _ := r.ReadBytes('\n')
+		assertResponse(resp, prepMessage(ERR_CHANOPEN, s.Name, "bob", "#open").String(), t)
+	})
+
+	t.Run("NoKnock", func(t *testing.T) {
+		c.Write([]byte("KNOCK #noknock\r\n"))
+		resp, _ := r.ReadBytes('\n')
+		assertResponse(resp, prepMessage(ERR_CANNOTKNOCK, s.Name, "bob", "#noknock").String(), t)
+	})
+
+	t.Run("Knock", func(t *testing.T) {
+		c.Write([]byte("KNOCK #invite :let me in\r\n"))
+		resp, _ := r.ReadBytes('\n')
+		assertResponse(resp, prepMessage(RPL_KNOCKDLVR, s.Name, "bob", "#invite").String(), t)
+
+		knock, _ := opR.ReadBytes('\n')
+		assertResponse(knock, ":gossip 710 alice #invite bob!bob@localhost :has asked for an invite (let me in)\r\n", t)
+	})
+
+	t.Run("TooMany", func(t *testing.T) {
+		c.Write([]byte("KNOCK #invite\r\n"))
+		resp, _ := r.ReadBytes('\n')
+		assertResponse(resp, prepMessage(ERR_TOOMANYKNOCK, s.Name, "bob", "#invite").String(), t)
+	})
+
+	t.Run("Invited", func(t *testing.T) {
+		op.Write([]byte("INVITE bob #invite\r\n"))
+		opR.ReadBytes('\n')
+		r.ReadBytes('\n')
+
+		c.Write([]byte("JOIN #invite\r\n"))
+		join, _ := r.ReadBytes('\n')
+		assertResponse(join, ":bob!bob@localhost JOIN #invite\r\n", t)
+		readLines(r, 2)
+
+		c.Write([]byte("KNOCK #invite\r\n"))
+		resp, _ := r.ReadBytes('\n')
+		assertResponse(resp, prepMessage(ERR_KNOCKONCHAN, s.Name, "bob", "#invite").String(), t)
+
+		c.Write([]byte("PART #invite\r\n"))
+		r.ReadBytes('\n')
+	})
+}
@@ -0,0 +1,1022 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	cap "github.com/mitchr/gossip/capability"
+	"github.com/mitchr/gossip/channel"
+	"github.com/mitchr/gossip/client"
+	"github.com/mitchr/gossip/sasl"
+	"github.com/mitchr/gossip/scan"
+	"github.com/mitchr/gossip/scan/mode"
+	"github.com/mitchr/gossip/scan/msg"
+	"github.com/mitchr/gossip/util"
+)
+
+// Servers link together by exchanging a simple line based protocol that
+// uses the same message format as clients. State changes (UID, SJOIN,
+// JOIN, PART, KICK, MODE, NICK, QUIT, ...) are applied by every server
+// silently and forwarded to the rest of the network. Messages that are
+// meant to be seen by a user on another server are routed to that
+// server with DELIVER.
+//
+// Users on other servers are represented locally as normal Clients
+// whose connection forwards everything written to it over the link.
+
+const (
+	linkHandshakeTimeout = time.Second * 10
+	linkWriteTimeout     = time.Second * 5
+)
+
+var (
+	ErrLinkUnknown  = errors.New("no such server in links config")
+	ErrLinkExists   = errors.New("server is already linked")
+	ErrLinkPassword = errors.New("bad link password")
+)
+
+// a link is a direct connection to another server
+type link struct {
+	name string
+	conn net.Conn
+
+	writeLock sync.Mutex
+	reason    string
+
+	// every server that is reachable through this link, including the
+	// server on the other end of it
+	servers *util.SafeMap[string, linkedServer]
+}
+
+type linkedServer struct {
+	name string
+	hops int
+	info string
+}
+
+func (l *link) send(m msg.Msg) {
+	l.writeLock.Lock()
+	defer l.writeLock.Unlock()
+
+	l.conn.SetWriteDeadline(time.Now().Add(linkWriteTimeout))
+	l.conn.Write(m.Bytes())
+}
+
+// close sends reason to the other end of l and shuts it down.
+func (l *link) close(reason string) {
+	l.writeLock.Lock()
+	l.reason = reason
+	l.writeLock.Unlock()
+
+	l.send(msg.New(nil, "", "", "", "ERROR", []string{reason}, true))
+	l.conn.Close()
+}
+
+// remoteConn is the connection of a client that is connected to
+// another server. Every line that is written to it is sent over the
+// link with DELIVER.
+type remoteConn struct {
+	l *link
+	c *client.Client
+}
+
+func (r *remoteConn) Write(b []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(b), "\r\n"), "\r\n") {
+		r.l.send(msg.New(nil, "", "", "", "DELIVER", []string{r.c.Nick, line}, true))
+	}
+	return len(b), nil
+}
+
+func (r *remoteConn) Read([]byte) (int, error)         { return 0, net.ErrClosed }
+func (r *remoteConn) Close() error                     { return nil }
+func (r *remoteConn) LocalAddr() net.Addr              { return r.l.conn.LocalAddr() }
+func (r *remoteConn) RemoteAddr() net.Addr             { return remoteAddr{} }
+func (r *remoteConn) SetDeadline(time.Time) error      { return nil }
+func (r *remoteConn) SetReadDeadline(time.Time) error  { return nil }
+func (r *remoteConn) SetWriteDeadline(time.Time) error { return nil }
+
+type remoteAddr struct{}
+
+func (remoteAddr) Network() string { return "link" }
+func (remoteAddr) String() string  { return "" }
+
+func (s *Server) linkPeer(name string) (LinkPeer, bool) {
+	for _, v := range s.Links.Peers {
+		if strings.EqualFold(v.Name, name) {
+			return v, true
+		}
+	}
+	return LinkPeer{}, false
+}
+
+// serverKnown returns true if name is this server or any server that is
+// reachable through a link.
+func (s *Server) serverKnown(name string) bool {
+	if strings.EqualFold(name, s.Name) {
+		return true
+	}
+	for _, l := range s.links.All() {
+		if _, ok := l.servers.Get(strings.ToLower(name)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) serverCount() int {
+	n := 1
+	for _, l := range s.links.All() {
+		n += l.servers.Len()
+	}
+	return n
+}
+
+// propagate sends m to every link except from.
+func (s *Server) propagate(from *link, m msg.Msg) {
+	for _, l := range s.links.All() {
+		if l != from {
+			l.send(m)
+		}
+	}
+}
+
+// propagateChan is like propagate, but only sends m if ch is visible to
+// the rest of the network.
+func (s *Server) propagateChan(ch *channel.Channel, m msg.Msg) {
+	if ch.ChanType == channel.Remote {
+		s.propagate(nil, m)
+	}
+}
+
+func (s *Server) acceptLinks(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		} else if err != nil {
+			s.logger.Warn("could not accept link", "err", err)
+			continue
+		}
+		go s.handleLink(conn, nil)
+	}
+}
+
+// connectLink starts a link with the peer called name.
+func (s *Server) connectLink(name string) error {
+	peer, ok := s.linkPeer(name)
+	if !ok {
+		return ErrLinkUnknown
+	}
+	if s.serverKnown(peer.Name) {
+		return ErrLinkExists
+	}
+
+	dialer := &net.Dialer{Timeout: linkHandshakeTimeout}
+	var conn net.Conn
+	var err error
+	if s.Links.TLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", peer.Addr, peerTLSConfig(peer))
+	} else {
+		conn, err = dialer.Dial("tcp", peer.Addr)
+	}
+	if err != nil {
+		return err
+	}
+
+	go s.handleLink(conn, &peer)
+	return nil
+}
+
+func peerTLSConfig(peer LinkPeer) *tls.Config {
+	host, _, _ := net.SplitHostPort(peer.Addr)
+	conf := &tls.Config{ServerName: host}
+	if peer.Fingerprint != "" {
+		conf.InsecureSkipVerify = true
+		conf.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return errors.New("peer did not send a certificate")
+			}
+			sum := sha256.Sum256(rawCerts[0])
+			if !strings.EqualFold(hex.EncodeToString(sum[:]), peer.Fingerprint) {
+				return errors.New("peer certificate fingerprint does not match")
+			}
+			return nil
+		}
+	}
+	return conf
+}
+
+func (s *Server) sendIntro(conn net.Conn, password string) {
+	conn.SetWriteDeadline(time.Now().Add(linkWriteTimeout))
+	conn.Write(msg.Buffer{
+		msg.New(nil, "", "", "", "PASS", []string{password}, false),
+		msg.New(nil, "", "", "", "SERVER", []string{s.Name, s.Network}, true),
+	}.Bytes())
+}
+
+// readLinkMessage reads and parses one line from r. Lines that cannot
+// be parsed are returned as a nil message.
+func readLinkMessage(r *bufio.Reader) (*msg.Message, error) {
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(string(line), "\r\n") {
+		line = append(line[:len(line)-1], '\r', '\n')
+	}
+
+	p := &scan.Parser{Lexer: scan.Lex(line, msg.LexMessage)}
+	m, _ := msg.Parse(p)
+	return m, nil
+}
+
+// handleLink runs a link until it is closed. If outgoing is nil, the
+// other server initiated the link.
+func (s *Server) handleLink(conn net.Conn, outgoing *LinkPeer) {
+	r := bufio.NewReader(conn)
+
+	if outgoing != nil {
+		s.sendIntro(conn, outgoing.Password)
+	}
+
+	peer, info, err := s.linkHandshake(conn, r, outgoing)
+	if err != nil {
+		conn.Write(msg.New(nil, "", "", "", "ERROR", []string{err.Error()}, true).Bytes())
+		conn.Close()
+		s.logger.Warn("link handshake failed", "addr", conn.RemoteAddr().String(), "err", err)
+		return
+	}
+	if outgoing == nil {
+		s.sendIntro(conn, peer.Password)
+	}
+
+	l := &link{name: peer.Name, conn: conn, servers: util.NewSafeMap[string, linkedServer]()}
+	l.servers.Put(strings.ToLower(peer.Name), linkedServer{peer.Name, 1, info})
+	s.links.Put(strings.ToLower(peer.Name), l)
+
+	s.propagate(l, msg.New(nil, s.Name, "", "", "SERVER", []string{peer.Name, "1", info}, true))
+	s.burst(l)
+
+	for {
+		m, err := readLinkMessage(r)
+		if err != nil {
+			break
+		} else if m == nil {
+			continue
+		}
+		s.configLock.RLock()
+		if h, ok := linkHandlers[m.Command]; ok && h(s, l, m) {
+			s.propagate(l, m)
+		}
+		s.configLock.RUnlock()
+	}
+
+	l.writeLock.Lock()
+	reason := l.reason
+	l.writeLock.Unlock()
+	if reason == "" {
+		reason = "Connection closed"
+	}
+	s.netsplit(l, reason)
+}
+
+func (s *Server) linkHandshake(conn net.Conn, r *bufio.Reader, outgoing *LinkPeer) (LinkPeer, string, error) {
+	conn.SetReadDeadline(time.Now().Add(linkHandshakeTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	var pass, name, info string
+	for pass == "" || name == "" {
+		m, err := readLinkMessage(r)
+		if err != nil {
+			return LinkPeer{}, "", err
+		} else if m == nil {
+			continue
+		}
+
+		switch m.Command {
+		case "PASS":
+			if len(m.Params) > 0 {
+				pass = m.Params[0]
+			}
+		case "SERVER":
+			if len(m.Params) > 0 {
+				name = m.Params[0]
+			}
+			if len(m.Params) > 1 {
+				info = m.Params[len(m.Params)-1]
+			}
+		case "ERROR":
+			return LinkPeer{}, "", fmt.Errorf("%v", m.Params)
+		}
+	}
+
+	peer, ok := s.linkPeer(name)
+	if !ok || (outgoing != nil && !strings.EqualFold(outgoing.Name, name)) {
+		return LinkPeer{}, "", ErrLinkUnknown
+	}
+	if subtle.ConstantTimeCompare([]byte(peer.Password), []byte(pass)) != 1 {
+		return LinkPeer{}, "", ErrLinkPassword
+	}
+	if s.serverKnown(name) {
+		return LinkPeer{}, "", ErrLinkExists
+	}
+	return peer, info, nil
+}
+
+// burst sends the entire state of the network as this server sees it
+// to l.
+func (s *Server) burst(l *link) {
+	var buff msg.Buffer
+
+	for _, other := range s.links.All() {
+		if other == l {
+			continue
+		}
+		for _, v := range other.servers.All() {
+			buff.AddMsg(msg.New(nil, s.Name, "", "", "SERVER", []string{v.name, strconv.Itoa(v.hops), v.info}, true))
+		}
+	}
+
+	glines, _ := s.bans(gline)
+	for _, b := range glines {
+		buff.AddMsg(glineMessage(s.Name, b))
+	}
+
+	for _, c := range s.clients.All() {
+		buff.AddMsg(s.uid(c))
+		if c.Is(client.Away) {
+			buff.AddMsg(msg.New(nil, c.Nick, "", "", "AWAY", []string{c.AwayMsg}, true))
+		}
+	}
+
+	for _, ch := range s.channels.All() {
+		// empty registered channels are not shared
+		if ch.ChanType != channel.Remote || ch.Len() == 0 {
+			continue
+		}
+		buff.AddMsg(sjoin(ch))
+		if ch.Topic != "" {
+			buff.AddMsg(msg.New(nil, ch.TopicSetBy.String(), "", "", "TOPIC", []string{ch.String(), strconv.FormatInt(ch.TopicSetAt.Unix(), 10), ch.Topic}, true))
+		}
+	}
+
+	for _, m := range buff {
+		l.send(m)
+	}
+}
+
+// uid introduces c to another server.
+//
+// :<server> UID <nick> <signon> <user> <host> <account> <modes> :<realname>
+func (s *Server) uid(c *client.Client) *msg.Message {
+	server := c.Server
+	if server == "" {
+		server = s.Name
+	}
+	account := "*"
+	if c.IsAuthenticated {
+		account = c.SASLMech.Authn()
+	}
+
+	return msg.New(nil, server, "", "", "UID", []string{
+		c.Nick,
+		strconv.FormatInt(c.JoinTime, 10),
+		c.User,
+		c.Host,
+		account,
+		strconv.FormatUint(uint64(c.Mode), 10),
+		c.Realname,
+	}, true)
+}
+
+// umode shares the modes of c with other servers.
+//
+// :<nick> UMODE <modes>
+func umode(c *client.Client) *msg.Message {
+	return msg.New(nil, c.Nick, "", "", "UMODE", []string{strconv.FormatUint(uint64(c.Mode), 10)}, false)
+}
+
+// sjoin describes a channel and all of its members.
+//
+// SJOIN <ts> <channel> <modestr> [<params>...] :<members>
+func sjoin(ch *channel.Channel) *msg.Message {
+	modeStr, modeParams := burstModes(ch)
+
+	members := []string{}
+	for m := range ch.All() {
+		members = append(members, m.HighestPrefix(true)+m.Nick)
+	}
+
+	params := []string{strconv.FormatInt(ch.CreatedAt.Unix(), 10), ch.String(), modeStr}
+	params = append(params, modeParams...)
+	params = append(params, strings.Join(members, " "))
+	return msg.New(nil, "", "", "", "SJOIN", params, true)
+}
+
+// burstModes returns every mode that is set on ch, including its key
+// and lists.
+func burstModes(ch *channel.Channel) (string, []string) {
+	modeStr := "+"
+	params := []string{}
+
+	flags := []struct {
+		set  bool
+		char string
+	}{{ch.Invite, "i"}, {ch.Moderated, "m"}, {ch.Secret, "s"}, {ch.Protected, "t"}, {ch.NoExternal, "n"}, {ch.RegisteredOnly, "R"}, {ch.RegisteredModerated, "M"}, {ch.NoColors, "c"}, {ch.StripColors, "S"}, {ch.NoCTCP, "C"}, {ch.NoKnock, "K"}, {ch.FreeTarget, "F"}}
+	for _, f := range flags {
+		if f.set {
+			modeStr += f.char
+		}
+	}
+
+	if ch.Key != "" {
+		modeStr += "k"
+		params = append(params, ch.Key)
+	}
+	if ch.Limit != math.MaxInt {
+		modeStr += "l"
+		params = append(params, strconv.Itoa(ch.Limit))
+	}
+	if f := ch.FloodParam(); f != "" {
+		modeStr += "f"
+		params = append(params, f)
+	}
+	if j := ch.JoinThrottleParam(); j != "" {
+		modeStr += "j"
+		params = append(params, j)
+	}
+	if ch.Forward != "" {
+		modeStr += "L"
+		params = append(params, ch.Forward)
+	}
+
+	lists := []struct {
+		list []channel.ListEntry
+		char string
+	}{{ch.List('b'), "b"}, {ch.List('e'), "e"}, {ch.List('I'), "I"}}
+	for _, l := range lists {
+		for _, v := range l.list {
+			modeStr += l.char
+			params = append(params, v.Mask)
+		}
+	}
+	return modeStr, params
+}
+
+// applyModes silently applies a mode change that happened on another
+// server to ch.
+func applyModes(ch *channel.Channel, modeStr string, params []string) {
+	modes := mode.Parse([]byte(modeStr))
+	channel.PrepareModes(modes, params)
+	for _, m := range modes {
+		if m.Type != mode.List {
+			ch.ApplyMode(m)
+		}
+	}
+}
+
+// netsplit removes every server behind l, and all of their clients.
+func (s *Server) netsplit(l *link, reason string) {
+	s.links.Del(strings.ToLower(l.name))
+
+	quitReason := s.Name + " " + l.name
+	for _, v := range l.servers.All() {
+		s.splitServer(v.name, quitReason)
+		s.propagate(l, msg.New(nil, s.Name, "", "", "SQUIT", []string{v.name, reason}, true))
+	}
+}
+
+// splitServer removes every client connected to the server called name.
+func (s *Server) splitServer(name, reason string) {
+	var split []*client.Client
+	for _, c := range s.clients.All() {
+		if strings.EqualFold(c.Server, name) {
+			split = append(split, c)
+		}
+	}
+	for _, c := range split {
+		s.removeRemote(c, reason, true)
+	}
+}
+
+// removeRemote removes a client that is connected to another server. If
+// notify is true, members of its channels that are connected to this
+// server receive its QUIT.
+func (s *Server) removeRemote(c *client.Client, reason string, notify bool) {
+	s.whowasHistory.push(c.Nick, c.User, c.Host, c.Realname)
+	s.notify(c, prepMessage(RPL_MONOFFLINE, s.Name, "*", c.Id()), cap.None)
+
+	quit := msg.New(nil, c.String(), "", "", "QUIT", []string{reason}, true)
+	for _, ch := range slices.Collect(s.channelsOf(c)) {
+		ch.DeleteMember(c.Nick)
+		if ch.Len() == 0 {
+			s.deleteChannel(ch.String())
+		} else if notify {
+			s.writeLocal(ch, quit)
+		}
+	}
+
+	s.deleteClient(c.Nick)
+	c.Close()
+}
+
+// writeLocal writes m to every member of ch that is connected to this
+// server.
+func (s *Server) writeLocal(ch *channel.Channel, m msg.Msg) {
+	for member := range ch.All() {
+		if member.Server == "" {
+			member.WriteMessage(m)
+		}
+	}
+}
+
+// newRemoteClient creates a client for a user that is connected to
+// server, which is reachable through l.
+func (s *Server) newRemoteClient(l *link, server string, p []string) *client.Client {
+	conn := &remoteConn{l: l}
+	c, _, _ := client.New(conn, context.Background())
+	conn.c = c
+
+	c.Server = server
+	c.Nick = p[0]
+	c.JoinTime, _ = strconv.ParseInt(p[1], 10, 64)
+	c.User = p[2]
+	c.Host = p[3]
+	c.RealHost = c.Host
+	if p[4] != "*" {
+		c.SASLMech = sasl.Account(p[4])
+		c.IsAuthenticated = true
+	}
+	modes, _ := strconv.ParseUint(p[5], 10, 32)
+	c.SetMode(client.Mode(modes))
+	c.Realname = p[6]
+
+	// keep all tags so that the other server can decide which ones its
+	// clients should see
+	c.Caps[cap.MessageTags.Name] = true
+	c.Caps[cap.ServerTime.Name] = true
+	c.Caps[cap.AccountTag.Name] = true
+	return c
+}
+
+type linkHandler func(*Server, *link, *msg.Message) bool
+
+// linkHandlers apply messages received from other servers. A handler
+// returns true if the message should be forwarded to the rest of the
+// network.
+var linkHandlers = map[string]linkHandler{
+	"SERVER":  linkSERVER,
+	"SQUIT":   linkSQUIT,
+	"UID":     linkUID,
+	"NICK":    linkNICK,
+	"QUIT":    linkQUIT,
+	"UMODE":   linkUMODE,
+	"AWAY":    linkAWAY,
+	"SETNAME": linkSETNAME,
+	"SJOIN":   linkSJOIN,
+	"JOIN":    linkJOIN,
+	"PART":    linkPART,
+	"KICK":    linkKICK,
+	"MODE":    linkMODE,
+	"TOPIC":   linkTOPIC,
+	"INVITE":  linkINVITE,
+	"DELIVER": linkDELIVER,
+	"GLINE":   linkGLINE,
+	"UNGLINE": linkUNGLINE,
+	"CHGHOST": linkCHGHOST,
+}
+
+// :<via> SERVER <name> <hops> :<info>
+func linkSERVER(s *Server, l *link, m *msg.Message) bool {
+	if len(m.Params) < 3 {
+		return false
+	}
+
+	name := m.Params[0]
+	if s.serverKnown(name) {
+		// the network would have a loop in it
+		go l.close(ErrLinkExists.Error() + ": " + name)
+		return false
+	}
+
+	hops, _ := strconv.Atoi(m.Params[1])
+	l.servers.Put(strings.ToLower(name), linkedServer{name, hops + 1, m.Params[2]})
+
+	m.Params[1] = strconv.Itoa(hops + 1)
+	return true
+}
+
+// :<via> SQUIT <name> :<reason>
+func linkSQUIT(s *Server, l *link, m *msg.Message) bool {
+	if len(m.Params) < 1 {
+		return false
+	}
+
+	v, ok := l.servers.Get(strings.ToLower(m.Params[0]))
+	if !ok {
+		return false
+	}
+	l.servers.Del(strings.ToLower(v.name))
+	s.splitServer(v.name, m.Nick+" "+v.name)
+	return true
+}
+
+func linkUID(s *Server, l *link, m *msg.Message) bool {
+	if len(m.Params) < 7 {
+		return false
+	}
+
+	incomingTS, _ := strconv.ParseInt(m.Params[1], 10, 64)
+	if existing, ok := s.getClient(m.Params[0]); ok {
+		// nick collision; the oldest client keeps the nick, and if both
+		// clients are the same age, neither does
+		if existing.JoinTime >= incomingTS {
+			s.kill(existing, "Nick collision", l)
+		}
+		if existing.JoinTime <= incomingTS {
+			return false
+		}
+	}
+
+	c := s.newRemoteClient(l, m.Nick, m.Params)
+	s.setClient(c)
+	s.notify(c, prepMessage(RPL_MONONLINE, s.Name, "*", c), cap.None)
+	return true
+}
+
+// kill forcibly removes c from the network. The resulting QUIT is not
+// sent to from.
+func (s *Server) kill(c *client.Client, reason string, from *link) {
+	if c.Server == "" {
+		s.quit(c, reason, from)
+		return
+	}
+
+	s.removeRemote(c, reason, false)
+	s.propagate(from, msg.New(nil, c.Nick, "", "", "QUIT", []string{reason}, true))
+}
+
+// :<old> NICK <new>
+func linkNICK(s *Server, l *link, m *msg.Message) bool {
+	if len(m.Params) < 1 {
+		return false
+	}
+
+	c, ok := s.getClient(m.Nick)
+	if !ok {
+		return false
+	}
+
+	nick := m.Params[0]
+	if other, inUse := s.getClient(nick); inUse && other != c {
+		// both servers let a client take this nick at the same time
+		s.kill(c, "Nick collision", nil)
+		return false
+	}
+
+	for ch := range s.channelsOf(c) {
+		member, _ := ch.GetMember(c.Nick)
+		defer func(ch *channel.Channel, oldNick string) {
+			ch.DeleteMember(oldNick)
+			ch.SetMember(member)
+		}(ch, c.Nick)
+	}
+
+	s.notify(c, prepMessage(RPL_MONOFFLINE, s.Name, "*", c.Id()), cap.None)
+	s.deleteClient(c.Nick)
+	c.Nick = nick
+	s.setClient(c)
+	s.notify(c, prepMessage(RPL_MONONLINE, s.Name, "*", c), cap.None)
+	return true
+}
+
+// :<nick> QUIT :<reason>
+func linkQUIT(s *Server, l *link, m *msg.Message) bool {
+	c, ok := s.getClient(m.Nick)
+	if !ok {
+		return false
+	}
+
+	reason := ""
+	if len(m.Params) > 0 {
+		reason = m.Params[0]
+	}
+
+	// another server decided that one of our clients has to go
+	if c.Server == "" {
+		s.kill(c, reason, l)
+		return false
+	}
+
+	s.removeRemote(c, reason, false)
+	return true
+}
+
+// :<nick> UMODE <modes>
+func linkUMODE(s *Server, l *link, m *msg.Message) bool {
+	c, ok := s.getClient(m.Nick)
+	if !ok || len(m.Params) < 1 {
+		return false
+	}
+
+	modes, _ := strconv.ParseUint(m.Params[0], 10, 32)
+	c.UnsetMode(client.Mode(math.MaxUint32))
+	c.SetMode(client.Mode(modes))
+	return true
+}
+
+// :<nick> AWAY [:<message>]
+func linkAWAY(s *Server, l *link, m *msg.Message) bool {
+	c, ok := s.getClient(m.Nick)
+	if !ok {
+		return false
+	}
+
+	if len(m.Params) == 0 || m.Params[0] == "" {
+		c.AwayMsg = ""
+		c.UnsetMode(client.Away)
+	} else {
+		c.AwayMsg = m.Params[0]
+		c.SetMode(client.Away)
+	}
+	return true
+}
+
+// :<nick> SETNAME :<realname>
+func linkSETNAME(s *Server, l *link, m *msg.Message) bool {
+	c, ok := s.getClient(m.Nick)
+	if !ok || len(m.Params) < 1 {
+		return false
+	}
+	c.Realname = m.Params[0]
+	return true
+}
+
+// SJOIN <ts> <channel> <modestr> [<params>...] :<members>
+//
+// If both servers have the channel, the one that was created first
+// wins: its modes are kept, and the modes and member prefixes of the
+// other are dropped. If both were created at the same time, their modes
+// are merged.
+func linkSJOIN(s *Server, l *link, m *msg.Message) bool {
+	if len(m.Params) < 4 {
+		return false
+	}
+
+	ts, _ := strconv.ParseInt(m.Params[0], 10, 64)
+	name := m.Params[1]
+	if !isValidChannelString(name) || channel.ChanType(name[0]) != channel.Remote {
+		return false
+	}
+
+	s.joinLock.Lock()
+	ch, exists := s.getChannel(name)
+	if !exists {
+		ch = channel.New(name[1:], channel.ChanType(name[0]))
+		ch.CreatedAt = time.Unix(ts, 0)
+		s.setChannel(ch)
+	}
+	s.joinLock.Unlock()
+
+	keepTheirs := true
+	if ours := ch.CreatedAt.Unix(); exists && ts < ours {
+		ch.ResetModes()
+		ch.CreatedAt = time.Unix(ts, 0)
+	} else if exists && ts > ours {
+		keepTheirs = false
+	}
+
+	if keepTheirs {
+		applyModes(ch, m.Params[2], m.Params[3:len(m.Params)-1])
+	}
+
+	for _, v := range strings.Fields(m.Params[len(m.Params)-1]) {
+		member := &channel.Member{}
+		nick := strings.TrimLeftFunc(v, func(r rune) bool {
+			p, ok := channel.MemberPrefix[byte(r)]
+			if ok {
+				member.Prefix |= p
+			}
+			return ok
+		})
+
+		c, ok := s.getClient(nick)
+		if !ok {
+			continue
+		}
+		if _, alreadyMember := ch.GetMember(nick); alreadyMember {
+			continue
+		}
+		member.Client = c
+		if !keepTheirs {
+			member.Prefix = 0
+		}
+		ch.SetMember(member)
+		s.writeLocal(ch, msg.New(nil, c.Nick, c.User, c.Host, "JOIN", []string{ch.String()}, false))
+	}
+	return true
+}
+
+// :<nick> JOIN <channel>
+func linkJOIN(s *Server, l *link, m *msg.Message) bool {
+	c, ok := s.getClient(m.Nick)
+	if !ok || len(m.Params) < 1 {
+		return false
+	}
+
+	s.joinLock.Lock()
+	ch, exists := s.getChannel(m.Params[0])
+	if !exists {
+		if !isValidChannelString(m.Params[0]) {
+			s.joinLock.Unlock()
+			return false
+		}
+		ch = channel.New(m.Params[0][1:], channel.ChanType(m.Params[0][0]))
+		s.setChannel(ch)
+	}
+	s.joinLock.Unlock()
+
+	ch.SetMember(&channel.Member{Client: c})
+	return true
+}
+
+// :<nick> PART <channel>
+func linkPART(s *Server, l *link, m *msg.Message) bool {
+	if len(m.Params) < 1 {
+		return false
+	}
+	s.removeMember(m.Params[0], m.Nick)
+	return true
+}
+
+// :<nick> KICK <channel> <target> [:<comment>]
+func linkKICK(s *Server, l *link, m *msg.Message) bool {
+	if len(m.Params) < 2 {
+		return false
+	}
+	s.removeMember(m.Params[0], m.Params[1])
+	return true
+}
+
+func (s *Server) removeMember(chanName, nick string) {
+	ch, ok := s.getChannel(chanName)
+	if !ok {
+		return
+	}
+
+	ch.DeleteMember(nick)
+	if ch.Len() == 0 {
+		s.deleteChannel(ch.String())
+	}
+}
+
+// :<nick> MODE <channel> <modestr> [<params>...]
+func linkMODE(s *Server, l *link, m *msg.Message) bool {
+	if len(m.Params) < 2 {
+		return false
+	}
+
+	ch, ok := s.getChannel(m.Params[0])
+	if !ok {
+		return false
+	}
+	applyModes(ch, m.Params[1], m.Params[2:])
+	s.channelChanged(ch)
+	return true
+}
+
+// :<nick> TOPIC <channel> <ts> :<topic>
+func linkTOPIC(s *Server, l *link, m *msg.Message) bool {
+	if len(m.Params) < 3 {
+		return false
+	}
+
+	ch, ok := s.getChannel(m.Params[0])
+	if !ok {
+		return false
+	}
+
+	ts, _ := strconv.ParseInt(m.Params[1], 10, 64)
+	if ch.Topic != "" && ch.TopicSetAt.Unix() > ts {
+		// we already have a newer topic
+		return false
+	}
+
+	setBy, ok := s.getClient(m.Nick)
+	if !ok {
+		setBy = &client.Client{Nick: m.Nick, User: m.User, Host: m.Host}
+	}
+	ch.Topic = m.Params[2]
+	ch.TopicSetBy = setBy
+	ch.TopicSetAt = time.Unix(ts, 0)
+	s.channelChanged(ch)
+	return true
+}
+
+// :<nick> INVITE <target> <channel>
+func linkINVITE(s *Server, l *link, m *msg.Message) bool {
+	if len(m.Params) < 2 {
+		return false
+	}
+
+	ch, ok := s.getChannel(m.Params[1])
+	if !ok {
+		return false
+	}
+	ch.Invited = append(ch.Invited, m.Params[0])
+	return true
+}
+
+// DELIVER <nick> :<message>
+//
+// DELIVER is never forwarded as is; if nick is on another server, the
+// message is routed towards it through nick's connection.
+func linkDELIVER(s *Server, l *link, m *msg.Message) bool {
+	if len(m.Params) < 2 {
+		return false
+	}
+
+	target, ok := s.getClient(m.Params[0])
+	if !ok {
+		return false
+	}
+
+	p := &scan.Parser{Lexer: scan.Lex([]byte(m.Params[1]+"\r\n"), msg.LexMessage)}
+	out, err := msg.Parse(p)
+	if err != nil {
+		return false
+	}
+
+	if target.Server == "" && !target.HasMessageTags() {
+		target.WriteMessage(out.RemoveAllTags())
+	} else {
+		target.WriteMessage(out)
+	}
+	return false
+}
+
+// CONNECT <server>
+func CONNECT(s *Server, c *client.Client, m *msg.Message) msg.Msg {
+	if !s.hasPrivilege(c, privRouting) {
+		return prepMessage(ERR_NOPRIVILEGES, s.Name, c.Id())
+	}
+	if len(m.Params) < 1 {
+		return prepMessage(ERR_NEEDMOREPARAMS, s.Name, c.Id(), "CONNECT")
+	}
+
+	err := s.connectLink(m.Params[0])
+	if errors.Is(err, ErrLinkUnknown) {
+		return prepMessage(ERR_NOSUCHSERVER, s.Name, c.Id(), m.Params[0])
+	} else if err != nil {
+		return s.NOTICE(c, "CONNECT "+m.Params[0]+": "+err.Error())
+	}
+	return s.NOTICE(c, "Connecting to "+m.Params[0])
+}
+
+// SQUIT <server> [:<comment>]
+func SQUIT(s *Server, c *client.Client, m *msg.Message) msg.Msg {
+	if !s.hasPrivilege(c, privRouting) {
+		return prepMessage(ERR_NOPRIVILEGES, s.Name, c.Id())
+	}
+	if len(m.Params) < 1 {
+		return prepMessage(ERR_NEEDMOREPARAMS, s.Name, c.Id(), "SQUIT")
+	}
+
+	l, ok := s.links.Get(strings.ToLower(m.Params[0]))
+	if !ok {
+		return prepMessage(ERR_NOSUCHSERVER, s.Name, c.Id(), m.Params[0])
+	}
+
+	reason := c.Nick + " used SQUIT"
+	if len(m.Params) > 1 {
+		reason = m.Params[1]
+	}
+	l.close(reason)
+	return nil
+}
+
+// LINKS
+func LINKS(s *Server, c *client.Client, m *msg.Message) msg.Msg {
+	buff := msg.Buffer{prepMessage(RPL_LINKS, s.Name, c.Id(), s.Name, 0, s.Network)}
+	for _, l := range s.links.All() {
+		for _, v := range l.servers.All() {
+			buff.AddMsg(prepMessage(RPL_LINKS, s.Name, c.Id(), v.name, v.hops, v.info))
+		}
+	}
+	buff.AddMsg(prepMessage(RPL_ENDOFLINKS, s.Name, c.Id()))
+	return buff
+}
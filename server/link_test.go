@@ -0,0 +1,116 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLink(t *testing.T) {
+	t.Parallel()
+
+	confOne := &Config{Name: "one", Network: "testnet", Port: ":0"}
+	confOne.Cloak.Disabled = true
+	confOne.Links.Port = ":0"
+	confOne.Links.Peers = []LinkPeer{{Name: "two", Password: "linkpass"}}
+	one, err := New(confOne)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer one.Close()
+	go one.Serve()
+
+	confTwo := &Config{Name: "two", Network: "testnet", Port: ":0"}
+	confTwo.Cloak.Disabled = true
+	confTwo.Links.Peers = []LinkPeer{{Name: "one", Addr: one.linkListener.Addr().String(), Password: "linkpass"}}
+	two, err := New(confTwo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer two.Close()
+	go two.Serve()
+
+	alice, aliceR := one.connectAndRegister("alice")
+	defer alice.Close()
+	alice.Write([]byte("JOIN #test\r\n"))
+	readLines(aliceR, 3)
+
+	if err := two.connectLink("one"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		_, ok := two.getChannel("#test")
+		return ok
+	})
+
+	bob, bobR := two.connectAndRegister("bob")
+	defer bob.Close()
+
+	t.Run("JOIN", func(t *testing.T) {
+		bob.Write([]byte("JOIN #test\r\n"))
+		readLines(bobR, 3)
+
+		resp, _ := aliceR.ReadBytes('\n')
+		assertResponse(resp, ":bob!bob@localhost JOIN #test\r\n", t)
+	})
+
+	t.Run("PRIVMSG", func(t *testing.T) {
+		bob.Write([]byte("PRIVMSG #test :hello\r\n"))
+		resp, _ := aliceR.ReadBytes('\n')
+		assertResponse(resp, ":bob!bob@localhost PRIVMSG #test :hello\r\n", t)
+
+		alice.Write([]byte("PRIVMSG bob :hi\r\n"))
+		resp, _ = bobR.ReadBytes('\n')
+		assertResponse(resp, ":alice!alice@localhost PRIVMSG bob :hi\r\n", t)
+	})
+
+	t.Run("WHOX", func(t *testing.T) {
+		alice.Write([]byte("WHO bob %ni\r\n"))
+		resp, _ := aliceR.ReadBytes('\n')
+		assertResponse(resp, ":one 354 alice 255.255.255.255 bob\r\n", t)
+		aliceR.ReadBytes('\n')
+	})
+
+	t.Run("NICK", func(t *testing.T) {
+		alice.Write([]byte("NICK alice2\r\n"))
+		aliceR.ReadBytes('\n')
+
+		resp, _ := bobR.ReadBytes('\n')
+		assertResponse(resp, ":alice!alice@localhost NICK alice2\r\n", t)
+		waitFor(t, func() bool {
+			_, ok := two.getClient("alice2")
+			return ok
+		})
+	})
+
+	t.Run("LINKS", func(t *testing.T) {
+		alice.Write([]byte("LINKS\r\n"))
+		resp, _ := readLines(aliceR, 2)
+		assertResponse(resp, prepMessage(RPL_LINKS, one.Name, "alice2", "two", 1, "testnet").String(), t)
+		resp, _ = aliceR.ReadBytes('\n')
+		assertResponse(resp, prepMessage(RPL_ENDOFLINKS, one.Name, "alice2").String(), t)
+	})
+
+	t.Run("Netsplit", func(t *testing.T) {
+		l, _ := two.links.Get("one")
+		l.close("test split")
+
+		resp, _ := aliceR.ReadBytes('\n')
+		assertResponse(resp, ":bob!bob@localhost QUIT :one two\r\n", t)
+		resp, _ = bobR.ReadBytes('\n')
+		assertResponse(resp, ":alice2!alice@localhost QUIT :two one\r\n", t)
+	})
+}
+
+// waitFor polls cond until it is true, failing t if that takes too
+// long.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+
+	for i := 0; i < 100; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+	t.Fatal("condition was never met")
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/mitchr/gossip/util"
+)
+
+// newLogger creates the logger that is described by c.Log. If logs are
+// written to a file, it is returned so that it can be closed along with
+// the server.
+func (c *Config) newLogger() (*slog.Logger, io.Closer, error) {
+	level := slog.LevelInfo
+	if c.Log.Level != "" {
+		if err := level.UnmarshalText([]byte(c.Log.Level)); err != nil {
+			return nil, nil, err
+		}
+	}
+	if c.Debug {
+		level = slog.LevelDebug
+	}
+
+	var w io.Writer = os.Stderr
+	var f io.Closer
+	if c.Log.File != "" {
+		r, err := util.OpenRotatingFile(c.Log.File, int64(c.Log.MaxSize)<<20, c.Log.MaxBackups)
+		if err != nil {
+			return nil, nil, err
+		}
+		w, f = r, r
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	switch c.Log.Format {
+	case "", "text":
+		return slog.New(slog.NewTextHandler(w, opts)), f, nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), f, nil
+	default:
+		if f != nil {
+			f.Close()
+		}
+		return nil, nil, errors.New("unknown log format " + c.Log.Format)
+	}
+}
+
+// Logger returns the logger that s writes to.
+func (s *Server) Logger() *slog.Logger { return s.logger }
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLogging(t *testing.T) {
+	t.Parallel()
+
+	conf := &Config{Name: "gossip", Port: ":0"}
+	conf.Cloak.Disabled = true
+	conf.Log.Format = "json"
+	conf.Log.Level = "debug"
+	conf.Log.File = filepath.Join(t.TempDir(), "gossip.log")
+
+	s, err := New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve()
+
+	c, r := s.connectAndRegister("alice")
+	c.Write([]byte("JOIN #test\r\n"))
+	readLines(r, 3)
+	c.Close()
+	s.Close()
+
+	f, err := os.Open(conf.Log.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	type record struct {
+		Level   string
+		Msg     string
+		Message string
+		Client  struct {
+			Conn uint64
+			Addr string
+			Nick string
+		}
+	}
+	var join record
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var rec record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Msg == "received message" && rec.Message == "JOIN #test" {
+			join = rec
+		}
+	}
+
+	if join.Level != "DEBUG" {
+		t.Fatal("expected JOIN to be logged")
+	}
+	if join.Client.Conn == 0 || join.Client.Addr == "" || join.Client.Nick != "alice" {
+		t.Error("expected connection attributes, got", join.Client)
+	}
+}
+
+func TestLogFormat(t *testing.T) {
+	conf := &Config{}
+	conf.Log.Format = "xml"
+	if _, _, err := conf.newLogger(); err == nil {
+		t.Error("expected unknown format to be rejected")
+	}
+
+	conf.Log.Format = "text"
+	conf.Log.Level = "loud"
+	if _, _, err := conf.newLogger(); err == nil {
+		t.Error("expected unknown level to be rejected")
+	}
+}
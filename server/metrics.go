@@ -0,0 +1,143 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+
+	"github.com/mitchr/gossip/client"
+)
+
+// metrics are counters that are exposed in the Prometheus text format
+// at /metrics.
+type metrics struct {
+	bytesIn, bytesOut statistic
+
+	floodDisconnects statistic
+	pingTimeouts     statistic
+	// writes to a client's connection that failed
+	writeErrors statistic
+
+	mu sync.Mutex
+	// command name to the number of times it was executed
+	commands map[string]uint64
+	// mechanism name to the number of successful and failed attempts
+	sasl map[string]*saslCount
+}
+
+type saslCount struct{ success, failure uint64 }
+
+func (m *metrics) command(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.commands == nil {
+		m.commands = make(map[string]uint64)
+	}
+	m.commands[name]++
+}
+
+func (m *metrics) saslAttempt(mech string, success bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.sasl == nil {
+		m.sasl = make(map[string]*saslCount)
+	}
+	count, ok := m.sasl[mech]
+	if !ok {
+		count = &saslCount{}
+		m.sasl[mech] = count
+	}
+	if success {
+		count.success++
+	} else {
+		count.failure++
+	}
+}
+
+// meteredConn counts the bytes that are read from and written to a
+// client's connection.
+type meteredConn struct {
+	net.Conn
+	m *metrics
+}
+
+func (c meteredConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.m.bytesIn.Add(uint64(n))
+	return n, err
+}
+
+func (c meteredConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.m.bytesOut.Add(uint64(n))
+	if err != nil {
+		c.m.writeErrors.Inc()
+	}
+	return n, err
+}
+
+// NetConn returns the underlying connection, so that the client can
+// still find out if it is connected over tls.
+func (c meteredConn) NetConn() net.Conn { return c.Conn }
+
+func (s *Server) serveMetrics(l net.Listener) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", s.writeMetrics)
+
+	serveHTTP(l, mux)
+}
+
+func (s *Server) writeMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	registered, opers := 0, 0
+	for _, c := range s.clients.All() {
+		if c.Server != "" {
+			continue
+		}
+		registered++
+		if c.Is(client.Op) {
+			opers++
+		}
+	}
+
+	writeMetric(w, "gossip_clients", "gauge", "Registered clients connected to this server.", registered)
+	writeMetric(w, "gossip_unregistered_clients", "gauge", "Connections that have not registered yet.", s.unknowns.Get())
+	writeMetric(w, "gossip_opers", "gauge", "Operators connected to this server.", opers)
+	writeMetric(w, "gossip_channels", "gauge", "Channels on the network.", s.channelLen())
+	writeMetric(w, "gossip_received_bytes_total", "counter", "Bytes read from client connections.", s.metrics.bytesIn.Get())
+	writeMetric(w, "gossip_sent_bytes_total", "counter", "Bytes written to client connections.", s.metrics.bytesOut.Get())
+	writeMetric(w, "gossip_flood_disconnects_total", "counter", "Clients that were disconnected for flooding.", s.metrics.floodDisconnects.Get())
+	writeMetric(w, "gossip_ping_timeouts_total", "counter", "Clients that were disconnected for not answering a PING.", s.metrics.pingTimeouts.Get())
+	writeMetric(w, "gossip_write_errors_total", "counter", "Writes to a client connection that failed.", s.metrics.writeErrors.Get())
+
+	s.metrics.mu.Lock()
+	defer s.metrics.mu.Unlock()
+
+	writeHeader(w, "gossip_messages_total", "counter", "Messages executed, by command.")
+	for _, cmd := range slices.Sorted(maps.Keys(s.metrics.commands)) {
+		fmt.Fprintf(w, "gossip_messages_total{command=%q} %d\n", cmd, s.metrics.commands[cmd])
+	}
+
+	writeHeader(w, "gossip_sasl_attempts_total", "counter", "SASL authentication attempts, by mechanism and result.")
+	for _, mech := range slices.Sorted(maps.Keys(s.metrics.sasl)) {
+		count := s.metrics.sasl[mech]
+		fmt.Fprintf(w, "gossip_sasl_attempts_total{mechanism=%q,result=\"success\"} %d\n", mech, count.success)
+		fmt.Fprintf(w, "gossip_sasl_attempts_total{mechanism=%q,result=\"failure\"} %d\n", mech, count.failure)
+	}
+}
+
+func writeHeader(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func writeMetric[T int | uint64](w io.Writer, name, kind, help string, v T) {
+	writeHeader(w, name, kind, help)
+	fmt.Fprintf(w, "%s %d\n", name, v)
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"encoding/base64"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	t.Parallel()
+
+	conf := &Config{Name: "gossip", Port: ":0"}
+	conf.Cloak.Disabled = true
+	conf.Metrics.Addr = "localhost:0"
+
+	s, err := New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	go s.Serve()
+
+	c, r := s.connectAndRegister("alice")
+	defer c.Close()
+	c.Write([]byte("JOIN #test\r\n"))
+	readLines(r, 3)
+
+	c.Write([]byte("AUTHENTICATE PLAIN\r\n"))
+	r.ReadBytes('\n')
+	c.Write([]byte("AUTHENTICATE " + base64.StdEncoding.EncodeToString([]byte("\000alice\000wrong")) + "\r\n"))
+	r.ReadBytes('\n')
+
+	resp, err := http.Get("http://" + s.metricsListener.Addr().String() + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	for _, line := range []string{
+		"# TYPE gossip_clients gauge\n",
+		"gossip_clients 1\n",
+		"gossip_unregistered_clients 0\n",
+		"gossip_channels 1\n",
+		`gossip_messages_total{command="JOIN"} 1` + "\n",
+		`gossip_messages_total{command="AUTHENTICATE"} 2` + "\n",
+		`gossip_sasl_attempts_total{mechanism="PLAIN",result="failure"} 1` + "\n",
+		`gossip_sasl_attempts_total{mechanism="PLAIN",result="success"} 0` + "\n",
+		"gossip_write_errors_total 0\n",
+	} {
+		if !strings.Contains(string(body), line) {
+			t.Errorf("expected %q in\n%s", line, body)
+		}
+	}
+	if strings.Contains(string(body), "gossip_received_bytes_total 0\n") {
+		t.Error("expected bytes to be counted")
+	}
+}
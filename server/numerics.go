@@ -1,6 +1,7 @@
 package server
 
 import (
+	"strconv"
 	"strings"
 
 	"github.com/mitchr/gossip/channel"
@@ -14,7 +15,11 @@ var (
 	RPL_CREATED          = msg.New(nil, "", "", "", "003", []string{"%s", "This server was created %s"}, true)
 	RPL_MYINFO           = msg.New(nil, "", "", "", "004", []string{"%s", "%s", "%s", "%s", "%s"}, false)
 	RPL_ISUPPORT         = msg.New(nil, "", "", "", "005", []string{"%s", "%s", "are supported by this server"}, true)
+	RPL_SNOMASK          = msg.New(nil, "", "", "", "008", []string{"%s", "%s", "Server notice mask"}, true)
+	RPL_STATSKLINE       = msg.New(nil, "", "", "", "216", []string{"%s", "%s", "%s", "%s", "%s", "%s"}, true)
+	RPL_ENDOFSTATS       = msg.New(nil, "", "", "", "219", []string{"%s", "%s", "End of /STATS report"}, true)
 	RPL_UMODEIS          = msg.New(nil, "", "", "", "221", []string{"%s", "%s"}, false)
+	RPL_STATSDLINE       = msg.New(nil, "", "", "", "225", []string{"%s", "%s", "%s", "%s", "%s", "%s"}, true)
 	RPL_LUSERCLIENT      = msg.New(nil, "", "", "", "251", []string{"%s", "There are %d users and %d invisible on %d servers"}, true)
 	RPL_LUSEROP          = msg.New(nil, "", "", "", "252", []string{"%s", "%d", "operator(s) online"}, true)
 	RPL_LUSERUNKNOWN     = msg.New(nil, "", "", "", "253", []string{"%s", "%d", "unknown connection(s)"}, true)
@@ -47,17 +52,20 @@ var (
 	RPL_INVITELIST       = msg.New(nil, "", "", "", "336", []string{"%s", "%s"}, false)
 	RPL_ENDOFINVITELIST  = msg.New(nil, "", "", "", "337", []string{"%s", "End of /INVITE list"}, true)
 	RPL_INVITING         = msg.New(nil, "", "", "", "341", []string{"%s", "%s", "%s"}, false)
-	RPL_INVEXLIST        = msg.New(nil, "", "", "", "346", []string{"%s", "%s", "%s"}, false)
+	RPL_INVEXLIST        = msg.New(nil, "", "", "", "346", []string{"%s", "%s", "%s", "%s", "%d"}, false)
 	RPL_ENFOFINVEXLIST   = msg.New(nil, "", "", "", "347", []string{"%s", "%s", "End of channel invite list"}, true)
-	RPL_EXCEPTLIST       = msg.New(nil, "", "", "", "348", []string{"%s", "%s", "%s"}, false)
+	RPL_EXCEPTLIST       = msg.New(nil, "", "", "", "348", []string{"%s", "%s", "%s", "%s", "%d"}, false)
 	RPL_ENDOFEXCEPTLIST  = msg.New(nil, "", "", "", "349", []string{"%s", "%s", "End of channel exception list"}, true)
 	RPL_WHOREPLY         = msg.New(nil, "", "", "", "352", []string{"%s", "%s", "%s", "%s", "%s", "%s", "%s", "0 %s"}, true)
 	RPL_NAMREPLY         = msg.New(nil, "", "", "", "353", []string{"%s", "%s", "%s", "%s"}, true)
 	RPL_WHOSPCRPL        = msg.New(nil, "", "", "", "354", []string{"%s", "%s"}, false)
+	RPL_LINKS            = msg.New(nil, "", "", "", "364", []string{"%s", "*", "%s", "%d %s"}, true)
+	RPL_ENDOFLINKS       = msg.New(nil, "", "", "", "365", []string{"%s", "*", "End of /LINKS list"}, true)
 	RPL_ENDOFNAMES       = msg.New(nil, "", "", "", "366", []string{"%s", "%s", "End of /NAMES list"}, true)
-	RPL_BANLIST          = msg.New(nil, "", "", "", "367", []string{"%s", "%s", "%s"}, false)
+	RPL_BANLIST          = msg.New(nil, "", "", "", "367", []string{"%s", "%s", "%s", "%s", "%d"}, false)
 	RPL_ENDOFBANLIST     = msg.New(nil, "", "", "", "368", []string{"%s", "%s", "End of channel ban list"}, true)
 	RPL_ENDOFWHOWAS      = msg.New(nil, "", "", "", "369", []string{"%s", "%s", "End of WHOWAS"}, true)
+	RPL_WHOISHOST        = msg.New(nil, "", "", "", "378", []string{"%s", "%s", "is connecting from *@%s"}, true)
 	RPL_MOTDSTART        = msg.New(nil, "", "", "", "375", []string{"%s", "- %s Message of the Day -"}, true)
 	RPL_INFO             = msg.New(nil, "", "", "", "371", []string{"%s", "%s"}, true)
 	RPL_MOTD             = msg.New(nil, "", "", "", "372", []string{"%s", "%s"}, true)
@@ -66,7 +74,9 @@ var (
 	RPL_YOUREOPER        = msg.New(nil, "", "", "", "381", []string{"%s", "You are now an IRC operator"}, true)
 	RPL_REHASHING        = msg.New(nil, "", "", "", "382", []string{"%s", "%s", "Rehashing"}, true)
 	RPL_TIME             = msg.New(nil, "", "", "", "391", []string{"%s", "%s", "%s"}, true)
+	RPL_HOSTHIDDEN       = msg.New(nil, "", "", "", "396", []string{"%s", "%s", "is now your displayed host"}, true)
 	ERR_NOSUCHNICK       = msg.New(nil, "", "", "", "401", []string{"%s", "%s", "No such nick/channel"}, true)
+	ERR_NOSUCHSERVER     = msg.New(nil, "", "", "", "402", []string{"%s", "%s", "No such server"}, true)
 	ERR_NOSUCHCHANNEL    = msg.New(nil, "", "", "", "403", []string{"%s", "%s", "No such channel"}, true)
 	ERR_CANNOTSENDTOCHAN = msg.New(nil, "", "", "", "404", []string{"%s", "%s", "Cannot send to channel"}, true)
 	ERR_WASNOSUCHNICK    = msg.New(nil, "", "", "", "406", []string{"%s", "%s", "There was no such nickname"}, true)
@@ -86,17 +96,31 @@ var (
 	ERR_NEEDMOREPARAMS   = msg.New(nil, "", "", "", "461", []string{"%s", "%s", "Not enough parameters"}, true)
 	ERR_ALREADYREGISTRED = msg.New(nil, "", "", "", "462", []string{"%s", "You may not reregister"}, true)
 	ERR_PASSWDMISMATCH   = msg.New(nil, "", "", "", "464", []string{"%s", "Password Incorrect"}, true)
+	ERR_YOUREBANNEDCREEP = msg.New(nil, "", "", "", "465", []string{"%s", "You are banned from this server: %s"}, true)
+	ERR_LINKCHANNEL      = msg.New(nil, "", "", "", "470", []string{"%s", "%s", "%s", "Forwarding to another channel"}, true)
 	ERR_CHANNELISFULL    = msg.New(nil, "", "", "", "471", []string{"%s", "%s", "Cannot join channel (+l)"}, true)
 	ERR_UNKNOWNMODE      = msg.New(nil, "", "", "", "472", []string{"%s", "%s", "is unknown mode char to me for %s"}, true)
 	ERR_INVITEONLYCHAN   = msg.New(nil, "", "", "", "473", []string{"%s", "%s", "Cannot join channel (+i)"}, true)
 	ERR_BANNEDFROMCHAN   = msg.New(nil, "", "", "", "474", []string{"%s", "%s", "Cannot join channel (+b)"}, true)
 	ERR_BADCHANNELKEY    = msg.New(nil, "", "", "", "475", []string{"%s", "%s", "Cannot join channel (+k)"}, true)
+	ERR_NEEDREGGEDNICK   = msg.New(nil, "", "", "", "477", []string{"%s", "%s", "Cannot join channel (+R) - you need to be logged in"}, true)
+	ERR_BANLISTFULL      = msg.New(nil, "", "", "", "478", []string{"%s", "%s", "%s", "Channel list is full"}, true)
+	ERR_THROTTLE         = msg.New(nil, "", "", "", "480", []string{"%s", "%s", "Cannot join channel (+j)"}, true)
+	ERR_CANNOTKNOCK      = msg.New(nil, "", "", "", "480", []string{"%s", "%s", "Cannot knock on channel (+K)"}, true)
 	ERR_NOPRIVILEGES     = msg.New(nil, "", "", "", "481", []string{"%s", "Permission Denied - You're not an IRC operator"}, true)
 	ERR_CHANOPRIVSNEEDED = msg.New(nil, "", "", "", "482", []string{"%s", "%s", "You're not a channel operator"}, true)
+	ERR_NONONREG         = msg.New(nil, "", "", "", "486", []string{"%s", "%s", "You must be logged in to message this user"}, true)
+	ERR_NOOPERHOST       = msg.New(nil, "", "", "", "491", []string{"%s", "No O-lines for your host"}, true)
 	ERR_UMODEUNKNOWNFLAG = msg.New(nil, "", "", "", "501", []string{"%s", "Unknown MODE flag"}, true)
 	ERR_USERSDONTMATCH   = msg.New(nil, "", "", "", "502", []string{"%s", "Can't change mode for other users"}, true)
 	ERR_INVALIDKEY       = msg.New(nil, "", "", "", "525", []string{"%s", "%s", "Key is not well-formed"}, true)
 	RPL_WHOISSECURE      = msg.New(nil, "", "", "", "671", []string{"%s", "%s", "is using a secure connection"}, true)
+	ERR_INVALIDMODEPARAM = msg.New(nil, "", "", "", "696", []string{"%s", "%s", "%s", "%s", "Invalid mode parameter"}, true)
+	RPL_KNOCK            = msg.New(nil, "", "", "", "710", []string{"%s", "%s", "%s", "has asked for an invite%s"}, true)
+	RPL_KNOCKDLVR        = msg.New(nil, "", "", "", "711", []string{"%s", "%s", "Your KNOCK has been delivered"}, true)
+	ERR_TOOMANYKNOCK     = msg.New(nil, "", "", "", "712", []string{"%s", "%s", "Too many KNOCKs (channel)"}, true)
+	ERR_CHANOPEN         = msg.New(nil, "", "", "", "713", []string{"%s", "%s", "Channel is open"}, true)
+	ERR_KNOCKONCHAN      = msg.New(nil, "", "", "", "714", []string{"%s", "%s", "You are already on that channel"}, true)
 	RPL_MONONLINE        = msg.New(nil, "", "", "", "730", []string{"%s", "%s"}, true)
 	RPL_MONOFFLINE       = msg.New(nil, "", "", "", "731", []string{"%s", "%s"}, true)
 	RPL_MONLIST          = msg.New(nil, "", "", "", "732", []string{"%s", "%s"}, true)
@@ -128,6 +152,11 @@ func (s *Server) ERROR(c *client.Client, m string) {
 	s.deleteClient(c.Nick)
 
 	c.WriteMessage(msg.New(nil, "", "", "", "ERROR", []string{m}, true))
+	// sessions can't outlive the client that they are attached to
+	for _, session := range c.Sessions() {
+		c.Detach(session, true)
+		session.Close()
+	}
 	c.Close()
 }
 
@@ -166,16 +195,24 @@ func constructNAMREPLY(ch *channel.Channel, invisibles bool, multiPrefix bool, u
 }
 
 var isupportTokens = func() []string {
-	supported := [20]string{
+	supported := [25]string{
 		"BOT=b",
 		"CASEMAPPING=ascii",
 		"CHANLIMIT=#&:",
-		"CHANMODES=beI,k,l,imnst",
+		"CHANMODES=beI,k,fjlL,imnstCFKMRSc",
+		"CHATHISTORY=" + strconv.Itoa(chathistoryLimit),
 		"ELIST=CMNTU",
+		"EXTBAN=$," + channel.ExtbanTypes,
 		"INVEX=I",
+		"KNOCK",
+		"MAXLIST=beI:" + strconv.Itoa(channel.MaxListEntries),
 		"MONITOR", // TODO: add a limit?
+		"MSGREFTYPES=msgid,timestamp",
 		"STATUSMSG=~&@%+",
 		"PREFIX=(qaohv)~&@%+",
+		// private messages are only kept between clients that are both
+		// logged in, and are looked up by account
+		"PRIVATEHISTORY=account",
 		"WHOX",
 		"UTF8ONLY",
 		"TARGMAX=KICK:,NAMES:,PRIVMSG:,WHOIS:,WHOWAS:",
@@ -185,7 +222,6 @@ var isupportTokens = func() []string {
 		"CHANNELLEN=64",
 		"HOSTLEN=64",
 		"KICKLEN=200",
-		"MAXLIST=beI:25",
 		"NICKLEN=30",
 		"TOPICLEN=307",
 		"USERLEN=18",
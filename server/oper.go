@@ -0,0 +1,95 @@
+package server
+
+import (
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/mitchr/gossip/client"
+	"github.com/mitchr/gossip/scan/wild"
+)
+
+// A privilege allows an operator to use a set of privileged commands.
+type privilege string
+
+const (
+	// KILL
+	privKill privilege = "kill"
+	// KLINE, DLINE, GLINE, their removal, and listing them with STATS
+	privBan privilege = "ban"
+	// REHASH
+	privRehash privilege = "rehash"
+	// WALLOPS
+	privWallops privilege = "wallops"
+	// CONNECT and SQUIT
+	privRouting privilege = "routing"
+	// act as a channel operator in any channel
+	privOverride privilege = "override"
+	// see certificate fingerprints, real hosts, and secret channels in
+	// WHOIS
+	privSeeHidden privilege = "see-hidden"
+)
+
+var privileges = []privilege{privKill, privBan, privRehash, privWallops, privRouting, privOverride, privSeeHidden}
+
+var (
+	ErrUnknownPrivilege = errors.New("unknown operator privilege")
+	ErrUnknownOperClass = errors.New("unknown operator class")
+)
+
+// validateOperClasses checks that every operator class only uses known
+// privileges, and that every operator is given a class that exists.
+func (c *Config) validateOperClasses() error {
+	for name, class := range c.OperClasses {
+		for _, p := range class.Privileges {
+			if !slices.Contains(privileges, privilege(p)) {
+				return errors.Join(ErrUnknownPrivilege, errors.New(name+": "+p))
+			}
+		}
+	}
+	for op, class := range c.OpClass {
+		if _, ok := c.OperClasses[class]; !ok {
+			return errors.Join(ErrUnknownOperClass, errors.New(op+": "+class))
+		}
+	}
+	return nil
+}
+
+// canOper returns true if c meets the requirements of class.
+func canOper(c *client.Client, class OperClass) bool {
+	if class.RequireTLS && !c.IsSecure() {
+		return false
+	}
+
+	if len(class.Hosts) > 0 {
+		userhost := strings.ToLower(c.User + "@" + c.RealHost)
+		if !slices.ContainsFunc(class.Hosts, func(mask string) bool {
+			return wild.Match(strings.ToLower(mask), userhost)
+		}) {
+			return false
+		}
+	}
+
+	if len(class.Fingerprints) > 0 {
+		fp, err := c.CertificateFingerprint()
+		if err != nil || !slices.ContainsFunc(class.Fingerprints, func(v string) bool {
+			return strings.EqualFold(v, fp)
+		}) {
+			return false
+		}
+	}
+	return true
+}
+
+// hasPrivilege returns true if c is an operator whose class grants p.
+func (s *Server) hasPrivilege(c *client.Client, p privilege) bool {
+	if !c.Is(client.Op) {
+		return false
+	}
+	if c.OperClass == "" {
+		return true
+	}
+
+	class, ok := s.OperClasses[c.OperClass]
+	return ok && slices.Contains(class.Privileges, string(p))
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestOperClasses(t *testing.T) {
+	t.Parallel()
+
+	pass, _ := bcrypt.GenerateFromPassword([]byte("pass"), bcrypt.MinCost)
+	conf := &Config{Name: "gossip", Port: ":0"}
+	conf.Cloak.Disabled = true
+	conf.Ops = map[string][]byte{"helper": pass, "remote": pass, "secure": pass, "admin": pass}
+	conf.OperClasses = map[string]OperClass{
+		"helper": {Privileges: []string{"kill"}, Hosts: []string{"*@localhost"}},
+		"remote": {Privileges: []string{"kill"}, Hosts: []string{"*@example.com"}},
+		"secure": {Privileges: []string{"kill"}, RequireTLS: true},
+	}
+	conf.OpClass = map[string]string{"helper": "helper", "remote": "remote", "secure": "secure"}
+
+	s, err := New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	go s.Serve()
+
+	t.Run("HostMismatch", func(t *testing.T) {
+		c, r := s.connectAndRegister("a")
+		defer c.Close()
+
+		c.Write([]byte("OPER remote pass\r\n"))
+		resp, _ := r.ReadBytes('\n')
+		assertResponse(resp, prepMessage(ERR_NOOPERHOST, s.Name, "a").String(), t)
+	})
+
+	t.Run("RequireTLS", func(t *testing.T) {
+		c, r := s.connectAndRegister("b")
+		defer c.Close()
+
+		c.Write([]byte("OPER secure pass\r\n"))
+		resp, _ := r.ReadBytes('\n')
+		assertResponse(resp, prepMessage(ERR_NOOPERHOST, s.Name, "b").String(), t)
+	})
+
+	t.Run("Privileges", func(t *testing.T) {
+		c, r := s.connectAndRegister("c")
+		defer c.Close()
+		victim, _ := s.connectAndRegister("d")
+		defer victim.Close()
+
+		c.Write([]byte("OPER helper pass\r\n"))
+		readLines(r, 2)
+
+		c.Write([]byte("WALLOPS hi\r\nREHASH\r\nKLINE d@*\r\n"))
+		for i := 0; i < 3; i++ {
+			resp, _ := r.ReadBytes('\n')
+			assertResponse(resp, prepMessage(ERR_NOPRIVILEGES, s.Name, "c").String(), t)
+		}
+
+		c.Write([]byte("KILL d :bye\r\nKILL d :bye\r\n"))
+		resp, _ := r.ReadBytes('\n')
+		assertResponse(resp, prepMessage(ERR_NOSUCHNICK, s.Name, "c", "d").String(), t)
+	})
+
+	t.Run("NoClass", func(t *testing.T) {
+		c, r := s.connectAndRegister("e")
+		defer c.Close()
+
+		c.Write([]byte("OPER admin pass\r\n"))
+		readLines(r, 2)
+
+		c.Write([]byte("STATS k\r\n"))
+		resp, _ := r.ReadBytes('\n')
+		assertResponse(resp, fmt.Sprintf(":%s 219 e k :End of /STATS report\r\n", s.Name), t)
+	})
+}
+
+func TestValidateOperClasses(t *testing.T) {
+	conf := &Config{
+		OperClasses: map[string]OperClass{"a": {Privileges: []string{"kill", "fly"}}},
+	}
+	if err := conf.validateOperClasses(); !errors.Is(err, ErrUnknownPrivilege) {
+		t.Error("expected", ErrUnknownPrivilege, "got", err)
+	}
+
+	conf = &Config{
+		OperClasses: map[string]OperClass{"a": {Privileges: []string{"kill"}}},
+		OpClass:     map[string]string{"admin": "b"},
+	}
+	if err := conf.validateOperClasses(); !errors.Is(err, ErrUnknownOperClass) {
+		t.Error("expected", ErrUnknownOperClass, "got", err)
+	}
+}
@@ -21,6 +21,7 @@ func TestCanConnectWithPROXYHeader(t *testing.T) {
 		Name:    "gossip",
 		Port:    ":0",
 	}
+	conf.Cloak.Disabled = true
 	conf.TLS.Port = ":0"
 	conf.TLS.Enabled = true
 	conf.TLS.Proxy.Enabled = true
@@ -63,6 +64,7 @@ func TestRejectUnknownProxyIp(t *testing.T) {
 		Name:    "gossip",
 		Port:    ":0",
 	}
+	conf.Cloak.Disabled = true
 	conf.TLS.Port = ":0"
 	conf.TLS.Enabled = true
 	conf.TLS.Proxy.Enabled = true
@@ -108,6 +110,7 @@ func TestProperIpResponseInWHOX(t *testing.T) {
 		Name:    "gossip",
 		Port:    ":0",
 	}
+	conf.Cloak.Disabled = true
 	conf.TLS.Port = ":0"
 	conf.TLS.Enabled = true
 	conf.TLS.Proxy.Enabled = true
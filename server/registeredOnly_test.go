@@ -0,0 +1,60 @@
+package server
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/mitchr/gossip/sasl/plain"
+)
+
+func TestRegisteredOnly(t *testing.T) {
+	t.Parallel()
+
+	s, err := New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	go s.Serve()
+
+	cred := plain.NewCredential("robert", "tanstaaftanstaaf")
+	s.persistPlain(cred.Username, "robert", cred.Pass)
+
+	op, opR := s.connectAndRegister("alice")
+	defer op.Close()
+	op.Write([]byte("JOIN #reg\r\nMODE #reg +R\r\nJOIN #speak\r\nMODE #speak +M\r\nMODE alice +R\r\n"))
+	mode, _ := readLines(opR, 9)
+	assertResponse(mode, ":gossip MODE alice +R\r\n", t)
+
+	c, r := s.connectAndRegister("bob")
+	defer c.Close()
+
+	t.Run("Join", func(t *testing.T) {
+		c.Write([]byte("JOIN #reg\r\n"))
+		resp, _ := r.ReadBytes('\n')
+		assertResponse(resp, prepMessage(ERR_NEEDREGGEDNICK, s.Name, "bob", "#reg").String(), t)
+	})
+
+	t.Run("Speak", func(t *testing.T) {
+		c.Write([]byte("JOIN #speak\r\nPRIVMSG #speak :hi\r\n"))
+		resp, _ := readLines(r, 4)
+		assertResponse(resp, prepMessage(ERR_CANNOTSENDTOCHAN, s.Name, "bob", "#speak").String(), t)
+	})
+
+	t.Run("PrivateMessage", func(t *testing.T) {
+		c.Write([]byte("PRIVMSG alice :hi\r\n"))
+		resp, _ := r.ReadBytes('\n')
+		assertResponse(resp, prepMessage(ERR_NONONREG, s.Name, "bob", "alice").String(), t)
+	})
+
+	t.Run("LoggedIn", func(t *testing.T) {
+		c.Write([]byte("AUTHENTICATE PLAIN\r\n"))
+		r.ReadBytes('\n')
+		c.Write([]byte("AUTHENTICATE " + base64.StdEncoding.EncodeToString([]byte("\000robert\000tanstaaftanstaaf")) + "\r\n"))
+		readLines(r, 2)
+
+		c.Write([]byte("JOIN #reg\r\n"))
+		join, _ := r.ReadBytes('\n')
+		assertResponse(join, ":bob!bob@localhost JOIN #reg\r\n", t)
+	})
+}
@@ -0,0 +1,295 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"hash"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+
+	cap "github.com/mitchr/gossip/capability"
+	"github.com/mitchr/gossip/client"
+	"github.com/mitchr/gossip/sasl"
+	"github.com/mitchr/gossip/sasl/plain"
+	"github.com/mitchr/gossip/sasl/scram"
+	"github.com/mitchr/gossip/scan/msg"
+)
+
+// Account registration follows draft/account-registration
+// (https://ircv3.net/specs/extensions/account-registration).
+
+// how long a verification code can be used for
+const verificationExpiry = time.Hour
+
+// A VerificationSender delivers the code that confirms an account
+// registration to the email address it was registered with.
+type VerificationSender interface {
+	SendVerification(account, email, code string) error
+}
+
+// smtpSender emails verification codes through an SMTP server.
+type smtpSender struct {
+	addr, from string
+}
+
+func (m smtpSender) SendVerification(account, email, code string) error {
+	body := "To: " + email + "\r\n" +
+		"From: " + m.from + "\r\n" +
+		"Subject: Verify your account " + account + "\r\n\r\n" +
+		"To finish registering " + account + ", send this to the server:\r\n\r\n" +
+		"VERIFY " + account + " " + code + "\r\n"
+	return smtp.SendMail(m.addr, nil, m.from, []string{email}, []byte(body))
+}
+
+// a pendingAccount is waiting on its owner to VERIFY it
+type pendingAccount struct {
+	account, email, code string
+	expires              time.Time
+
+	plain  *plain.Credential
+	scrams map[string]*scram.Credential
+}
+
+var (
+	ErrAccountExists      = errors.New("account already exists")
+	ErrNoVerificationPath = errors.New("emailRequired is set, but no verification sender is configured")
+)
+
+// accountRegistrationValue lists the flags of the draft/account-registration
+// capability that apply to this server.
+func (s *Server) accountRegistrationValue() string {
+	flags := []string{}
+	if s.AccountRegistration.BeforeConnect {
+		flags = append(flags, "before-connect")
+	}
+	flags = append(flags, "custom-account-name")
+	if s.AccountRegistration.EmailRequired {
+		flags = append(flags, "email-required")
+	}
+	return strings.Join(flags, ",")
+}
+
+func (s *Server) minPasswordLength() int {
+	if s.AccountRegistration.MinPasswordLength == 0 {
+		return 8
+	}
+	return s.AccountRegistration.MinPasswordLength
+}
+
+// registerAccount implements REGISTER <account> {<email> | "*"} <password>
+func (s *Server) registerAccount(c *client.Client, m *msg.Message) msg.Msg {
+	account, email, pass := m.Params[0], m.Params[1], m.Params[2]
+	fail := func(code, context, description string) msg.Msg {
+		s.stdReply(c, FAIL, "REGISTER", code, context, description)
+		return nil
+	}
+
+	if !c.Is(client.Registered) && !s.AccountRegistration.BeforeConnect {
+		return fail("COMPLETE_CONNECTION_REQUIRED", account, "You must complete connection registration before registering an account")
+	}
+	if c.IsAuthenticated {
+		return fail("ALREADY_AUTHENTICATED", account, "You are already logged in")
+	}
+
+	if account == "*" {
+		if c.Nick == "" {
+			return fail("NEED_NICK", "*", "You must choose a nickname before registering")
+		}
+		account = c.Nick
+	}
+	if !validateNick(account) {
+		return fail("BAD_ACCOUNT_NAME", account, "Account name is not valid")
+	}
+	if s.accountExists(account) {
+		return fail("ACCOUNT_EXISTS", account, "Account already exists")
+	}
+
+	if email == "*" {
+		if s.AccountRegistration.EmailRequired {
+			return fail("INVALID_EMAIL", account, "An email address is required")
+		}
+		email = ""
+	} else if addr, err := mail.ParseAddress(email); err != nil || addr.Address != email {
+		return fail("INVALID_EMAIL", account, "Email address is not valid")
+	}
+
+	if min := s.minPasswordLength(); len(pass) < min {
+		return fail("WEAK_PASSWORD", account, fmt.Sprintf("Password must be at least %d characters", min))
+	}
+	if strings.EqualFold(pass, account) {
+		return fail("UNACCEPTABLE_PASSWORD", account, "Password cannot be the same as the account name")
+	}
+
+	p := newPendingAccount(account, email, pass)
+
+	if s.AccountRegistration.EmailRequired {
+		s.pendingAccounts.Put(strings.ToLower(account), p)
+		time.AfterFunc(verificationExpiry, s.expirePendingAccounts)
+
+		// sending the code can take a while, so c is told how it went
+		// once it is done
+		go s.sendVerification(c, s.verificationSender, p)
+		return nil
+	}
+
+	if err := s.persistAccount(p); err != nil {
+		if errors.Is(err, ErrAccountExists) {
+			return fail("ACCOUNT_EXISTS", account, "Account already exists")
+		}
+		s.logger.Error("could not register account", "client", c, "account", account, "err", err)
+		return fail("TEMPORARILY_UNAVAILABLE", account, "Could not register account, try again later")
+	}
+	return msg.Buffer{
+		msg.New(nil, s.Name, "", "", "REGISTER", []string{"SUCCESS", account, "Account successfully registered"}, true),
+		s.logIn(c, account),
+	}
+}
+
+// sendVerification sends the verification code of p with sender, and
+// tells c whether it was sent.
+func (s *Server) sendVerification(c *client.Client, sender VerificationSender, p *pendingAccount) {
+	if err := sender.SendVerification(p.account, p.email, p.code); err != nil {
+		s.logger.Error("could not send verification code", "client", c, "account", p.account, "err", err)
+		s.pendingAccounts.Del(strings.ToLower(p.account))
+		s.stdReply(c, FAIL, "REGISTER", "TEMPORARILY_UNAVAILABLE", p.account, "Could not send a verification code, try again later")
+		return
+	}
+	c.WriteMessage(msg.New(nil, s.Name, "", "", "REGISTER", []string{"VERIFICATION_REQUIRED", p.account, "A verification code was sent to " + p.email}, true))
+}
+
+// expirePendingAccounts forgets every pending account whose
+// verification code has expired.
+func (s *Server) expirePendingAccounts() {
+	var expired []string
+	for k, p := range s.pendingAccounts.All() {
+		if time.Now().After(p.expires) {
+			expired = append(expired, k)
+		}
+	}
+	for _, k := range expired {
+		s.pendingAccounts.Del(k)
+	}
+}
+
+// VERIFY <account> <code>
+func VERIFY(s *Server, c *client.Client, m *msg.Message) msg.Msg {
+	if len(m.Params) < 2 {
+		return prepMessage(ERR_NEEDMOREPARAMS, s.Name, c.Id(), "VERIFY")
+	}
+	account, code := m.Params[0], m.Params[1]
+
+	if !c.Is(client.Registered) && !s.AccountRegistration.BeforeConnect {
+		s.stdReply(c, FAIL, "VERIFY", "COMPLETE_CONNECTION_REQUIRED", account, "You must complete connection registration before verifying an account")
+		return nil
+	}
+	if c.IsAuthenticated {
+		s.stdReply(c, FAIL, "VERIFY", "ALREADY_AUTHENTICATED", account, "You are already logged in")
+		return nil
+	}
+
+	p, ok := s.pendingAccounts.Get(strings.ToLower(account))
+	if !ok || time.Now().After(p.expires) || subtle.ConstantTimeCompare([]byte(p.code), []byte(code)) != 1 {
+		s.stdReply(c, FAIL, "VERIFY", "INVALID_CODE", account, "Invalid verification code")
+		return nil
+	}
+	s.pendingAccounts.Del(strings.ToLower(account))
+
+	if err := s.persistAccount(p); err != nil {
+		s.logger.Error("could not register account", "client", c, "account", account, "err", err)
+		s.stdReply(c, FAIL, "VERIFY", "TEMPORARILY_UNAVAILABLE", account, "Could not register account, try again later")
+		return nil
+	}
+	return msg.Buffer{
+		msg.New(nil, s.Name, "", "", "VERIFY", []string{"SUCCESS", p.account, "Account successfully registered"}, true),
+		s.logIn(c, p.account),
+	}
+}
+
+func newPendingAccount(account, email, pass string) *pendingAccount {
+	code := make([]byte, 10)
+	rand.Read(code)
+
+	p := &pendingAccount{
+		account: account,
+		email:   email,
+		code:    base32.StdEncoding.EncodeToString(code),
+		expires: time.Now().Add(verificationExpiry),
+		plain:   plain.NewCredential(account, pass),
+		scrams:  make(map[string]*scram.Credential),
+	}
+	for _, h := range []func() hash.Hash{sha256.New, sha512.New} {
+		salt := make([]byte, 16)
+		rand.Read(salt)
+		p.scrams[scram.Table(h)] = scram.NewCredential(h, account, pass, salt, 4096)
+	}
+	return p
+}
+
+// accountExists returns true if account has been registered, or is
+// waiting to be verified.
+func (s *Server) accountExists(account string) bool {
+	if p, ok := s.pendingAccounts.Get(strings.ToLower(account)); ok && time.Now().Before(p.expires) {
+		return true
+	}
+
+	var username string
+	err := s.db.QueryRow(`
+		select username from sasl_plain where lower(username)=lower(?)
+		union
+		select username from sasl_external where lower(username)=lower(?)
+	`, account, account).Scan(&username)
+	return err == nil
+}
+
+// persistAccount saves every credential of p in a single transaction.
+func (s *Server) persistAccount(p *pendingAccount) error {
+	if s.accountExists(p.account) {
+		return ErrAccountExists
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("INSERT INTO sasl_plain VALUES(?, ?, ?)", p.account, p.account, p.plain.Pass)
+	if err != nil {
+		return err
+	}
+	for table, cred := range p.scrams {
+		_, err = tx.Exec("INSERT INTO "+table+" VALUES(?, ?, ?, ?, ?, ?)", p.account, p.account, cred.ServerKey, cred.StoredKey, cred.Salt, cred.Iteration)
+		if err != nil {
+			return err
+		}
+	}
+	if p.email != "" {
+		_, err = tx.Exec("INSERT INTO account_email VALUES(?, ?)", p.account, p.email)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// logIn marks c as authenticated to account, as if it had used SASL.
+func (s *Server) logIn(c *client.Client, account string) msg.Msg {
+	c.SASLMech = sasl.Account(account)
+	c.IsAuthenticated = true
+	s.accountNotify(c)
+
+	var buff msg.Buffer
+	buff.AddMsg(prepMessage(RPL_LOGGEDIN, s.Name, c.Id(), c, account, account))
+	if c.Caps[cap.AccountNotify.Name] {
+		buff.AddMsg(msg.New(nil, c.Nick, c.User, c.Host, "ACCOUNT", []string{account}, false))
+	}
+	buff.AddMsg(s.grantAllAccess(c))
+	return buff
+}
@@ -0,0 +1,422 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+
+	cap "github.com/mitchr/gossip/capability"
+	"github.com/mitchr/gossip/client"
+	"github.com/mitchr/gossip/cloak"
+	"github.com/mitchr/gossip/sasl/oauthbearer"
+	"github.com/mitchr/gossip/scan/msg"
+	"github.com/mitchr/gossip/websocket"
+	"github.com/pires/go-proxyproto"
+)
+
+// A listenerSpec describes one of the listeners that a config asks
+// for.
+type listenerSpec struct {
+	// the field of the server that holds the listener
+	field *net.Listener
+
+	enabled bool
+	addr    string
+	// the rest of the settings that the listener is opened with. If
+	// these change, the listener is reopened.
+	settings string
+
+	// wrap adds the protocol that the listener speaks on top of tcp,
+	// like tls; nil if it is plain tcp
+	wrap  func(net.Listener) (net.Listener, error)
+	serve func(net.Listener)
+}
+
+func (s *Server) listenerSpecs(c *Config) []listenerSpec {
+	return []listenerSpec{
+		{
+			field: &s.listener, enabled: true, addr: c.Port, settings: fmt.Sprint(c.Proxy),
+			wrap:  proxyWrapper(c.Proxy),
+			serve: s.acceptClients,
+		},
+		{
+			field: &s.tlsListener, enabled: c.TLS.Enabled, addr: c.TLS.Port, settings: fmt.Sprint(c.TLS.Proxy),
+			wrap: func(l net.Listener) (net.Listener, error) {
+				// the proxy in front of this listener terminates tls
+				if c.TLS.Proxy.Enabled {
+					return proxyWrapper(c.TLS.Proxy)(l)
+				}
+				return tls.NewListener(l, s.tlsConfig()), nil
+			},
+			serve: s.acceptClients,
+		},
+		{
+			field: &s.wsListener, enabled: c.WebSocket.Port != "", addr: c.WebSocket.Port, settings: fmt.Sprint(c.WebSocket.TLS, c.WebSocket.Origins),
+			wrap: func(l net.Listener) (net.Listener, error) {
+				if c.WebSocket.TLS {
+					l = tls.NewListener(l, s.tlsConfig())
+				}
+				return websocket.Listen(l, c.WebSocket.Origins), nil
+			},
+			serve: s.acceptClients,
+		},
+		{
+			field: &s.linkListener, enabled: c.Links.Port != "", addr: c.Links.Port, settings: fmt.Sprint(c.Links.TLS),
+			wrap: func(l net.Listener) (net.Listener, error) {
+				if c.Links.TLS {
+					return tls.NewListener(l, s.tlsConfig()), nil
+				}
+				return l, nil
+			},
+			serve: s.acceptLinks,
+		},
+		{
+			field: &s.adminListener, enabled: c.Admin.Addr != "", addr: httpAddr(c.Admin.Addr),
+			serve: s.serveAdmin,
+		},
+		{
+			field: &s.metricsListener, enabled: c.Metrics.Addr != "", addr: httpAddr(c.Metrics.Addr),
+			serve: s.serveMetrics,
+		},
+	}
+}
+
+// A tcpListener is a listener that speaks another protocol on top of a
+// tcp listener. The tcp listener is kept so that it can be handed over
+// by an upgrade.
+type tcpListener struct {
+	net.Listener
+	tcp *net.TCPListener
+}
+
+// open opens the listener that spec describes. If a listener on the
+// same address was handed over by an upgrade, it is used instead of
+// listening again.
+func (s *Server) open(spec listenerSpec) (net.Listener, error) {
+	l, ok := s.inherited[spec.addr]
+	if ok {
+		delete(s.inherited, spec.addr)
+	} else {
+		ln, err := net.Listen("tcp", spec.addr)
+		if err != nil {
+			return nil, err
+		}
+		l = ln.(*net.TCPListener)
+	}
+
+	if spec.wrap == nil {
+		return l, nil
+	}
+	wrapped, err := spec.wrap(l)
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	return tcpListener{wrapped, l}, nil
+}
+
+// proxyWrapper returns a wrap function for listenerSpec. If p is
+// enabled, connections are expected to start with a PROXY header.
+func proxyWrapper(p Proxy) func(net.Listener) (net.Listener, error) {
+	return func(l net.Listener) (net.Listener, error) {
+		if !p.Enabled {
+			return l, nil
+		}
+
+		proxyListener := &proxyproto.Listener{Listener: l}
+		if len(p.Whitelist) > 0 {
+			var err error
+			proxyListener.Policy, err = proxyproto.StrictWhiteListPolicy(p.Whitelist)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return proxyListener, nil
+	}
+}
+
+// tlsConfig is used by every tls listener. It hands out the tls config
+// of the current config, so that REHASH can replace certificates
+// without reopening listeners.
+func (s *Server) tlsConfig() *tls.Config {
+	return &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return s.config().TLS.Config, nil
+		},
+	}
+}
+
+// config returns the current config. Code that does not run as part of
+// a command, like a tls handshake, reads the config through this so
+// that it never sees one that is being swapped in.
+func (s *Server) config() *Config {
+	s.configLock.RLock()
+	defer s.configLock.RUnlock()
+	return s.Config
+}
+
+const (
+	keepListener = iota
+	replaceListener
+	// the listener is opened on the same address as the current one, so
+	// it can only be opened once the current one is closed
+	reopenListener
+)
+
+// A pendingConfig is a config along with everything that the server
+// derives from it. It is built completely before it is applied, so that
+// a config which can't be used leaves the server untouched.
+type pendingConfig struct {
+	*Config
+
+	oauth              *oauthbearer.Verifier
+	cloak              *cloak.Cloaker
+	verificationSender VerificationSender
+	supportedCaps      []cap.Cap
+
+	// what to do with each listener in listenerSpecs
+	actions   []int
+	listeners []net.Listener
+}
+
+// prepare builds everything that s derives from c, which has already
+// been validated. Listeners that changed since old are opened.
+func (s *Server) prepare(old, c *Config) (*pendingConfig, error) {
+	p := &pendingConfig{Config: c, supportedCaps: supportedCaps(c)}
+
+	p.verificationSender = c.AccountRegistration.Sender
+	if p.verificationSender == nil && c.AccountRegistration.SMTP.Addr != "" {
+		p.verificationSender = smtpSender{c.AccountRegistration.SMTP.Addr, c.AccountRegistration.SMTP.From}
+	}
+
+	if len(c.OAuth.Keys) > 0 {
+		var err error
+		p.oauth, err = oauthbearer.NewVerifier(c.OAuth.Keys, c.OAuth.Issuer, c.OAuth.Audience, c.OAuth.AccountClaim)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !c.Cloak.Disabled {
+		secret := c.Cloak.Secret
+		if secret == "" {
+			var err error
+			if secret, err = s.cloakSecret(); err != nil {
+				return nil, err
+			}
+		}
+		prefix := c.Cloak.Prefix
+		if prefix == "" {
+			prefix = c.Network
+		}
+		if prefix == "" {
+			prefix = "gossip"
+		}
+		p.cloak = cloak.New(secret, prefix)
+	}
+
+	specs := s.listenerSpecs(c)
+	var current []listenerSpec
+	if old != nil {
+		current = s.listenerSpecs(old)
+	}
+	p.actions = make([]int, len(specs))
+	p.listeners = make([]net.Listener, len(specs))
+	for i, spec := range specs {
+		switch {
+		case current != nil && current[i].enabled == spec.enabled && current[i].addr == spec.addr && current[i].settings == spec.settings:
+			p.actions[i] = keepListener
+		case !spec.enabled:
+			p.actions[i] = replaceListener
+		case current != nil && current[i].enabled && current[i].addr == spec.addr:
+			p.actions[i] = reopenListener
+		default:
+			l, err := s.open(spec)
+			if err != nil {
+				p.close()
+				return nil, err
+			}
+			p.actions[i], p.listeners[i] = replaceListener, l
+		}
+	}
+	return p, nil
+}
+
+// close closes the listeners that were opened for p.
+func (p *pendingConfig) close() {
+	for _, l := range p.listeners {
+		if l != nil {
+			l.Close()
+		}
+	}
+}
+
+// apply swaps p in as the config of s. The caller must hold configLock.
+// Listeners that are replaced are closed; connections that they
+// accepted are kept.
+func (s *Server) apply(p *pendingConfig) error {
+	s.Config = p.Config
+	s.oauth = p.oauth
+	s.cloak = p.cloak
+	s.verificationSender = p.verificationSender
+	s.supportedCaps = p.supportedCaps
+
+	var errs []error
+	for i, spec := range s.listenerSpecs(p.Config) {
+		if p.actions[i] == keepListener {
+			continue
+		}
+		if *spec.field != nil {
+			(*spec.field).Close()
+		}
+
+		l := p.listeners[i]
+		if p.actions[i] == reopenListener {
+			var err error
+			if l, err = s.open(spec); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		*spec.field = l
+		if l != nil && s.serving {
+			go spec.serve(l)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Rehash reads the config again from the file that it was read from,
+// and swaps it in. If the new config can't be used, the server keeps
+// its current config.
+func (s *Server) Rehash() error {
+	s.rehashLock.Lock()
+	defer s.rehashLock.Unlock()
+
+	// the new config is built without holding configLock, so that
+	// commands aren't held up by reading the file or opening listeners.
+	// Only rehashes change the config, so old stays current until it is
+	// swapped out below.
+	old := s.config()
+	if old.path == "" {
+		return errors.New("the config was not read from a file")
+	}
+	c, err := ReadConfig(old.path)
+	if err != nil {
+		return err
+	}
+	c.Debug = old.Debug
+	c.AccountRegistration.Sender = old.AccountRegistration.Sender
+
+	if c.Name != old.Name {
+		return errors.New("the server name can't be changed by rehashing")
+	}
+	if c.Datasource != old.Datasource {
+		return errors.New("the datasource can't be changed by rehashing")
+	}
+	if err := c.validate(); err != nil {
+		return err
+	}
+
+	p, err := s.prepare(old, c)
+	if err != nil {
+		return err
+	}
+
+	s.configLock.Lock()
+	before := s.capValues()
+	err = s.apply(p)
+	notify := s.updateCaps(before, s.capValues())
+	s.configLock.Unlock()
+
+	notify()
+	if err != nil {
+		s.logger.Error("could not reopen listener", "err", err)
+	}
+	s.logger.Info("rehashed", "config", c.path)
+	return err
+}
+
+// capValues maps the name of every supported capability to the value
+// that it is advertised with.
+func (s *Server) capValues() map[string]string {
+	values := make(map[string]string, len(s.supportedCaps))
+	for _, v := range s.supportedCaps {
+		values[v.Name] = s.capValue(v)
+	}
+	return values
+}
+
+// updateCaps removes every capability that is no longer supported from
+// the clients that have cap-notify enabled. It returns a function that
+// sends them CAP NEW and CAP DEL for every capability that was added,
+// removed, or changed its value. The caller must hold configLock, but
+// should call the returned function after releasing it.
+func (s *Server) updateCaps(before, after map[string]string) func() {
+	var added, removed []string
+	for name, value := range after {
+		if old, ok := before[name]; !ok || old != value {
+			added = append(added, name)
+		}
+	}
+	for name := range before {
+		if _, ok := after[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+	if len(added) == 0 && len(removed) == 0 {
+		return func() {}
+	}
+	slices.Sort(added)
+	slices.Sort(removed)
+
+	type notification struct {
+		c    *client.Client
+		msgs []*msg.Message
+	}
+	var notifications []notification
+	for _, c := range s.clients.All() {
+		if c.Server != "" || !c.Caps[cap.CapNotify.Name] {
+			continue
+		}
+
+		n := notification{c: c}
+		if len(removed) > 0 {
+			for _, name := range removed {
+				c.ApplyCap(name, true)
+			}
+			n.msgs = append(n.msgs, msg.New(nil, s.Name, "", "", "CAP", []string{c.Id(), "DEL", strings.Join(removed, " ")}, true))
+		}
+		if len(added) > 0 {
+			caps := make([]string, len(added))
+			for i, name := range added {
+				caps[i] = name
+				if value := after[name]; value != "" && c.SupportsCapVersion(302) {
+					caps[i] += "=" + value
+				}
+			}
+			n.msgs = append(n.msgs, msg.New(nil, s.Name, "", "", "CAP", []string{c.Id(), "NEW", strings.Join(caps, " ")}, true))
+		}
+		notifications = append(notifications, n)
+	}
+
+	return func() {
+		for _, n := range notifications {
+			for _, m := range n.msgs {
+				n.c.WriteMessage(m)
+			}
+		}
+	}
+}
+
+// rehashFor rehashes on behalf of c, and tells c and operators about it.
+func (s *Server) rehashFor(c *client.Client) {
+	if err := s.Rehash(); err != nil {
+		c.WriteMessage(s.NOTICE(c, "Rehash failed: "+err.Error()))
+		s.snotice(client.SnoRehash, c.Nick+" failed to rehash: "+err.Error())
+		return
+	}
+	s.snotice(client.SnoRehash, c.Nick+" rehashed "+s.config().path)
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRehash(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	writeConfig := func(config string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.WriteFile(filepath.Join(dir, "motd.txt"), []byte("welcome back"), 0o644)
+
+	writeConfig(`{"name": "gossip", "port": ":0", "cloak": {"disabled": true}}`)
+	conf, err := ReadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	go s.Serve()
+
+	c, r := s.connectAndRegister("alice")
+	defer c.Close()
+	c.Write([]byte("CAP LS 302\r\n"))
+	r.ReadBytes('\n')
+
+	t.Run("Apply", func(t *testing.T) {
+		writeConfig(`{
+			"name": "gossip",
+			"port": ":0",
+			"cloak": {"disabled": true},
+			"motd": "` + filepath.Join(dir, "motd.txt") + `",
+			"metrics": {"addr": "localhost:0"},
+			"accountRegistration": {"beforeConnect": true}
+		}`)
+		if err := s.Rehash(); err != nil {
+			t.Fatal(err)
+		}
+
+		resp, _ := r.ReadBytes('\n')
+		assertResponse(resp, ":gossip CAP alice NEW :draft/account-registration=before-connect,custom-account-name\r\n", t)
+
+		c.Write([]byte("MOTD\r\n"))
+		motd, _ := readLines(r, 2)
+		assertResponse(motd, prepMessage(RPL_MOTD, s.Name, "alice", "welcome back").String(), t)
+		readLines(r, 1)
+
+		metrics, err := http.Get("http://" + s.metricsListener.Addr().String() + "/metrics")
+		if err != nil {
+			t.Fatal(err)
+		}
+		metrics.Body.Close()
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		metricsAddr := s.metricsListener.Addr().String()
+
+		for _, config := range []string{
+			`{"name": "gossip"`,
+			`{"name": "other", "port": ":0"}`,
+			`{"name": "gossip", "port": ":0", "cloak": {"disabled": true}, "admin": {"addr": "localhost:0"}}`,
+		} {
+			writeConfig(config)
+			if err := s.Rehash(); err == nil {
+				t.Error("expected rehash to fail for", config)
+			}
+		}
+
+		if s.metricsListener == nil || s.metricsListener.Addr().String() != metricsAddr {
+			t.Error("expected metrics listener to be kept")
+		}
+		if !s.AccountRegistration.BeforeConnect {
+			t.Error("expected config to be kept")
+		}
+	})
+
+	t.Run("CloseListener", func(t *testing.T) {
+		metricsAddr := s.metricsListener.Addr().String()
+
+		writeConfig(`{"name": "gossip", "port": ":0", "cloak": {"disabled": true}}`)
+		if err := s.Rehash(); err != nil {
+			t.Fatal(err)
+		}
+		resp, _ := r.ReadBytes('\n')
+		assertResponse(resp, ":gossip CAP alice NEW :draft/account-registration=custom-account-name\r\n", t)
+
+		if s.metricsListener != nil {
+			t.Error("expected metrics listener to be closed")
+		}
+		if _, err := net.Dial("tcp", metricsAddr); err == nil {
+			t.Error("expected metrics to no longer be served")
+		}
+	})
+}
@@ -3,25 +3,27 @@ package server
 import (
 	"bytes"
 	"context"
-	"crypto/tls"
 	"database/sql"
 	"errors"
+	"io"
 	"iter"
-	"log"
+	"log/slog"
 	"net"
+	"os"
 	"slices"
-	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	cap "github.com/mitchr/gossip/capability"
 	"github.com/mitchr/gossip/channel"
 	"github.com/mitchr/gossip/client"
+	"github.com/mitchr/gossip/cloak"
+	"github.com/mitchr/gossip/sasl/oauthbearer"
 	"github.com/mitchr/gossip/scan"
 	"github.com/mitchr/gossip/scan/msg"
 	"github.com/mitchr/gossip/util"
-	"github.com/pires/go-proxyproto"
 	_ "modernc.org/sqlite"
 )
 
@@ -31,9 +33,48 @@ type Server struct {
 	// database used for user account information
 	db *sql.DB
 
-	listener    net.Listener
-	tlsListener net.Listener
-	created     time.Time
+	logger *slog.Logger
+	// the file that logger writes to; nil if it writes to stderr
+	logFile io.Closer
+
+	// verifies OAUTHBEARER tokens; nil if OAUTHBEARER is disabled
+	oauth *oauthbearer.Verifier
+
+	// generates cloaked hosts; nil if cloaking is disabled
+	cloak *cloak.Cloaker
+
+	// account name to a registration that has not been verified yet
+	pendingAccounts    *util.SafeMap[string, *pendingAccount]
+	verificationSender VerificationSender
+
+	// the commands that clients can send. REHASH opens listeners, which
+	// lead to executeMessage, so it looks commands up through s instead
+	// of depending on them directly.
+	commands map[string]executor
+
+	// guards Config, the listeners, and the state derived from Config
+	// while REHASH swaps in a new config. Commands are executed while
+	// holding it for reading.
+	configLock sync.RWMutex
+	// held for the whole of a rehash, so that only one runs at a time
+	rehashLock sync.Mutex
+	// true once Serve has been called, so that listeners opened by
+	// REHASH are served
+	serving bool
+
+	listener     net.Listener
+	tlsListener  net.Listener
+	wsListener   net.Listener
+	linkListener net.Listener
+	// serves the admin API; nil if it is disabled
+	adminListener net.Listener
+	// serves /metrics; nil if metrics are disabled
+	metricsListener net.Listener
+	// listeners that were handed over by an upgrade, by address, that
+	// have not been opened yet
+	inherited map[string]*net.TCPListener
+
+	created time.Time
 
 	// nick to underlying client
 	clients *util.SafeMap[string, *client.Client]
@@ -41,6 +82,9 @@ type Server struct {
 	// ChanType + name to channel
 	channels *util.SafeMap[string, *channel.Channel]
 
+	// server name to directly linked server
+	links *util.SafeMap[string, *link]
+
 	joinLock sync.Mutex
 
 	// a running count of connected users who are unregistered
@@ -49,86 +93,110 @@ type Server struct {
 	// the largest number of clients ever connected to this server
 	max statistic
 
+	metrics metrics
+
+	// messages waiting to be written to the history table by
+	// writeHistory, which closes historyDone once it stops
+	history     chan historyRecord
+	historyDone chan struct{}
+
 	supportedCaps []cap.Cap
 	whowasHistory whowasStack
 	monitor       monitor
 
-	wg sync.WaitGroup
+	// the upgrade that is in progress, if any
+	handover atomic.Pointer[handover]
+
+	// canceled by Close; connections are closed once it is done
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
 }
 
 func New(c *Config) (*Server, error) {
+	s, err := newServer(c)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.openListeners(); err != nil {
+		s.discard()
+		return nil, err
+	}
+	return s, nil
+}
+
+// newServer sets up everything that s needs except for its listeners.
+func newServer(c *Config) (*Server, error) {
 	s := &Server{
 		Config:   c,
+		commands: commands,
 		created:  time.Now(),
 		clients:  util.NewSafeMap[string, *client.Client](),
 		channels: util.NewSafeMap[string, *channel.Channel](),
-		// keep this list sorted alphabetically
-		supportedCaps: []cap.Cap{
-			cap.AccountNotify,
-			cap.AccountTag,
-			cap.AwayNotify,
-			cap.Batch,
-			cap.CapNotify,
-			cap.EchoMessage,
-			cap.ExtendedJoin,
-			cap.ExtendedMonitor,
-			cap.InviteNotify,
-			cap.LabeledResponses,
-			cap.MessageTags,
-			cap.MultiPrefix,
-			cap.SASL,
-			cap.ServerTime,
-			cap.Setname,
-			cap.UserhostInNames,
-		},
-		monitor: monitor{m: make(map[string]map[string]bool)},
+		links:    util.NewSafeMap[string, *link](),
+
+		pendingAccounts: util.NewSafeMap[string, *pendingAccount](),
+		monitor:         monitor{m: make(map[string]map[string]bool)},
+		history:         make(chan historyRecord, historyQueueSize),
+		historyDone:     make(chan struct{}),
 	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
 
-	err := s.loadDatabase(s.Datasource)
+	err := c.validate()
 	if err != nil {
 		return nil, err
 	}
 
-	s.listener, err = net.Listen("tcp", c.Port)
+	s.logger, s.logFile, err = c.newLogger()
 	if err != nil {
 		return nil, err
 	}
-	if c.Proxy.Enabled {
-		proxyListener := &proxyproto.Listener{Listener: s.listener}
-		if len(c.Proxy.Whitelist) > 0 {
-			proxyListener.Policy = proxyproto.MustStrictWhiteListPolicy(c.Proxy.Whitelist)
+	defer func() {
+		if err != nil && s.logFile != nil {
+			s.logFile.Close()
 		}
-		s.listener = proxyListener
-	}
-
-	if c.TLS.Enabled {
-		if c.TLS.Proxy.Enabled {
-			s.tlsListener, err = net.Listen("tcp", c.TLS.Port)
-			if err != nil {
-				return nil, err
-			}
+	}()
 
-			proxyListener := &proxyproto.Listener{Listener: s.tlsListener}
-			if len(c.TLS.Proxy.Whitelist) > 0 {
-				proxyListener.Policy = proxyproto.MustStrictWhiteListPolicy(c.TLS.Proxy.Whitelist)
-			}
-			s.tlsListener = proxyListener
-		} else {
+	err = s.loadDatabase(s.Datasource)
+	if err != nil {
+		return nil, err
+	}
+	err = s.loadChannels()
+	if err != nil {
+		return nil, err
+	}
+	go s.writeHistory()
+	go s.pruneHistory()
+	return s, nil
+}
 
-			s.tlsListener, err = tls.Listen("tcp", c.TLS.Port, c.TLS.Config)
-			if err != nil {
-				return nil, err
-			}
-		}
-		if c.TLS.STS.Enabled {
-			s.supportedCaps = append(s.supportedCaps, cap.STS)
-			sort.Slice(s.supportedCaps, func(i, j int) bool {
-				return s.supportedCaps[i].Name < s.supportedCaps[j].Name
-			})
+// discard releases everything that s holds, for a server that could
+// not be started and is never served.
+func (s *Server) discard() {
+	s.configLock.Lock()
+	for _, spec := range s.listenerSpecs(s.Config) {
+		if *spec.field != nil {
+			(*spec.field).Close()
 		}
 	}
+	s.configLock.Unlock()
 
-	return s, nil
+	s.cancel()
+	<-s.historyDone
+	if s.logFile != nil {
+		s.logFile.Close()
+	}
+}
+
+// openListeners opens every listener that the config of s asks for.
+func (s *Server) openListeners() error {
+	p, err := s.prepare(nil, s.Config)
+	if err != nil {
+		return err
+	}
+	s.configLock.Lock()
+	defer s.configLock.Unlock()
+	return s.apply(p)
 }
 
 func (s *Server) hasCap(c string) bool {
@@ -149,6 +217,9 @@ func (s *Server) loadDatabase(datasource string) error {
 	if err != nil {
 		return err
 	}
+	// every new connection to an in-memory database gets its own empty
+	// database, so make sure that we only ever use one
+	s.db.SetMaxOpenConns(1)
 
 	_, err = s.db.Exec(`CREATE TABLE IF NOT EXISTS sasl_plain(
 		username TEXT,
@@ -174,60 +245,177 @@ func (s *Server) loadDatabase(datasource string) error {
 		PRIMARY KEY(username)
 	);
 	
+	CREATE TABLE IF NOT EXISTS sasl_scram_sha512(
+		username TEXT,
+		nick TEXT,
+		serverKey BLOB,
+		storedKey BLOB,
+		salt BLOB,
+		iterations INTEGER,
+		PRIMARY KEY(username)
+	);
+
+	CREATE TABLE IF NOT EXISTS account_email(
+		username TEXT,
+		email TEXT,
+		PRIMARY KEY(username)
+	);
+
 	CREATE TABLE IF NOT EXISTS channels(
 		owner TEXT,
 		chan TEXT
-	)`)
+	);
+
+	CREATE TABLE IF NOT EXISTS channel_state(
+		chan TEXT,
+		created INTEGER,
+		topic TEXT,
+		topicSetBy TEXT,
+		topicSetAt INTEGER,
+		key TEXT,
+		lim INTEGER,
+		flags TEXT,
+		flood TEXT,
+		joinThrottle TEXT,
+		forward TEXT,
+		PRIMARY KEY(chan)
+	);
+
+	CREATE TABLE IF NOT EXISTS channel_lists(
+		chan TEXT,
+		mode TEXT,
+		mask TEXT,
+		setBy TEXT,
+		setAt INTEGER,
+		expires INTEGER
+	);
+
+	CREATE TABLE IF NOT EXISTS channel_access(
+		chan TEXT,
+		mask TEXT,
+		prefix TEXT
+	);
+
+	CREATE TABLE IF NOT EXISTS server_bans(
+		kind TEXT,
+		mask TEXT,
+		reason TEXT,
+		setter TEXT,
+		setAt INTEGER,
+		expires INTEGER,
+		PRIMARY KEY(kind, mask)
+	);
+
+	CREATE TABLE IF NOT EXISTS secrets(
+		name TEXT,
+		value TEXT,
+		PRIMARY KEY(name)
+	);
+
+	CREATE TABLE IF NOT EXISTS history(
+		msgid TEXT,
+		target TEXT,
+		sender TEXT,
+		account TEXT,
+		time INTEGER,
+		message BLOB
+	);
+
+	CREATE INDEX IF NOT EXISTS history_target_time ON history(target, time);`)
 
 	return err
 }
 
-func (s *Server) startAccept(ctx context.Context, cancel context.CancelFunc, l net.Listener) {
+// acceptClients accepts client connections from l until it is closed.
+func (s *Server) acceptClients(l net.Listener) {
 	for {
 		conn, err := l.Accept()
 		if errors.Is(err, net.ErrClosed) {
-			cancel()
 			return
+		} else if err != nil {
+			s.logger.Warn("could not accept connection", "err", err)
+			continue
 		}
 		s.wg.Add(1)
-		go s.handleConn(conn, ctx)
+		go func() {
+			// looking up the address of a proxied connection may block, so
+			// this is done off of the accept loop
+			if s.rejectConn(conn) {
+				s.wg.Done()
+				return
+			}
+			s.handleConn(conn, s.ctx)
+		}()
 	}
 }
 
 func (s *Server) Serve() {
-	ctx, cancel := context.WithCancel(context.Background())
-
-	go s.startAccept(ctx, cancel, s.listener)
-	if s.tlsListener != nil {
-		go s.startAccept(ctx, cancel, s.tlsListener)
+	s.configLock.Lock()
+	s.serving = true
+	for _, spec := range s.listenerSpecs(s.Config) {
+		if *spec.field != nil {
+			go spec.serve(*spec.field)
+		}
+	}
+	peers := s.Links.Peers
+	s.configLock.Unlock()
+
+	for _, v := range peers {
+		if v.AutoConnect {
+			go func() {
+				if err := s.connectLink(v.Name); err != nil {
+					s.logger.Warn("could not connect link", "server", v.Name, "err", err)
+				}
+			}()
+		}
 	}
 
-	<-ctx.Done()
+	<-s.ctx.Done()
 	s.wg.Wait()
 }
 
-// close listeners so that we stop accepting more connections
-// this will implicitly cancel the server's context
+// close listeners so that we stop accepting more connections, then
+// cancel the server's context
 // graceful shutdown from https://blog.golang.org/context
-func (s *Server) Close() error {
+func (s *Server) Close() error { return s.shutdown("Server shutting down") }
+
+// shutdown closes s, and closes every link with reason.
+func (s *Server) shutdown(reason string) error {
+	s.configLock.Lock()
 	err := s.listener.Close()
-	if err != nil {
-		return err
+	for _, spec := range s.listenerSpecs(s.Config) {
+		if spec.field != &s.listener && *spec.field != nil {
+			(*spec.field).Close()
+		}
 	}
-	if s.tlsListener != nil {
-		return s.tlsListener.Close()
+	s.configLock.Unlock()
+
+	for _, l := range s.links.All() {
+		l.close(reason)
+	}
+	s.cancel()
+	// wait for the history that is left to be written
+	<-s.historyDone
+	if s.logFile != nil {
+		s.logFile.Close()
 	}
-	return nil
+	return err
 }
 
 func (s *Server) handleConn(u net.Conn, ctx context.Context) {
 	defer s.wg.Done()
 
-	c, clientCtx, cancel := client.New(u, ctx)
+	c, clientCtx, cancel := client.New(meteredConn{u, &s.metrics}, ctx)
 	defer cancel()
+	s.logger.Info("connection opened", "client", c)
 
 	s.unknowns.Inc()
+	s.serveClient(c, clientCtx)
+}
 
+// serveClient reads and executes messages from c, and keeps its
+// connection alive, until it is closed.
+func (s *Server) serveClient(c *client.Client, clientCtx context.Context) {
 	errs := make(chan error)
 
 	go startRegistrationTimer(c, errs)
@@ -243,19 +431,29 @@ func (s *Server) handleConn(u net.Conn, ctx context.Context) {
 		case <-clientCtx.Done():
 			return
 		case <-pingTick.C:
-			c.WriteMessage(msg.New(nil, s.Name, "", "", "PING", []string{c.Nick}, false))
+			c.WriteToConn(msg.New(nil, s.Name, "", "", "PING", []string{c.Nick}, false))
 			go waitForPong(c, errs)
 		case <-grantTick.C:
 			c.AddGrant()
 		case err := <-errs:
+			s.logger.Info("connection closed", "client", c, "reason", err)
 			if errors.Is(err, net.ErrClosed) {
-				if _, ok := s.getClient(c.Nick); !ok {
+				if v, ok := s.getClient(c.Nick); !ok || v != c.Identity() {
 					// network closed and client was already removed (or never
-					// was added to begin with); no work to be done here
+					// was added to begin with, or its nick has been taken by
+					// someone else since); no work to be done here
 					return
 				}
 			}
+			if errors.Is(err, client.ErrFlood) {
+				s.metrics.floodDisconnects.Inc()
+				s.snotice(client.SnoFlood, "Client flooding: "+describe(c))
+			} else if errors.Is(err, ErrPingTimeout) {
+				s.metrics.pingTimeouts.Inc()
+			}
+			s.configLock.RLock()
 			QUIT(s, c, &msg.Message{Params: []string{err.Error()}})
+			s.configLock.RUnlock()
 			return
 		}
 	}
@@ -292,14 +490,27 @@ func (s *Server) getMessage(c *client.Client, ctx context.Context, errs chan<- e
 			return
 		default:
 			buff, err := c.ReadMsg()
-			if s.Debug && len(buff) != 0 {
-				log.Printf("[%s]: %s\n", c.RemoteAddr(), string(bytes.TrimRight(buff, "\r\n")))
+			if len(buff) != 0 && s.logger.Enabled(ctx, slog.LevelDebug) {
+				s.logger.Debug("received message", "client", c, "message", string(bytes.TrimRight(buff, "\r\n")))
 			}
 
 			if err == msg.ErrMsgSizeOverflow {
 				s.writeReply(c, ERR_INPUTTOOLONG)
 				continue
 			} else if err != nil {
+				if errors.Is(err, os.ErrDeadlineExceeded) {
+					if h := s.handover.Load(); h != nil {
+						if h.pause(c) {
+							return
+						}
+						continue
+					}
+					// read deadlines are only set to pause readers for an
+					// upgrade, which failed and was over before this reader
+					// got to it
+					c.Conn().SetReadDeadline(time.Time{})
+					continue
+				}
 				errs <- err
 				continue
 			}
@@ -345,7 +556,16 @@ func (s *Server) getChannel(c string) (*channel.Channel, bool) {
 func (s *Server) setChannel(v *channel.Channel) {
 	s.channels.Put(strings.ToLower(v.String()), v)
 }
+
+// deleteChannel removes the channel k once its last member has left.
+// Registered channels are kept, but lose their members.
 func (s *Server) deleteChannel(k string) {
+	if ch, ok := s.getChannel(k); ok && ch.Registered {
+		for _, m := range slices.Collect(ch.All()) {
+			ch.DeleteMember(m.Nick)
+		}
+		return
+	}
 	s.channels.Del(strings.ToLower(k))
 }
 func (s *Server) channelLen() int {
@@ -12,9 +12,11 @@ import (
 	"encoding/hex"
 	"encoding/pem"
 	"fmt"
+	"io"
 	"log"
 	"math/big"
 	"net"
+	"net/http"
 	"strconv"
 	"strings"
 	"testing"
@@ -70,6 +72,50 @@ func TestTLS(t *testing.T) {
 
 }
 
+func TestWebSocket(t *testing.T) {
+	t.Parallel()
+
+	conf := &Config{Name: "gossip", Port: ":0"}
+	conf.Cloak.Disabled = true
+	conf.WebSocket.Port = ":0"
+	s, err := New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	go s.Serve()
+
+	_, port, _ := net.SplitHostPort(s.wsListener.Addr().String())
+	c, err := net.Dial("tcp", ":"+port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	r := bufio.NewReader(c)
+
+	c.Write([]byte("GET / HTTP/1.1\r\nHost: gossip\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\nSec-WebSocket-Protocol: text.ircv3.net\r\n\r\n"))
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatal("expected 101, got", resp.Status)
+	}
+
+	// every line is its own masked frame, without a CRLF
+	for _, line := range []string{"NICK alice", "USER alice 0 0 :Alice Smith"} {
+		frame := []byte{0x81, 0x80 | byte(len(line)), 0, 0, 0, 0}
+		c.Write(append(frame, line...))
+	}
+
+	header := make([]byte, 2)
+	io.ReadFull(r, header)
+	welcome := make([]byte, header[1]&0x7f)
+	io.ReadFull(r, welcome)
+	assertResponse(welcome, fmt.Sprintf(":%s 001 alice :Welcome to the %s IRC Network alice!alice@localhost", s.Name, s.Network), t)
+}
+
 func TestMessageSize(t *testing.T) {
 	t.Parallel()
 
@@ -422,6 +468,7 @@ func generateConfig() *Config {
 	c.TLS.Config = &tls.Config{ClientAuth: tls.RequestClientCert, Certificates: []tls.Certificate{cert}}
 	c.TLS.Enabled = true
 	c.TLS.Port = ":0"
+	c.Cloak.Disabled = true
 
 	return c
 }
@@ -0,0 +1,28 @@
+package server
+
+import (
+	"github.com/mitchr/gossip/client"
+	"github.com/mitchr/gossip/scan/msg"
+)
+
+// snotice sends a server notice to every local operator that has
+// subscribed to category with +s, and logs it.
+func (s *Server) snotice(category client.Snomask, text string) {
+	s.logger.Info(text, "snomask", category.String())
+
+	var opers []*client.Client
+	for _, c := range s.clients.All() {
+		if c.Server == "" && c.HasSnomask(category) {
+			opers = append(opers, c)
+		}
+	}
+
+	for _, c := range opers {
+		c.WriteMessage(msg.New(nil, s.Name, "", "", "NOTICE", []string{c.Nick, "*** Notice -- " + text}, true))
+	}
+}
+
+// describe names c in a server notice.
+func describe(c *client.Client) string {
+	return c.Id() + " (" + c.User + "@" + c.RealHost + ")"
+}
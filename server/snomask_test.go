@@ -0,0 +1,93 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mitchr/gossip/client"
+)
+
+func TestSnomask(t *testing.T) {
+	t.Parallel()
+
+	s, err := New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	go s.Serve()
+
+	oper, operR := s.connectAndRegister("alice")
+	defer oper.Close()
+
+	t.Run("NotOper", func(t *testing.T) {
+		oper.Write([]byte("MODE alice +s\r\n"))
+		unknown, _ := operR.ReadBytes('\n')
+		mode, _ := operR.ReadBytes('\n')
+		assertResponse(unknown, prepMessage(ERR_UMODEUNKNOWNFLAG, s.Name, "alice").String(), t)
+		assertResponse(mode, ":gossip MODE alice \r\n", t)
+	})
+
+	alice, _ := s.getClient("alice")
+	alice.SetMode(client.Op)
+
+	t.Run("Set", func(t *testing.T) {
+		oper.Write([]byte("MODE alice +s +cCn\r\n"))
+		mode, _ := operR.ReadBytes('\n')
+		snomask, _ := operR.ReadBytes('\n')
+		assertResponse(mode, ":gossip MODE alice +s\r\n", t)
+		assertResponse(snomask, prepMessage(RPL_SNOMASK, s.Name, "alice", "+cCn").String(), t)
+	})
+
+	t.Run("Events", func(t *testing.T) {
+		c, r := s.connectAndRegister("bob")
+		connect, _ := operR.ReadBytes('\n')
+		if !strings.HasPrefix(string(connect), ":gossip NOTICE alice :*** Notice -- Client connecting: bob (bob@localhost) [") {
+			t.Error("got", string(connect))
+		}
+
+		c.Write([]byte("NICK bob2\r\n"))
+		r.ReadBytes('\n')
+		nick, _ := operR.ReadBytes('\n')
+		assertResponse(nick, ":gossip NOTICE alice :*** Notice -- Nick change: bob -> bob2\r\n", t)
+
+		c.Write([]byte("QUIT :bye\r\n"))
+		exit, _ := operR.ReadBytes('\n')
+		assertResponse(exit, ":gossip NOTICE alice :*** Notice -- Client exiting: bob2 (bob@localhost) [bye]\r\n", t)
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		oper.Write([]byte("MODE alice +s -cn\r\n"))
+		operR.ReadBytes('\n')
+		snomask, _ := operR.ReadBytes('\n')
+		assertResponse(snomask, prepMessage(RPL_SNOMASK, s.Name, "alice", "+C").String(), t)
+
+		oper.Write([]byte("MODE alice -s\r\nMODE alice +s\r\n"))
+		unset, _ := operR.ReadBytes('\n')
+		set, _ := operR.ReadBytes('\n')
+		snomask, _ = operR.ReadBytes('\n')
+		assertResponse(unset, ":gossip MODE alice -s\r\n", t)
+		assertResponse(set, ":gossip MODE alice +s\r\n", t)
+		assertResponse(snomask, prepMessage(RPL_SNOMASK, s.Name, "alice", "+cCkfonar").String(), t)
+	})
+
+	t.Run("Deoper", func(t *testing.T) {
+		oper.Write([]byte("MODE alice -s\r\nMODE alice +s-o\r\n"))
+		operR.ReadBytes('\n')
+		mode, _ := operR.ReadBytes('\n')
+		assertResponse(mode, ":gossip MODE alice -o\r\n", t)
+		if alice.Is(client.ServerNotice) {
+			t.Error("non-oper kept +s")
+		}
+
+		alice.SetMode(client.Op)
+		oper.Write([]byte("MODE alice +s\r\n"))
+		readLines(operR, 2)
+		oper.Write([]byte("MODE alice -o\r\n"))
+		mode, _ = operR.ReadBytes('\n')
+		assertResponse(mode, ":gossip MODE alice -os\r\n", t)
+		if alice.Is(client.ServerNotice) {
+			t.Error("non-oper kept +s")
+		}
+	})
+}
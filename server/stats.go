@@ -31,3 +31,7 @@ func (s *statistic) KeepMax(t uint64) uint64 {
 		}
 	}
 }
+
+func (s *statistic) Add(n uint64) {
+	atomic.AddUint64((*uint64)(s), n)
+}
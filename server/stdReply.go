@@ -1,6 +1,8 @@
 package server
 
 import (
+	"strings"
+
 	"github.com/mitchr/gossip/client"
 	"github.com/mitchr/gossip/scan/msg"
 )
@@ -13,6 +15,9 @@ const (
 	NOTE replyType = "NOTE"
 )
 
+// stdReply sends a standard reply to c. context is a space separated
+// list of parameters that are placed between code and description.
 func (s *Server) stdReply(c *client.Client, rType replyType, command, code, context, description string) {
-	c.WriteMessage(msg.New(nil, "", "", "", string(rType), []string{command, code, description}, true))
+	params := append([]string{command, code}, strings.Fields(context)...)
+	c.WriteMessage(msg.New(nil, "", "", "", string(rType), append(params, description), true))
 }
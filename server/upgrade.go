@@ -0,0 +1,749 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mitchr/gossip/channel"
+	"github.com/mitchr/gossip/client"
+	"github.com/mitchr/gossip/sasl"
+	"github.com/mitchr/gossip/scan/mode"
+	"github.com/mitchr/gossip/scan/msg"
+	"github.com/pires/go-proxyproto"
+)
+
+// An upgrade replaces this process with a new one, usually after the
+// gossip binary has been replaced, without disconnecting clients. The
+// new process is started with one end of a unix socket, over which it
+// is handed the listeners and client connections of this process along
+// with the state of every client and channel:
+//
+//  1. the new process sets itself up, then sends a byte once it is ready
+//  2. the readers of every client that can be handed over are paused,
+//     and their sockets are sent along with the sockets of the listeners
+//  3. the state of the server is sent as json
+//  4. the new process restores the state, and sends back a byte
+//  5. this process flushes what it still had to write to its clients,
+//     shuts down, and closes the socket
+//  6. the new process starts serving once the socket is closed
+//
+// If the new process fails before step 4, this process keeps serving as
+// if nothing happened.
+
+// the environment variable that holds the descriptor of the socket that
+// a process started by an upgrade is handed over on
+const upgradeEnv = "GOSSIP_UPGRADE_FD"
+
+// how long to wait for the new process, and for readers to pause
+const upgradeTimeout = time.Second * 10
+
+// the reason given to clients that can't be handed over
+const upgradeReason = "Server is upgrading, please reconnect"
+
+// A handover is an upgrade that is in progress.
+type handover struct {
+	// paused readers send their client here
+	paused chan *client.Client
+	// closed once the upgrade is over
+	done chan struct{}
+	// true if the connections were handed over; set before done is
+	// closed
+	handedOver bool
+}
+
+// pause blocks the reader of c until the upgrade is over. It reports
+// whether c was handed over, in which case the reader should stop.
+func (h *handover) pause(c *client.Client) bool {
+	select {
+	case h.paused <- c:
+	case <-h.done:
+	}
+	<-h.done
+	return h.handedOver
+}
+
+// handoverState is the state of a server that is handed over by an
+// upgrade. Sockets are referred to by their index in the files that are
+// sent along with it.
+type handoverState struct {
+	Created time.Time
+	Max     uint64
+
+	// address of each listener to its socket
+	Listeners map[string]int
+	Clients   []clientState
+	Channels  []channelState
+	// target to the clients that are monitoring it
+	Monitor map[string][]string
+}
+
+type clientState struct {
+	Nick, User, Realname string
+	Host, RealHost       string
+	JoinTime, Idle       int64
+	Mode                 client.Mode
+	Snomask              client.Snomask
+	AwayMsg              string
+	OperClass            string
+	Account              string
+	ServerPassAccepted   bool
+
+	// the connection of the client itself; nil if it is detached
+	Conn     *connState
+	Sessions []connState
+}
+
+type connState struct {
+	File       int
+	RemoteAddr string
+	JoinTime   int64
+	Caps       []string
+	CapVersion int
+}
+
+type channelState struct {
+	Name       string
+	Created    time.Time
+	Topic      string
+	TopicSetBy string
+	TopicSetAt time.Time
+
+	Key          string
+	Limit        int
+	Flags        string
+	Flood        string
+	JoinThrottle string
+	Forward      string
+	Lists        map[string][]channel.ListEntry
+
+	Registered bool
+	Founder    string
+	Access     map[string]string
+	Invited    []string
+
+	// nick to prefix symbols
+	Members map[string]string
+}
+
+// Upgraded reports whether this process was started by an upgrade, and
+// should be created with Resume instead of New.
+func Upgraded() bool { return os.Getenv(upgradeEnv) != "" }
+
+// Upgrade execs the binary of this process again with the same
+// arguments, and hands it every listener and every client that is
+// connected over plain tcp. Clients that are connected over tls or
+// websockets can't be handed over, and are asked to reconnect. Links
+// are closed, and reconnect to the new process.
+//
+// If Upgrade returns nil, s has been shut down.
+func (s *Server) Upgrade() error {
+	if inMemory(s.Datasource) {
+		return errors.New("accounts in an in-memory database would be lost by upgrading")
+	}
+
+	cmd, sock, err := startUpgrade()
+	if err != nil {
+		return err
+	}
+	defer sock.Close()
+
+	if err := s.handOver(sock); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return err
+	}
+	return cmd.Process.Release()
+}
+
+func inMemory(datasource string) bool {
+	return datasource == "" || strings.Contains(datasource, ":memory:") || strings.Contains(datasource, "mode=memory")
+}
+
+// handOver hands s over to the process on the other end of sock. If it
+// returns nil, s has been shut down.
+func (s *Server) handOver(sock *net.UnixConn) error {
+	h := &handover{paused: make(chan *client.Client), done: make(chan struct{})}
+	if !s.handover.CompareAndSwap(nil, h) {
+		return errors.New("an upgrade is already in progress")
+	}
+	defer s.handover.Store(nil)
+
+	if err := expectByte(sock); err != nil {
+		close(h.done)
+		return fmt.Errorf("the new process did not start: %w", err)
+	}
+
+	targets, deadlined := s.pauseReaders(h)
+	s.configLock.Lock()
+	state, files, handed, err := s.snapshot(targets)
+	if err == nil {
+		err = sendState(sock, files, state)
+		if err == nil {
+			err = expectByte(sock)
+		}
+	}
+	for _, f := range files {
+		f.Close()
+	}
+	if err != nil {
+		s.configLock.Unlock()
+		// readers that did not pause in time still have the deadline
+		for c := range deadlined {
+			c.Conn().SetReadDeadline(time.Time{})
+		}
+		close(h.done)
+		return fmt.Errorf("the new process did not take over: %w", err)
+	}
+
+	// the new process owns the handed over connections now. Whatever is
+	// still queued for them is written before it starts serving.
+	var local []*client.Client
+	for _, c := range s.clients.All() {
+		if c.Server == "" {
+			local = append(local, c)
+		}
+	}
+	for _, c := range local {
+		if !handed[c] {
+			s.quit(c, upgradeReason, nil)
+			continue
+		}
+		for _, conn := range connsOf(c) {
+			if _, ok := targets[conn]; !ok {
+				conn.WriteToConn(msg.New(nil, "", "", "", "ERROR", []string{upgradeReason}, true))
+			}
+			conn.Close()
+		}
+	}
+	s.configLock.Unlock()
+
+	h.handedOver = true
+	close(h.done)
+	s.logger.Info("upgraded", "clients", len(state.Clients), "channels", len(state.Channels))
+	return s.shutdown("Server upgrading")
+}
+
+// connsOf returns every connection of c, including its own unless it is
+// detached.
+func connsOf(c *client.Client) []*client.Client {
+	conns := c.Sessions()
+	if !c.Detached() {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+// handoverConn returns the tcp connection of c, if it can be handed
+// over. tls and websocket connections keep their state in this
+// process, so they can't be.
+func handoverConn(c *client.Client) (*net.TCPConn, bool) {
+	m, ok := c.Conn().(meteredConn)
+	if !ok {
+		return nil, false
+	}
+	switch conn := m.Conn.(type) {
+	case *net.TCPConn:
+		return conn, true
+	case *proxyproto.Conn:
+		return conn.TCPConn()
+	case proxiedConn:
+		tcp, ok := conn.Conn.(*net.TCPConn)
+		return tcp, ok
+	}
+	return nil, false
+}
+
+// pauseReaders pauses the reader of every local connection that can be
+// handed over. It returns the connections that paused in time, along
+// with every connection that was given a read deadline to pause it.
+func (s *Server) pauseReaders(h *handover) (paused, targets map[*client.Client]*net.TCPConn) {
+	targets = make(map[*client.Client]*net.TCPConn)
+	for _, c := range s.clients.All() {
+		if c.Server != "" {
+			continue
+		}
+		for _, conn := range connsOf(c) {
+			if tcp, ok := handoverConn(conn); ok {
+				targets[conn] = tcp
+			}
+		}
+	}
+
+	// readers stop at the first read that would block, after they have
+	// executed every message that they already read
+	for c := range targets {
+		c.Conn().SetReadDeadline(time.Now())
+	}
+
+	paused = make(map[*client.Client]*net.TCPConn, len(targets))
+	timeout := time.After(upgradeTimeout)
+	for len(paused) < len(targets) {
+		select {
+		case c := <-h.paused:
+			if tcp, ok := targets[c]; ok {
+				paused[c] = tcp
+			}
+		case <-timeout:
+			return paused, targets
+		}
+	}
+	return paused, targets
+}
+
+// snapshot collects the state of s and the sockets that are handed over
+// with it. Only the connections in conns are handed over; handed holds
+// every client that is handed over with at least one of them, or that
+// is kept online without any. The caller must hold configLock.
+func (s *Server) snapshot(conns map[*client.Client]*net.TCPConn) (state *handoverState, files []*os.File, handed map[*client.Client]bool, err error) {
+	defer func() {
+		if err != nil {
+			for _, f := range files {
+				f.Close()
+			}
+		}
+	}()
+
+	state = &handoverState{
+		Created:   s.created,
+		Max:       s.max.Get(),
+		Listeners: make(map[string]int),
+		Monitor:   make(map[string][]string),
+	}
+
+	for _, spec := range s.listenerSpecs(s.Config) {
+		var tcp *net.TCPListener
+		switch l := (*spec.field).(type) {
+		case *net.TCPListener:
+			tcp = l
+		case tcpListener:
+			tcp = l.tcp
+		default:
+			continue
+		}
+		f, err := tcp.File()
+		if err != nil {
+			return nil, files, nil, err
+		}
+		state.Listeners[spec.addr] = len(files)
+		files = append(files, f)
+	}
+
+	addConn := func(c *client.Client) (*connState, error) {
+		tcp, ok := conns[c]
+		if !ok {
+			return nil, nil
+		}
+		f, err := tcp.File()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+
+		st := &connState{
+			File:       len(files) - 1,
+			RemoteAddr: c.RemoteAddr().String(),
+			JoinTime:   c.JoinTime,
+			CapVersion: c.CapVersion,
+		}
+		for name, enabled := range c.Caps {
+			if enabled {
+				st.Caps = append(st.Caps, name)
+			}
+		}
+		return st, nil
+	}
+
+	handed = make(map[*client.Client]bool)
+	for _, c := range s.clients.All() {
+		// remote clients come back once the links reconnect
+		if c.Server != "" {
+			continue
+		}
+
+		st := clientState{
+			Nick: c.Nick, User: c.User, Realname: c.Realname,
+			Host: c.Host, RealHost: c.RealHost,
+			JoinTime: c.JoinTime, Idle: c.IdleTime().Unix(),
+			Mode: c.Mode, Snomask: c.Snomask(),
+			AwayMsg: c.AwayMsg, OperClass: c.OperClass,
+			ServerPassAccepted: c.ServerPassAccepted,
+		}
+		if c.IsAuthenticated {
+			st.Account = c.SASLMech.Authn()
+		}
+
+		if !c.Detached() {
+			if st.Conn, err = addConn(c); err != nil {
+				return nil, files, nil, err
+			}
+		}
+		for _, session := range c.Sessions() {
+			conn, err := addConn(session)
+			if err != nil {
+				return nil, files, nil, err
+			}
+			if conn != nil {
+				st.Sessions = append(st.Sessions, *conn)
+			}
+		}
+
+		alwaysOn := s.Bouncer.Enabled && s.Bouncer.AlwaysOn && c.IsAuthenticated
+		if st.Conn != nil || len(st.Sessions) > 0 || alwaysOn {
+			handed[c] = true
+			state.Clients = append(state.Clients, st)
+		}
+	}
+
+	for _, ch := range s.channels.All() {
+		st := channelState{
+			Name:         ch.String(),
+			Created:      ch.CreatedAt,
+			Topic:        ch.Topic,
+			TopicSetAt:   ch.TopicSetAt,
+			Key:          ch.Key,
+			Limit:        ch.Limit,
+			Flags:        channelFlags(ch),
+			Flood:        ch.FloodParam(),
+			JoinThrottle: ch.JoinThrottleParam(),
+			Forward:      ch.Forward,
+			Lists:        map[string][]channel.ListEntry{"b": ch.List('b'), "e": ch.List('e'), "I": ch.List('I')},
+			Registered:   ch.Registered,
+			Founder:      ch.Founder,
+			Access:       make(map[string]string),
+			Invited:      ch.Invited,
+			Members:      make(map[string]string),
+		}
+		if ch.TopicSetBy != nil {
+			st.TopicSetBy = ch.TopicSetBy.String()
+		}
+		for mask, p := range ch.Access {
+			st.Access[mask] = p.String()
+		}
+		for m := range ch.All() {
+			if handed[m.Client] {
+				st.Members[m.Nick] = m.HighestPrefix(true)
+			}
+		}
+		// channels without any members that are handed over come back
+		// once the links reconnect
+		if len(st.Members) > 0 || ch.Registered {
+			state.Channels = append(state.Channels, st)
+		}
+	}
+
+	s.monitor.rwLock.RLock()
+	for target, observers := range s.monitor.m {
+		for o := range observers {
+			state.Monitor[target] = append(state.Monitor[target], o)
+		}
+	}
+	s.monitor.rwLock.RUnlock()
+
+	return state, files, handed, nil
+}
+
+// sendState sends files followed by state over sock.
+func sendState(sock *net.UnixConn, files []*os.File, state *handoverState) error {
+	sock.SetWriteDeadline(time.Now().Add(upgradeTimeout))
+	defer sock.SetWriteDeadline(time.Time{})
+
+	if err := sendFiles(sock, files); err != nil {
+		return err
+	}
+	return json.NewEncoder(sock).Encode(state)
+}
+
+// receiveState receives what sendState sent.
+func receiveState(sock *net.UnixConn) ([]*os.File, *handoverState, error) {
+	files, err := receiveFiles(sock)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	state := new(handoverState)
+	if err := json.NewDecoder(sock).Decode(state); err != nil {
+		for _, f := range files {
+			f.Close()
+		}
+		return nil, nil, err
+	}
+	return files, state, nil
+}
+
+// expectByte waits for the other end of sock to send a byte.
+func expectByte(sock *net.UnixConn) error {
+	sock.SetReadDeadline(time.Now().Add(upgradeTimeout))
+	defer sock.SetReadDeadline(time.Time{})
+	_, err := io.ReadFull(sock, make([]byte, 1))
+	return err
+}
+
+// Resume creates a server out of the state that was handed over by the
+// process that started this one with Upgrade. c should be the same
+// config that the old process was started with; listeners that it no
+// longer asks for are closed.
+func Resume(c *Config) (*Server, error) {
+	fd, err := strconv.Atoi(os.Getenv(upgradeEnv))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", upgradeEnv, err)
+	}
+	os.Unsetenv(upgradeEnv)
+
+	f := os.NewFile(uintptr(fd), "upgrade")
+	conn, err := net.FileConn(f)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	sock, ok := conn.(*net.UnixConn)
+	if !ok {
+		conn.Close()
+		return nil, errors.New("the upgrade socket is not a unix socket")
+	}
+	defer sock.Close()
+	return resume(c, sock)
+}
+
+func resume(c *Config, sock *net.UnixConn) (_ *Server, err error) {
+	s, err := newServer(c)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			s.discard()
+		}
+	}()
+	if _, err := sock.Write([]byte{0}); err != nil {
+		return nil, err
+	}
+
+	files, state, err := receiveState(sock)
+	if err != nil {
+		return nil, err
+	}
+	conns, err := s.restore(state, files)
+	for _, f := range files {
+		f.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := sock.Write([]byte{0}); err != nil {
+		return nil, err
+	}
+	// the old process closes the socket once it has finished writing to
+	// the connections that it handed over
+	io.Copy(io.Discard, sock)
+
+	for _, rc := range conns {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer rc.cancel()
+			s.serveClient(rc.c, rc.ctx)
+		}()
+	}
+	s.logger.Info("resumed", "clients", len(state.Clients), "channels", len(state.Channels))
+	return s, nil
+}
+
+// A restoredConn is a connection that was handed over, and is served
+// once the old process is done with it.
+type restoredConn struct {
+	c      *client.Client
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// restore rebuilds the state that was handed over, and opens the
+// listeners of s using the ones that were handed over.
+func (s *Server) restore(state *handoverState, files []*os.File) ([]restoredConn, error) {
+	file := func(i int) (*os.File, error) {
+		if i < 0 || i >= len(files) {
+			return nil, fmt.Errorf("no file %d was handed over", i)
+		}
+		return files[i], nil
+	}
+
+	s.created = state.Created
+	s.max.KeepMax(state.Max)
+
+	s.inherited = make(map[string]*net.TCPListener)
+	for addr, i := range state.Listeners {
+		f, err := file(i)
+		if err != nil {
+			return nil, err
+		}
+		l, err := net.FileListener(f)
+		if err != nil {
+			return nil, err
+		}
+		s.inherited[addr] = l.(*net.TCPListener)
+	}
+	err := s.openListeners()
+	// listeners that the config no longer asks for
+	for _, l := range s.inherited {
+		l.Close()
+	}
+	s.inherited = nil
+	if err != nil {
+		return nil, err
+	}
+
+	var conns []restoredConn
+	restoreConn := func(st connState) (*client.Client, error) {
+		f, err := file(st.File)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := net.FileConn(f)
+		if err != nil {
+			return nil, err
+		}
+		// connections from a proxy keep the address that it sent
+		if st.RemoteAddr != conn.RemoteAddr().String() {
+			addr, err := net.ResolveTCPAddr("tcp", st.RemoteAddr)
+			if err != nil {
+				conn.Close()
+				return nil, err
+			}
+			conn = proxiedConn{conn, addr}
+		}
+
+		c, ctx, cancel := client.Restore(meteredConn{conn, &s.metrics}, s.ctx)
+		c.JoinTime = st.JoinTime
+		for _, name := range st.Caps {
+			c.Caps[name] = true
+		}
+		c.CapVersion = st.CapVersion
+		conns = append(conns, restoredConn{c, ctx, cancel})
+		return c, nil
+	}
+
+	for _, st := range state.Clients {
+		var c *client.Client
+		if st.Conn != nil {
+			var err error
+			if c, err = restoreConn(*st.Conn); err != nil {
+				return nil, err
+			}
+		} else {
+			// a detached client has no connection of its own
+			conn, _ := net.Pipe()
+			conn.Close()
+			c, _, _ = client.Restore(conn, s.ctx)
+			c.Close()
+			c.Detach(c, true)
+		}
+
+		c.Nick, c.User, c.Realname = st.Nick, st.User, st.Realname
+		c.Host, c.RealHost = st.Host, st.RealHost
+		c.JoinTime = st.JoinTime
+		c.UpdateIdleTime(time.Unix(st.Idle, 0))
+		c.SetMode(st.Mode)
+		c.SetSnomask(st.Snomask)
+		c.AwayMsg, c.OperClass = st.AwayMsg, st.OperClass
+		c.ServerPassAccepted = st.ServerPassAccepted
+		if st.Account != "" {
+			c.SASLMech = sasl.Account(st.Account)
+			c.IsAuthenticated = true
+		}
+
+		for _, sessionState := range st.Sessions {
+			session, err := restoreConn(sessionState)
+			if err != nil {
+				return nil, err
+			}
+			session.SASLMech, session.IsAuthenticated = c.SASLMech, c.IsAuthenticated
+			c.Attach(session)
+		}
+		s.setClient(c)
+	}
+
+	for _, st := range state.Channels {
+		if !isValidChannelString(st.Name) {
+			continue
+		}
+		ch := channel.New(st.Name[1:], channel.ChanType(st.Name[0]))
+		ch.CreatedAt = st.Created
+		if st.Topic != "" {
+			ch.Topic = st.Topic
+			ch.TopicSetBy = topicSetter(st.TopicSetBy)
+			ch.TopicSetAt = st.TopicSetAt
+		}
+		ch.Key = st.Key
+		ch.Limit = st.Limit
+		setChannelFlags(ch, st.Flags)
+		ch.Forward = st.Forward
+		if st.Flood != "" {
+			ch.ApplyMode(mode.Mode{Type: mode.Add, ModeChar: 'f', Param: st.Flood})
+		}
+		if st.JoinThrottle != "" {
+			ch.ApplyMode(mode.Mode{Type: mode.Add, ModeChar: 'j', Param: st.JoinThrottle})
+		}
+		for char, list := range st.Lists {
+			for _, e := range list {
+				if len(char) == 1 {
+					ch.AddListEntry(char[0], e)
+				}
+			}
+		}
+		ch.Registered = st.Registered
+		ch.Founder = st.Founder
+		for mask, sym := range st.Access {
+			if len(sym) != 1 {
+				continue
+			}
+			if p, ok := channel.MemberPrefix[sym[0]]; ok {
+				ch.SetAccess(mask, p)
+			}
+		}
+		ch.Invited = st.Invited
+
+		for nick, prefixes := range st.Members {
+			c, ok := s.getClient(nick)
+			if !ok {
+				continue
+			}
+			m := &channel.Member{Client: c}
+			for i := range len(prefixes) {
+				m.Prefix |= channel.MemberPrefix[prefixes[i]]
+			}
+			ch.SetMember(m)
+		}
+		s.setChannel(ch)
+
+		for char, list := range st.Lists {
+			for _, e := range list {
+				s.scheduleExpiry(ch, char[0], e.Mask)
+			}
+		}
+	}
+
+	for target, observers := range state.Monitor {
+		for _, o := range observers {
+			s.monitor.observe(target, o)
+		}
+	}
+	return conns, nil
+}
+
+// A proxiedConn is a connection that was accepted from a proxy before
+// it was handed over. It keeps the address of the client that the
+// proxy sent.
+type proxiedConn struct {
+	net.Conn
+	remoteAddr net.Addr
+}
+
+func (p proxiedConn) RemoteAddr() net.Addr { return p.remoteAddr }
@@ -0,0 +1,16 @@
+//go:build !unix
+
+package server
+
+import (
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+)
+
+func startUpgrade() (*exec.Cmd, *net.UnixConn, error) { return nil, nil, errors.ErrUnsupported }
+
+func sendFiles(*net.UnixConn, []*os.File) error { return errors.ErrUnsupported }
+
+func receiveFiles(*net.UnixConn) ([]*os.File, error) { return nil, errors.ErrUnsupported }
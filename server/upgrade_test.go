@@ -0,0 +1,189 @@
+//go:build unix
+
+package server
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/mitchr/gossip/channel"
+	"github.com/mitchr/gossip/sasl/plain"
+	"github.com/pires/go-proxyproto"
+)
+
+// socketpair returns both ends of a connected unix socket.
+func socketpair(t *testing.T) (*net.UnixConn, *net.UnixConn) {
+	t.Helper()
+
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var conns [2]*net.UnixConn
+	for i, fd := range fds {
+		f := os.NewFile(uintptr(fd), "socketpair")
+		c, err := net.FileConn(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conns[i] = c.(*net.UnixConn)
+	}
+	return conns[0], conns[1]
+}
+
+func TestUpgrade(t *testing.T) {
+	t.Parallel()
+
+	datasource := filepath.Join(t.TempDir(), "gossip.db")
+	newConf := func() *Config {
+		conf := &Config{Name: "gossip", Port: ":0", Datasource: datasource}
+		conf.Cloak.Disabled = true
+		conf.Proxy.Enabled = true
+		conf.Bouncer.Enabled = true
+		conf.Bouncer.AlwaysOn = true
+		return conf
+	}
+	old, err := New(newConf())
+	if err != nil {
+		t.Fatal(err)
+	}
+	go old.Serve()
+	for _, account := range []string{"dave", "erin"} {
+		cred := plain.NewCredential(account, "pass")
+		old.persistPlain(cred.Username, account, cred.Pass)
+	}
+
+	alice, aliceR := old.connectAndRegister("alice")
+	defer alice.Close()
+	bob, bobR := old.connectAndRegister("bob")
+	defer bob.Close()
+	alice.Write([]byte("JOIN #test\r\n"))
+	readLines(aliceR, 3)
+	bob.Write([]byte("JOIN #test\r\n"))
+	readLines(bobR, 3)
+	readLines(aliceR, 1)
+
+	// dave stays online without any connections
+	dave, daveR := old.connectAndAuthenticate("dave", "dave", "pass")
+	dave.Write([]byte("JOIN #test\r\n"))
+	readLines(daveR, 3)
+	readLines(aliceR, 1)
+	readLines(bobR, 1)
+	dave.Write([]byte("QUIT\r\n"))
+	readLines(daveR, 1)
+	dave.Close()
+	waitFor(t, func() bool { c, ok := old.getClient("dave"); return ok && c.Detached() })
+
+	// erin is connected twice
+	erin, erinR := old.connectAndAuthenticate("erin", "erin", "pass")
+	defer erin.Close()
+	erinSession, erinSessionR := old.connectAndAuthenticate("erin2", "erin", "pass")
+	defer erinSession.Close()
+
+	// dan connects through a proxy
+	dan, err := net.Dial("tcp", ":"+old.port())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dan.Close()
+	header := proxyproto.HeaderProxyFromAddrs(1, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 2), Port: 5555}, dan.RemoteAddr())
+	header.WriteTo(dan)
+	dan.Write([]byte("NICK dan\r\nUSER dan 0 0 :dan\r\n"))
+	readLines(bufio.NewReader(dan), 14)
+
+	t.Run("Recover", func(t *testing.T) {
+		parent, child := socketpair(t)
+		defer parent.Close()
+
+		// the new process starts, but dies before taking over
+		child.Write([]byte{0})
+		child.Close()
+		if err := old.handOver(parent); err == nil {
+			t.Fatal("expected upgrade to fail")
+		}
+
+		alice.Write([]byte("PRIVMSG #test :still here\r\n"))
+		resp, _ := bobR.ReadBytes('\n')
+		assertResponse(resp, ":alice!alice@localhost PRIVMSG #test :still here\r\n", t)
+	})
+
+	t.Run("HandOver", func(t *testing.T) {
+		addr := old.listener.Addr().String()
+		parent, child := socketpair(t)
+
+		type result struct {
+			s   *Server
+			err error
+		}
+		resumed := make(chan result)
+		go func() {
+			defer child.Close()
+			s, err := resume(newConf(), child)
+			resumed <- result{s, err}
+		}()
+
+		if err := old.handOver(parent); err != nil {
+			t.Fatal(err)
+		}
+		parent.Close()
+		res := <-resumed
+		if res.err != nil {
+			t.Fatal(res.err)
+		}
+		s := res.s
+		defer s.Close()
+		go s.Serve()
+
+		if s.listener.Addr().String() != addr {
+			t.Error("expected listener to be handed over, got", s.listener.Addr())
+		}
+
+		// the connections are served by the new server, which knows about
+		// their channels
+		alice.Write([]byte("PRIVMSG #test :hello\r\n"))
+		resp, _ := bobR.ReadBytes('\n')
+		assertResponse(resp, ":alice!alice@localhost PRIVMSG #test :hello\r\n", t)
+
+		ch, _ := s.getChannel("#test")
+		if m, ok := ch.GetMember("alice"); !ok || !m.Is(channel.Operator) {
+			t.Error("expected alice to still be an operator of #test")
+		}
+
+		if c, ok := s.getClient("dave"); !ok || !c.Detached() {
+			t.Error("expected dave to stay online without a connection")
+		}
+		if _, ok := ch.GetMember("dave"); !ok {
+			t.Error("expected dave to still be in #test")
+		}
+
+		if c, ok := s.getClient("erin"); !ok || len(c.Sessions()) != 1 {
+			t.Fatal("expected the session of erin to stay attached")
+		}
+		bob.Write([]byte("PRIVMSG erin :hi\r\n"))
+		for _, r := range []*bufio.Reader{erinR, erinSessionR} {
+			resp, _ := r.ReadBytes('\n')
+			assertResponse(resp, ":bob!bob@localhost PRIVMSG erin :hi\r\n", t)
+		}
+
+		if c, ok := s.getClient("dan"); !ok || c.RemoteAddr().String() != "127.0.0.2:5555" {
+			t.Error("expected dan to keep the address given by the proxy")
+		}
+		dan.Write([]byte("PRIVMSG bob :hey\r\n"))
+		resp, _ = bobR.ReadBytes('\n')
+		if !strings.HasPrefix(string(resp), ":dan!dan@") {
+			t.Error("expected dan to still be served, got", string(resp))
+		}
+
+		carol, _ := s.connectAndRegister("carol")
+		defer carol.Close()
+		if _, ok := s.getClient("carol"); !ok {
+			t.Error("expected new connections to be accepted")
+		}
+	})
+}
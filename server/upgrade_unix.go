@@ -0,0 +1,116 @@
+//go:build unix
+
+package server
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"slices"
+	"syscall"
+)
+
+// how many descriptors are sent in one message; Linux allows at most 253
+const filesPerMessage = 128
+
+// startUpgrade starts the new process, and returns the socket that it
+// is handed over on.
+func startUpgrade() (*exec.Cmd, *net.UnixConn, error) {
+	path, err := os.Executable()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	syscall.ForkLock.RLock()
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err == nil {
+		syscall.CloseOnExec(fds[0])
+		syscall.CloseOnExec(fds[1])
+	}
+	syscall.ForkLock.RUnlock()
+	if err != nil {
+		return nil, nil, err
+	}
+	local := os.NewFile(uintptr(fds[0]), "upgrade")
+	remote := os.NewFile(uintptr(fds[1]), "upgrade")
+	defer local.Close()
+	defer remote.Close()
+
+	conn, err := net.FileConn(local)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cmd := exec.Command(path, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	// the first extra file is descriptor 3
+	cmd.ExtraFiles = []*os.File{remote}
+	cmd.Env = append(os.Environ(), upgradeEnv+"=3")
+	if err := cmd.Start(); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return cmd, conn.(*net.UnixConn), nil
+}
+
+// sendFiles sends the number of files over sock, followed by the files
+// in batches of filesPerMessage, each attached to a single byte.
+func sendFiles(sock *net.UnixConn, files []*os.File) error {
+	if err := binary.Write(sock, binary.BigEndian, uint32(len(files))); err != nil {
+		return err
+	}
+	for batch := range slices.Chunk(files, filesPerMessage) {
+		fds := make([]int, len(batch))
+		for i, f := range batch {
+			fds[i] = int(f.Fd())
+		}
+		if _, _, err := sock.WriteMsgUnix([]byte{0}, syscall.UnixRights(fds...), nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// receiveFiles receives what sendFiles sent.
+func receiveFiles(sock *net.UnixConn) ([]*os.File, error) {
+	var files []*os.File
+	fail := func(err error) ([]*os.File, error) {
+		for _, f := range files {
+			f.Close()
+		}
+		return nil, err
+	}
+
+	var n uint32
+	if err := binary.Read(sock, binary.BigEndian, &n); err != nil {
+		return nil, err
+	}
+	b := make([]byte, 1)
+	oob := make([]byte, syscall.CmsgSpace(filesPerMessage*4))
+	for uint32(len(files)) < n {
+		_, oobn, flags, _, err := sock.ReadMsgUnix(b, oob)
+		if err != nil {
+			return fail(err)
+		}
+		if flags&syscall.MSG_CTRUNC != 0 {
+			return fail(errors.New("descriptors were truncated"))
+		}
+
+		msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+		if err != nil {
+			return fail(err)
+		}
+		for _, m := range msgs {
+			fds, err := syscall.ParseUnixRights(&m)
+			if err != nil {
+				return fail(err)
+			}
+			for _, fd := range fds {
+				files = append(files, os.NewFile(uintptr(fd), "handover"))
+			}
+		}
+	}
+	return files, nil
+}
@@ -0,0 +1,11 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// the signals that upgrade the server to a new binary
+var upgradeSignals = []os.Signal{syscall.SIGUSR2}
@@ -0,0 +1,67 @@
+package websocket
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Listener accepts WebSocket connections from an HTTP server that runs
+// on top of another listener.
+type Listener struct {
+	l      net.Listener
+	server *http.Server
+
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Listen starts serving WebSocket handshakes on l. If origins is not
+// empty, only browsers from those origins may connect.
+func Listen(l net.Listener, origins []string) *Listener {
+	w := &Listener{
+		l:     l,
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+	w.server = &http.Server{
+		Handler: http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			c, err := Upgrade(rw, r, origins)
+			if err != nil {
+				return
+			}
+
+			select {
+			case w.conns <- c:
+			case <-w.done:
+				c.Close()
+			}
+		}),
+		ReadHeaderTimeout: time.Second * 10,
+	}
+
+	go w.server.Serve(l)
+	return w
+}
+
+func (w *Listener) Accept() (net.Conn, error) {
+	select {
+	case c := <-w.conns:
+		return c, nil
+	case <-w.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (w *Listener) Close() error {
+	err := net.ErrClosed
+	w.closeOnce.Do(func() {
+		close(w.done)
+		err = w.server.Close()
+	})
+	return err
+}
+
+func (w *Listener) Addr() net.Addr { return w.l.Addr() }
@@ -0,0 +1,373 @@
+// Package websocket implements the server side of the IRCv3 WebSocket
+// transport (https://ircv3.net/specs/extensions/websocket). Each IRC
+// line is carried in its own WebSocket message, and sockets are exposed
+// as a net.Conn that reads and writes ordinary CRLF terminated lines.
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// Text is the subprotocol that sends every line as a UTF-8 text
+	// message.
+	Text = "text.ircv3.net"
+
+	// Binary is the subprotocol that sends every line as a binary
+	// message.
+	Binary = "binary.ircv3.net"
+)
+
+// the GUID that is appended to a client's key when computing
+// Sec-WebSocket-Accept (RFC 6455 section 1.3)
+const keyGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// the largest message that a client may send; this is comfortably
+// larger than a line with a full set of tags
+const maxMessageSize = 1 << 14
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+// close status codes from RFC 6455 section 7.4.1
+const (
+	closeNormal        = 1000
+	closeProtocolError = 1002
+	closeInvalidData   = 1007
+	closeTooBig        = 1009
+)
+
+var (
+	ErrBadHandshake = errors.New("websocket: bad handshake")
+	ErrBadOrigin    = errors.New("websocket: origin not allowed")
+	ErrProtocol     = errors.New("websocket: protocol error")
+	ErrTooBig       = errors.New("websocket: message too large")
+)
+
+// Conn is a WebSocket connection that reads and writes IRC lines.
+type Conn struct {
+	net.Conn
+	r *bufio.Reader
+
+	// the negotiated subprotocol; this is empty if the client did not
+	// ask for one, in which case lines are sent as text
+	Subprotocol string
+
+	// the remainder of the last message that was received
+	pending []byte
+
+	writeLock sync.Mutex
+	closeOnce sync.Once
+}
+
+// Upgrade completes the WebSocket handshake for r. If origins is not
+// empty, the Origin header of r must match one of them.
+func Upgrade(w http.ResponseWriter, r *http.Request, origins []string) (*Conn, error) {
+	if r.Method != http.MethodGet ||
+		!headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") ||
+		r.Header.Get("Sec-WebSocket-Version") != "13" {
+		http.Error(w, ErrBadHandshake.Error(), http.StatusBadRequest)
+		return nil, ErrBadHandshake
+	}
+
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		http.Error(w, ErrBadHandshake.Error(), http.StatusBadRequest)
+		return nil, ErrBadHandshake
+	}
+
+	if !originAllowed(r.Header.Get("Origin"), origins) {
+		http.Error(w, ErrBadOrigin.Error(), http.StatusForbidden)
+		return nil, ErrBadOrigin
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, ErrBadHandshake.Error(), http.StatusInternalServerError)
+		return nil, ErrBadHandshake
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	// the http server may have left a deadline on the connection
+	conn.SetDeadline(time.Time{})
+
+	c := &Conn{Conn: conn, r: rw.Reader, Subprotocol: chooseSubprotocol(r.Header)}
+
+	resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n"
+	if c.Subprotocol != "" {
+		resp += "Sec-WebSocket-Protocol: " + c.Subprotocol + "\r\n"
+	}
+	resp += "\r\n"
+
+	if _, err := conn.Write([]byte(resp)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + keyGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// headerContains returns true if one of the comma separated values of
+// header is token.
+func headerContains(h http.Header, header, token string) bool {
+	for _, v := range h.Values(header) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func originAllowed(origin string, origins []string) bool {
+	if len(origins) == 0 {
+		return true
+	}
+	for _, v := range origins {
+		if v == "*" || strings.EqualFold(v, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// chooseSubprotocol picks the first subprotocol offered by the client
+// that we support.
+func chooseSubprotocol(h http.Header) string {
+	for _, v := range h.Values("Sec-WebSocket-Protocol") {
+		for _, p := range strings.Split(v, ",") {
+			p = strings.TrimSpace(p)
+			if p == Text || p == Binary {
+				return p
+			}
+		}
+	}
+	return ""
+}
+
+// NetConn returns the connection that c is running on top of.
+func (c *Conn) NetConn() net.Conn { return c.Conn }
+
+// Read reads the next IRC line sent by the client. Every message is
+// returned with a trailing CRLF, as the transport does not include one.
+func (c *Conn) Read(b []byte) (int, error) {
+	for len(c.pending) == 0 {
+		m, err := c.readMessage()
+		if err != nil {
+			return 0, err
+		}
+		if len(m) == 0 {
+			continue
+		}
+
+		if m[len(m)-1] != '\n' {
+			m = append(m, '\r', '\n')
+		}
+		c.pending = m
+	}
+
+	n := copy(b, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// readMessage reads frames until it has a complete data message,
+// answering any control frames along the way.
+func (c *Conn) readMessage() ([]byte, error) {
+	var message []byte
+	var opcode byte
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+
+		switch op {
+		case opPing:
+			c.writeFrame(opPong, payload)
+			continue
+		case opPong:
+			continue
+		case opClose:
+			// echo the status code back to the client
+			c.closeWith(payload[:min(len(payload), 2)])
+			return nil, io.EOF
+		case opText, opBinary:
+			if opcode != 0 {
+				return nil, c.fail(closeProtocolError, ErrProtocol)
+			}
+			opcode = op
+		case opContinuation:
+			if opcode == 0 {
+				return nil, c.fail(closeProtocolError, ErrProtocol)
+			}
+		default:
+			return nil, c.fail(closeProtocolError, ErrProtocol)
+		}
+
+		if len(message)+len(payload) > maxMessageSize {
+			return nil, c.fail(closeTooBig, ErrTooBig)
+		}
+		message = append(message, payload...)
+
+		if fin {
+			if opcode == opText && !utf8.Valid(message) {
+				return nil, c.fail(closeInvalidData, ErrProtocol)
+			}
+			return message, nil
+		}
+	}
+}
+
+func (c *Conn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	var header [2]byte
+	if _, err = io.ReadFull(c.r, header[:]); err != nil {
+		return
+	}
+
+	fin = header[0]&0x80 != 0
+	opcode = header[0] & 0x0f
+	masked := header[1]&0x80 != 0
+
+	// reserved bits must be unset, and clients must mask everything they
+	// send
+	if header[0]&0x70 != 0 || !masked {
+		err = c.fail(closeProtocolError, ErrProtocol)
+		return
+	}
+
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.r, ext[:]); err != nil {
+			return
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.r, ext[:]); err != nil {
+			return
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+
+	// control frames can't be fragmented or be longer than 125 bytes
+	if opcode >= opClose && (!fin || length > 125) {
+		err = c.fail(closeProtocolError, ErrProtocol)
+		return
+	}
+	if length > maxMessageSize {
+		err = c.fail(closeTooBig, ErrTooBig)
+		return
+	}
+
+	var mask [4]byte
+	if _, err = io.ReadFull(c.r, mask[:]); err != nil {
+		return
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(c.r, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return
+}
+
+// fail closes c with the given status code, and returns err.
+func (c *Conn) fail(code uint16, err error) error {
+	c.closeWith(binary.BigEndian.AppendUint16(nil, code))
+	return err
+}
+
+// Write sends every line in b as its own message, without its CRLF.
+// When using the text subprotocol, invalid UTF-8 is replaced.
+func (c *Conn) Write(b []byte) (int, error) {
+	opcode := byte(opText)
+	if c.Subprotocol == Binary {
+		opcode = opBinary
+	}
+
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if line == "" {
+			continue
+		}
+		if opcode == opText {
+			line = strings.ToValidUTF8(line, "\uFFFD")
+		}
+		if err := c.writeFrame(opcode, []byte(line)); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// writeFrame sends payload in a single unmasked frame.
+func (c *Conn) writeFrame(opcode byte, payload []byte) error {
+	frame := make([]byte, 0, len(payload)+10)
+	frame = append(frame, 0x80|opcode)
+
+	switch l := len(payload); {
+	case l < 126:
+		frame = append(frame, byte(l))
+	case l <= 0xffff:
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(l))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(l))
+	}
+	frame = append(frame, payload...)
+
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	_, err := c.Conn.Write(frame)
+	return err
+}
+
+// Close sends a close frame to the client and closes the underlying
+// connection.
+func (c *Conn) Close() error {
+	return c.closeWith(binary.BigEndian.AppendUint16(nil, closeNormal))
+}
+
+func (c *Conn) closeWith(status []byte) error {
+	err := net.ErrClosed
+	c.closeOnce.Do(func() {
+		c.Conn.SetWriteDeadline(time.Now().Add(time.Second))
+		c.writeFrame(opClose, status)
+		err = c.Conn.Close()
+	})
+	return err
+}
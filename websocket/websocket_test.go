@@ -0,0 +1,166 @@
+package websocket
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAcceptKey(t *testing.T) {
+	// example from RFC 6455 section 1.3
+	if k := acceptKey("dGhlIHNhbXBsZSBub25jZQ=="); k != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Error("got", k)
+	}
+}
+
+func TestListener(t *testing.T) {
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := Listen(l, []string{"https://example.com"})
+	defer w.Close()
+
+	t.Run("BadOrigin", func(t *testing.T) {
+		_, r := dial(t, w.Addr().String(), "https://evil.com", Text)
+		status, _ := r.ReadString('\n')
+		if !strings.Contains(status, "403") {
+			t.Error("expected 403, got", status)
+		}
+	})
+
+	t.Run("Text", func(t *testing.T) {
+		client, r := dial(t, w.Addr().String(), "https://example.com", "foo, "+Text)
+		defer client.Close()
+		resp := readHandshake(r)
+		if resp.Header.Get("Sec-WebSocket-Protocol") != Text {
+			t.Error("expected text subprotocol, got", resp.Header.Get("Sec-WebSocket-Protocol"))
+		}
+
+		server, _ := w.Accept()
+		defer server.Close()
+
+		// a fragmented message with a ping in the middle of it
+		writeFrame(client, false, opText, []byte("PRIVMSG #test "))
+		writeFrame(client, true, opPing, []byte("hey"))
+		writeFrame(client, true, opContinuation, []byte(":hi"))
+
+		line, _ := bufio.NewReader(server).ReadString('\n')
+		if line != "PRIVMSG #test :hi\r\n" {
+			t.Errorf("got %q", line)
+		}
+
+		op, payload := readFrame(r)
+		if op != opPong || string(payload) != "hey" {
+			t.Error("expected pong, got", op, string(payload))
+		}
+
+		server.Write([]byte("PING a\r\nPING \xffb\r\n"))
+		op, payload = readFrame(r)
+		if op != opText || string(payload) != "PING a" {
+			t.Errorf("got %d %q", op, payload)
+		}
+		op, payload = readFrame(r)
+		if op != opText || string(payload) != "PING �b" {
+			t.Errorf("got %d %q", op, payload)
+		}
+	})
+
+	t.Run("Binary", func(t *testing.T) {
+		client, r := dial(t, w.Addr().String(), "https://example.com", Binary)
+		defer client.Close()
+		readHandshake(r)
+
+		server, _ := w.Accept()
+		defer server.Close()
+
+		server.Write([]byte("PING \xff\r\n"))
+		op, payload := readFrame(r)
+		if op != opBinary || string(payload) != "PING \xff" {
+			t.Errorf("got %d %q", op, payload)
+		}
+	})
+
+	t.Run("Close", func(t *testing.T) {
+		client, r := dial(t, w.Addr().String(), "https://example.com", Text)
+		defer client.Close()
+		readHandshake(r)
+
+		server, _ := w.Accept()
+		defer server.Close()
+
+		writeFrame(client, true, opClose, binary.BigEndian.AppendUint16(nil, closeNormal))
+		if _, err := server.Read(make([]byte, 10)); err != io.EOF {
+			t.Error("expected EOF, got", err)
+		}
+		op, _ := readFrame(r)
+		if op != opClose {
+			t.Error("expected close frame, got", op)
+		}
+	})
+
+	t.Run("Unmasked", func(t *testing.T) {
+		client, r := dial(t, w.Addr().String(), "https://example.com", Text)
+		defer client.Close()
+		readHandshake(r)
+
+		server, _ := w.Accept()
+		defer server.Close()
+
+		client.Write([]byte{0x80 | opText, 4, 'P', 'I', 'N', 'G'})
+		if _, err := server.Read(make([]byte, 10)); err != ErrProtocol {
+			t.Error("expected protocol error, got", err)
+		}
+	})
+}
+
+func dial(t *testing.T, addr, origin, protocols string) (net.Conn, *bufio.Reader) {
+	t.Helper()
+
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Write([]byte("GET / HTTP/1.1\r\n" +
+		"Host: " + addr + "\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+		"Sec-WebSocket-Version: 13\r\n" +
+		"Sec-WebSocket-Protocol: " + protocols + "\r\n" +
+		"Origin: " + origin + "\r\n\r\n"))
+	return c, bufio.NewReader(c)
+}
+
+func readHandshake(r *bufio.Reader) *http.Response {
+	resp, _ := http.ReadResponse(r, nil)
+	return resp
+}
+
+// writeFrame sends a masked frame, as a client would.
+func writeFrame(w io.Writer, fin bool, opcode byte, payload []byte) {
+	b := opcode
+	if fin {
+		b |= 0x80
+	}
+	mask := []byte{1, 2, 3, 4}
+	frame := []byte{b, 0x80 | byte(len(payload))}
+	frame = append(frame, mask...)
+	for i, v := range payload {
+		frame = append(frame, v^mask[i%4])
+	}
+	w.Write(frame)
+}
+
+// readFrame reads a short unmasked frame from the server.
+func readFrame(r *bufio.Reader) (byte, []byte) {
+	header := make([]byte, 2)
+	io.ReadFull(r, header)
+	payload := make([]byte, header[1]&0x7f)
+	io.ReadFull(r, payload)
+	return header[0] & 0x0f, payload
+}